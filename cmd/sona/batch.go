@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/thewh1teagle/sona/internal/audio"
+	"github.com/thewh1teagle/sona/internal/transcript"
+	"github.com/thewh1teagle/sona/internal/whisper"
+)
+
+// audioExts are the files picked up when a batch input is a directory.
+// Files named directly or by a glob are transcribed whatever their
+// extension.
+var audioExts = []string{
+	".wav", ".mp3", ".m4a", ".flac", ".ogg", ".opus", ".aac", ".wma",
+	".webm", ".mp4", ".mkv", ".mov", ".avi",
+}
+
+// batchFile is an input file and where its outputs go, without extension.
+type batchFile struct {
+	path string
+	out  string
+}
+
+func (a *app) newBatchCommand() *cobra.Command {
+	var f transcribeFlags
+	var formats []string
+	var outputDir string
+	var overwrite bool
+
+	cmd := &cobra.Command{
+		Use:   "batch <model.bin> <inputs...>",
+		Short: "Transcribe many audio files with one model",
+		Long: "Transcribe many audio files, loading the model once. Inputs are audio files,\n" +
+			"directories (searched recursively for audio files) or glob patterns. Outputs\n" +
+			"are written next to each input, or under --output-dir, as <name>.txt, .json,\n" +
+			".verbose.json, .srt, .vtt, .tsv or .lrc. Files whose outputs all exist are\n" +
+			"skipped.",
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			modelPath := args[0]
+			audio.SetVerbose(a.verbose)
+			whisper.SetVerbose(a.verbose)
+			if err := f.validate(); err != nil {
+				return err
+			}
+			if err := validateFormats(formats); err != nil {
+				return err
+			}
+
+			files, err := collectBatchFiles(args[1:], outputDir)
+			if err != nil {
+				return err
+			}
+			if len(files) == 0 {
+				return fmt.Errorf("no audio files found")
+			}
+
+			ctx, err := whisper.New(modelPath, f.gpuDevice, false)
+			if err != nil {
+				return fmt.Errorf("error loading model: %w", err)
+			}
+			defer ctx.Close()
+
+			opts := f.options(a.verbose)
+			log := cmd.ErrOrStderr()
+			var done, skipped int
+			var failures []string
+			for i, file := range files {
+				outputs := make([]string, len(formats))
+				for j, format := range formats {
+					outputs[j] = file.out + transcript.Extensions[format]
+				}
+				if !overwrite && allExist(outputs) {
+					skipped++
+					continue
+				}
+				fmt.Fprintf(log, "[%d/%d] %s\n", i+1, len(files), file.path)
+				if err := f.transcribeBatchFile(ctx, opts, file.path, formats, outputs); err != nil {
+					fmt.Fprintf(log, "  %v\n", err)
+					failures = append(failures, fmt.Sprintf("%s: %v", file.path, err))
+					continue
+				}
+				done++
+			}
+
+			fmt.Fprintf(log, "%d transcribed, %d skipped, %d failed\n", done, skipped, len(failures))
+			for _, failure := range failures {
+				fmt.Fprintf(log, "  %s\n", failure)
+			}
+			if len(failures) > 0 {
+				return fmt.Errorf("%d of %d files failed", len(failures), len(files))
+			}
+			return nil
+		},
+	}
+
+	f.register(cmd)
+	cmd.Flags().StringSliceVar(&formats, "format", []string{"text"}, "output formats, comma-separated: "+strings.Join(transcript.Formats, ", "))
+	cmd.Flags().StringVar(&outputDir, "output-dir", "", "write outputs here, mirroring input directories, instead of next to each input")
+	cmd.Flags().BoolVar(&overwrite, "overwrite", false, "transcribe files whose outputs already exist")
+	return cmd
+}
+
+// transcribeBatchFile transcribes audioPath and writes it in each format.
+func (f *transcribeFlags) transcribeBatchFile(ctx *whisper.Context, opts whisper.TranscribeOptions, audioPath string, formats, outputs []string) error {
+	in, err := f.readAudio(audioPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	result, err := in.transcribe(ctx, opts)
+	if err != nil {
+		return err
+	}
+	return writeOutputs(result, formats, outputs)
+}
+
+// collectBatchFiles expands inputs into the files to transcribe, in order
+// and without duplicates. Glob patterns are expanded here so they work
+// when quoted or on shells that do not expand them. Two inputs that would
+// write the same outputs are an error.
+func collectBatchFiles(inputs []string, outputDir string) ([]batchFile, error) {
+	var files []batchFile
+	seen := map[string]bool{}
+	outs := map[string]string{} // output base -> input
+	add := func(path, rel string) error {
+		if seen[path] {
+			return nil
+		}
+		seen[path] = true
+		out := strings.TrimSuffix(path, filepath.Ext(path))
+		if outputDir != "" {
+			out = filepath.Join(outputDir, strings.TrimSuffix(rel, filepath.Ext(rel)))
+		}
+		if other, ok := outs[out]; ok {
+			return fmt.Errorf("%s and %s would write the same outputs", other, path)
+		}
+		outs[out] = path
+		files = append(files, batchFile{path: path, out: out})
+		return nil
+	}
+
+	for _, input := range inputs {
+		paths := []string{input}
+		if strings.ContainsAny(input, "*?[") {
+			matches, err := filepath.Glob(input)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %s: %w", input, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match %s", input)
+			}
+			paths = matches
+		}
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				if err := add(path, filepath.Base(path)); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if d.IsDir() || !slices.Contains(audioExts, strings.ToLower(filepath.Ext(p))) {
+					return nil
+				}
+				rel, err := filepath.Rel(path, p)
+				if err != nil {
+					return err
+				}
+				return add(p, rel)
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return files, nil
+}
+
+// allExist reports whether every path exists.
+func allExist(paths []string) bool {
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			return false
+		}
+	}
+	return true
+}
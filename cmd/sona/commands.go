@@ -1,14 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/thewh1teagle/sona/internal/audio"
 	"github.com/thewh1teagle/sona/internal/server"
+	"github.com/thewh1teagle/sona/internal/transcript"
 	"github.com/thewh1teagle/sona/internal/whisper"
 	"github.com/thewh1teagle/sona/parent"
 )
@@ -25,87 +30,237 @@ func newRootCommand() *cobra.Command {
 		Version: version,
 	}
 	rootCmd.PersistentFlags().BoolVarP(&a.verbose, "verbose", "v", false, "show ffmpeg and whisper/ggml logs")
-	rootCmd.AddCommand(a.newTranscribeCommand(), a.newServeCommand(), newPullCommand(), newDevicesCommand())
+	rootCmd.AddCommand(a.newTranscribeCommand(), a.newBatchCommand(), a.newServeCommand(), newPullCommand(), newDevicesCommand())
 	return rootCmd
 }
 
+// transcribeFlags are the decoding flags shared by transcribe and batch.
+type transcribeFlags struct {
+	language, prompt                                                string
+	translate, detectLanguage                                       bool
+	enhanceAudio, wordTimestamps                                    bool
+	threads, maxTextCtx, maxSegmentLen, bestOf, beamSize, gpuDevice int
+	chunkLength, chunkOverlap                                       int
+	temperature                                                     float32
+}
+
+func (f *transcribeFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&f.language, "language", "l", "", "language code (e.g. en, he); empty uses whisper.cpp default (en)")
+	cmd.Flags().BoolVar(&f.detectLanguage, "detect-language", false, "auto-detect language")
+	cmd.Flags().BoolVar(&f.enhanceAudio, "enhance-audio", false, "clean audio with ffmpeg before transcription (slower, can reduce repeats)")
+	cmd.Flags().BoolVar(&f.translate, "translate", false, "translate to English")
+	cmd.Flags().IntVar(&f.threads, "threads", 0, "CPU threads (0 = default)")
+	cmd.Flags().StringVar(&f.prompt, "prompt", "", "initial prompt / vocabulary hint")
+	cmd.Flags().Float32Var(&f.temperature, "temperature", 0, "initial decoding temperature (0 = default)")
+	cmd.Flags().IntVar(&f.maxTextCtx, "max-text-ctx", 0, "max tokens from past text as context (0 = default)")
+	cmd.Flags().BoolVar(&f.wordTimestamps, "word-timestamps", false, "enable token-level timestamps")
+	cmd.Flags().IntVar(&f.maxSegmentLen, "max-segment-len", 0, "max segment length in characters (0 = no limit)")
+	cmd.Flags().IntVar(&f.bestOf, "best-of", 0, "greedy sampling: top candidates (0 = default)")
+	cmd.Flags().IntVar(&f.beamSize, "beam-size", 0, "beam search: beam width (0 = default)")
+	cmd.Flags().IntVar(&f.gpuDevice, "gpu-device", -1, "GPU device index (-1 = whisper default)")
+	cmd.Flags().IntVar(&f.chunkLength, "chunk-length", 0, "transcribe in windows of this many seconds, decoding the file as it goes (0 = whole file at once)")
+	cmd.Flags().IntVar(&f.chunkOverlap, "chunk-overlap", 0, "seconds shared by consecutive windows with --chunk-length (0 = 5)")
+}
+
+func (f *transcribeFlags) validate() error {
+	if f.chunkLength > 0 && f.enhanceAudio {
+		return fmt.Errorf("--enhance-audio cannot be combined with --chunk-length")
+	}
+	return nil
+}
+
+func (f *transcribeFlags) options(verbose bool) whisper.TranscribeOptions {
+	return whisper.TranscribeOptions{
+		Language:       f.language,
+		DetectLanguage: f.detectLanguage,
+		Translate:      f.translate,
+		Threads:        f.threads,
+		Prompt:         f.prompt,
+		Verbose:        verbose,
+		Temperature:    f.temperature,
+		MaxTextCtx:     f.maxTextCtx,
+		WordTimestamps: f.wordTimestamps,
+		MaxSegmentLen:  f.maxSegmentLen,
+		BestOf:         f.bestOf,
+		BeamSize:       f.beamSize,
+		ChunkSeconds:   f.chunkLength,
+		ChunkOverlap:   f.chunkOverlap,
+	}
+}
+
+// audioInput is a decoded audio file, or in chunked mode a stream that is
+// decoded while it is transcribed, one window at a time, instead of being
+// read up front.
+type audioInput struct {
+	samples []float32
+	stream  *audio.Stream
+}
+
+func (f *transcribeFlags) readAudio(audioPath string) (audioInput, error) {
+	var in audioInput
+	var err error
+	if f.chunkLength > 0 {
+		in.stream, err = audio.OpenStream(audioPath)
+	} else {
+		in.samples, err = audio.ReadFileWithOptions(audioPath, audio.ReadOptions{
+			EnhanceAudio: f.enhanceAudio,
+		})
+	}
+	if err != nil {
+		return audioInput{}, fmt.Errorf("error reading audio: %w", err)
+	}
+	return in, nil
+}
+
+func (in audioInput) Close() {
+	if in.stream != nil {
+		in.stream.Close()
+	}
+}
+
+func (in audioInput) transcribe(ctx *whisper.Context, opts whisper.TranscribeOptions) (whisper.TranscribeResult, error) {
+	var result whisper.TranscribeResult
+	var err error
+	if in.stream != nil {
+		result, err = ctx.TranscribeReader(in.stream, opts, whisper.StreamCallbacks{})
+	} else {
+		result, err = ctx.Transcribe(in.samples, opts)
+	}
+	if err != nil {
+		return whisper.TranscribeResult{}, fmt.Errorf("error transcribing: %w", err)
+	}
+	return result, nil
+}
+
 func (a *app) newTranscribeCommand() *cobra.Command {
-	var language, prompt string
-	var translate, detectLanguage bool
-	var enhanceAudio, wordTimestamps bool
-	var threads, maxTextCtx, maxSegmentLen, bestOf, beamSize, gpuDevice int
-	var temperature float32
+	var f transcribeFlags
+	var formats []string
+	var output string
 
 	cmd := &cobra.Command{
 		Use:   "transcribe <model.bin> <audio.wav>",
 		Short: "Transcribe an audio file",
-		Args:  cobra.ExactArgs(2),
+		Long: "Transcribe an audio file. The transcript is printed in --format, or written to\n" +
+			"--output. With several formats, --output is a base name that gets each\n" +
+			"format's extension (.txt, .json, .verbose.json, .srt, .vtt, .tsv, .lrc).",
+		Args: cobra.ExactArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			modelPath := args[0]
 			audioPath := args[1]
 			audio.SetVerbose(a.verbose)
 			whisper.SetVerbose(a.verbose)
+			if err := f.validate(); err != nil {
+				return err
+			}
+			if err := validateFormats(formats); err != nil {
+				return err
+			}
+			var outputs []string
+			switch {
+			case output == "" && len(formats) > 1:
+				return fmt.Errorf("--output is required with more than one --format")
+			case len(formats) == 1 && output != "":
+				outputs = []string{output}
+			case output != "":
+				// Drop an output format extension the base name was given
+				// with, so -o out.srt --format srt,vtt writes out.srt and out.vtt.
+				base := transcript.TrimExtension(output)
+				for _, format := range formats {
+					outputs = append(outputs, base+transcript.Extensions[format])
+				}
+			}
 
-			samples, err := audio.ReadFileWithOptions(audioPath, audio.ReadOptions{
-				EnhanceAudio: enhanceAudio,
-			})
+			in, err := f.readAudio(audioPath)
 			if err != nil {
-				return fmt.Errorf("error reading audio: %w", err)
+				return err
 			}
+			defer in.Close()
 
-			ctx, err := whisper.New(modelPath, gpuDevice, false)
+			ctx, err := whisper.New(modelPath, f.gpuDevice, false)
 			if err != nil {
 				return fmt.Errorf("error loading model: %w", err)
 			}
 			defer ctx.Close()
 
-			result, err := ctx.Transcribe(samples, whisper.TranscribeOptions{
-				Language:       language,
-				DetectLanguage: detectLanguage,
-				Translate:      translate,
-				Threads:        threads,
-				Prompt:         prompt,
-				Verbose:        a.verbose,
-				Temperature:    temperature,
-				MaxTextCtx:     maxTextCtx,
-				WordTimestamps: wordTimestamps,
-				MaxSegmentLen:  maxSegmentLen,
-				BestOf:         bestOf,
-				BeamSize:       beamSize,
-			})
+			result, err := in.transcribe(ctx, f.options(a.verbose))
 			if err != nil {
-				return fmt.Errorf("error transcribing: %w", err)
+				return err
+			}
+			if outputs != nil {
+				return writeOutputs(result, formats, outputs)
 			}
-			fmt.Println(result.Text())
-			return nil
+			body, err := transcript.Render(formats[0], result, transcript.Options{})
+			if err != nil {
+				return err
+			}
+			if !bytes.HasSuffix(body, []byte("\n")) {
+				body = append(body, '\n')
+			}
+			_, err = cmd.OutOrStdout().Write(body)
+			return err
 		},
 	}
-
-	cmd.Flags().StringVarP(&language, "language", "l", "", "language code (e.g. en, he); empty uses whisper.cpp default (en)")
-	cmd.Flags().BoolVar(&detectLanguage, "detect-language", false, "auto-detect language")
-	cmd.Flags().BoolVar(&enhanceAudio, "enhance-audio", false, "clean audio with ffmpeg before transcription (slower, can reduce repeats)")
-	cmd.Flags().BoolVar(&translate, "translate", false, "translate to English")
-	cmd.Flags().IntVar(&threads, "threads", 0, "CPU threads (0 = default)")
-	cmd.Flags().StringVar(&prompt, "prompt", "", "initial prompt / vocabulary hint")
-	cmd.Flags().Float32Var(&temperature, "temperature", 0, "initial decoding temperature (0 = default)")
-	cmd.Flags().IntVar(&maxTextCtx, "max-text-ctx", 0, "max tokens from past text as context (0 = default)")
-	cmd.Flags().BoolVar(&wordTimestamps, "word-timestamps", false, "enable token-level timestamps")
-	cmd.Flags().IntVar(&maxSegmentLen, "max-segment-len", 0, "max segment length in characters (0 = no limit)")
-	cmd.Flags().IntVar(&bestOf, "best-of", 0, "greedy sampling: top candidates (0 = default)")
-	cmd.Flags().IntVar(&beamSize, "beam-size", 0, "beam search: beam width (0 = default)")
-	cmd.Flags().IntVar(&gpuDevice, "gpu-device", -1, "GPU device index (-1 = whisper default)")
+	f.register(cmd)
+	cmd.Flags().StringSliceVar(&formats, "format", []string{"text"}, "output formats, comma-separated: "+strings.Join(transcript.Formats, ", "))
+	cmd.Flags().StringVarP(&output, "output", "o", "", "write the transcript to this file instead of stdout")
 	return cmd
 }
 
+// validateFormats rejects formats transcript.Render does not know, and
+// formats given twice, which would write the same file twice.
+func validateFormats(formats []string) error {
+	if len(formats) == 0 {
+		return fmt.Errorf("--format must name at least one format")
+	}
+	for i, format := range formats {
+		if !slices.Contains(transcript.Formats, format) {
+			return fmt.Errorf("unknown format %q (want one of %s)", format, strings.Join(transcript.Formats, ", "))
+		}
+		if slices.Contains(formats[:i], format) {
+			return fmt.Errorf("format %q is given more than once", format)
+		}
+	}
+	return nil
+}
+
+// writeOutputs writes result in each format to the matching path. Each
+// output is written to a temporary file and renamed into place, so an
+// interrupted run never leaves an output that looks done.
+func writeOutputs(result whisper.TranscribeResult, formats, paths []string) error {
+	for i, format := range formats {
+		body, err := transcript.Render(format, result, transcript.Options{})
+		if err != nil {
+			return err
+		}
+		if dir := filepath.Dir(paths[i]); dir != "." {
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				return err
+			}
+		}
+		tmp := paths[i] + ".part"
+		if err := os.WriteFile(tmp, body, 0o644); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, paths[i]); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	return nil
+}
+
 func (a *app) newServeCommand() *cobra.Command {
-	var host string
-	var port int
+	var host, speakersPath string
+	var port, queueSize, parallel, maxAudioInMemory int
+	var diarizeTimeout time.Duration
 	var isparent bool
 
 	cmd := &cobra.Command{
-		Use:   "serve [model.bin]",
+		Use:   "serve [[name=]model.bin...]",
 		Short: "Start a transcription runner",
-		Args:  cobra.MaximumNArgs(1),
+		Long: "Start a transcription runner. Each model argument is loaded at startup under\n" +
+			"its file name, or under name when given as name=model.bin; requests choose a\n" +
+			"model with the OpenAI \"model\" field. The first model is the default.",
 		RunE: func(cmd *cobra.Command, args []string) error {
 
 			if isparent {
@@ -127,10 +282,19 @@ func (a *app) newServeCommand() *cobra.Command {
 			s := server.New(a.verbose)
 			s.Version = version
 			s.Commit = commit
+			s.QueueSize = queueSize
+			s.Parallel = parallel
+			s.MaxAudioInMemory = maxAudioInMemory
+			s.SpeakersPath = speakersPath
+			s.DiarizeTimeout = diarizeTimeout
 
-			// Load initial model if provided.
-			if len(args) > 0 {
-				if err := s.LoadModel(args[0], -1, false); err != nil {
+			// Load initial models if provided.
+			for _, arg := range args {
+				name, path := "", arg
+				if n, p, ok := strings.Cut(arg, "="); ok && n != "" {
+					name, path = n, p
+				}
+				if _, err := s.LoadModel(name, path, -1, false); err != nil {
 					return fmt.Errorf("error loading model: %w", err)
 				}
 			}
@@ -141,10 +305,25 @@ func (a *app) newServeCommand() *cobra.Command {
 
 	cmd.Flags().StringVar(&host, "host", "127.0.0.1", "host to bind to")
 	cmd.Flags().IntVarP(&port, "port", "p", 0, "port to listen on (0 = auto-assign)")
+	cmd.Flags().IntVar(&queueSize, "queue-size", 16, "transcription requests that may wait per model for a free slot (0 = reject when busy)")
+	cmd.Flags().IntVar(&parallel, "parallel", 1, "concurrent transcriptions per model, sharing the loaded weights")
+	cmd.Flags().IntVar(&maxAudioInMemory, "max-audio-in-memory", 30*60, "seconds of decoded audio an upload may keep in memory; longer uploads are spooled to disk and transcribed in chunks")
+	cmd.Flags().StringVar(&speakersPath, "speakers", defaultSpeakersPath(), "JSON file of enrolled speakers for /v1/speakers and identify_speakers (empty = disabled)")
+	cmd.Flags().DurationVar(&diarizeTimeout, "diarize-timeout", 30*time.Minute, "give up on a diarization after this long and return the transcript without speakers")
 	cmd.Flags().BoolVar(&isparent, "parent", false, "Parent monitoring")
 	return cmd
 }
 
+// defaultSpeakersPath is where enrolled speakers are kept unless --speakers
+// says otherwise: speakers.json in the user's config directory.
+func defaultSpeakersPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "sona", "speakers.json")
+}
+
 func newDevicesCommand() *cobra.Command {
 	return &cobra.Command{
 		Use:   "devices",
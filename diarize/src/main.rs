@@ -7,7 +7,12 @@ All diagnostics go to stderr so sona can parse stdout cleanly.
 Usage:
     wget https://huggingface.co/altunenes/parakeet-rs/resolve/main/diar_streaming_sortformer_4spk-v2.1.onnx
     wget https://github.com/thewh1teagle/pyannote-rs/releases/download/v0.1.0/6_speakers.wav
-    cargo run diar_streaming_sortformer_4spk-v2.1.onnx 6_speakers.wav
+    cargo run diar_streaming_sortformer_4spk-v2.1.onnx 6_speakers.wav [--num-speakers N] [--max-speakers N]
+
+--num-speakers and --max-speakers cap the speakers reported: the least
+talkative speaker's turns go to the speaker nearest in time until the cap
+holds. Sortformer tracks at most 4 speakers and cannot be asked for more,
+so there is no --min-speakers.
 
 Output (stdout):
   [{"start":0.00,"end":1.50,"speaker_id":0}, ...]
@@ -20,6 +25,9 @@ use std::env;
 use std::process;
 use std::time::Instant;
 
+/// Sortformer v2 tracks at most this many speakers.
+const MODEL_SPEAKERS: usize = 4;
+
 #[derive(Serialize)]
 struct Segment {
     start: f32,
@@ -32,12 +40,13 @@ fn run() -> Result<(), Box<dyn std::error::Error>> {
     let args: Vec<String> = env::args().collect();
 
     if args.len() < 3 {
-        eprintln!("Usage: sona-diarize <model.onnx> <audio.wav>");
+        eprintln!("Usage: sona-diarize <model.onnx> <audio.wav> [--num-speakers N] [--max-speakers N]");
         process::exit(1);
     }
 
     let model_path = &args[1];
     let audio_path = &args[2];
+    let max_speakers = parse_max_speakers(&args[3..])?;
 
     eprintln!("sona-diarize: loading audio {}", audio_path);
 
@@ -70,7 +79,7 @@ fn run() -> Result<(), Box<dyn std::error::Error>> {
 
     let speaker_segments = sortformer.diarize(audio, spec.sample_rate, spec.channels)?;
 
-    let segments: Vec<Segment> = speaker_segments
+    let mut segments: Vec<Segment> = speaker_segments
         .iter()
         .map(|seg| Segment {
             start: seg.start,
@@ -78,6 +87,9 @@ fn run() -> Result<(), Box<dyn std::error::Error>> {
             speaker_id: seg.speaker_id,
         })
         .collect();
+    if let Some(n) = max_speakers {
+        limit_speakers(&mut segments, n);
+    }
 
     // JSON to stdout — this is what sona reads
     println!("{}", serde_json::to_string(&segments)?);
@@ -91,6 +103,78 @@ fn run() -> Result<(), Box<dyn std::error::Error>> {
     Ok(())
 }
 
+/// Parses the options after the model and audio paths, returning the cap on
+/// speakers. --num-speakers wins over --max-speakers, as in sona.
+fn parse_max_speakers(args: &[String]) -> Result<Option<usize>, Box<dyn std::error::Error>> {
+    let (mut num, mut max) = (None, None);
+    let mut it = args.iter();
+    while let Some(flag) = it.next() {
+        let slot = match flag.as_str() {
+            "--num-speakers" => &mut num,
+            "--max-speakers" => &mut max,
+            _ => return Err(format!("unknown option {}", flag).into()),
+        };
+        let value = it.next().ok_or_else(|| format!("{} needs a value", flag))?;
+        let n: usize = value
+            .parse()
+            .map_err(|_| format!("invalid {} {:?}", flag, value))?;
+        if n == 0 {
+            return Err(format!("{} must be at least 1", flag).into());
+        }
+        *slot = Some(n);
+    }
+    if let Some(n) = num {
+        if n > MODEL_SPEAKERS {
+            return Err(format!("--num-speakers {} exceeds the model's {} speakers", n, MODEL_SPEAKERS).into());
+        }
+    }
+    Ok(num.or(max))
+}
+
+/// Relabels segments until at most n speakers remain. Each turn of the
+/// least talkative speaker goes to the speaker of the nearest turn in time.
+fn limit_speakers(segments: &mut [Segment], n: usize) {
+    loop {
+        let mut talk: Vec<(usize, f32)> = Vec::new();
+        for seg in segments.iter() {
+            match talk.iter_mut().find(|(id, _)| *id == seg.speaker_id) {
+                Some((_, d)) => *d += seg.end - seg.start,
+                None => talk.push((seg.speaker_id, seg.end - seg.start)),
+            }
+        }
+        if talk.len() <= n {
+            return;
+        }
+        let quietest = talk
+            .iter()
+            .min_by(|a, b| a.1.total_cmp(&b.1).then(b.0.cmp(&a.0)))
+            .map(|(id, _)| *id)
+            .unwrap();
+        let relabeled: Vec<usize> = segments
+            .iter()
+            .map(|seg| {
+                if seg.speaker_id != quietest {
+                    return seg.speaker_id;
+                }
+                segments
+                    .iter()
+                    .filter(|other| other.speaker_id != quietest)
+                    .min_by(|a, b| gap(seg, a).total_cmp(&gap(seg, b)))
+                    .map(|other| other.speaker_id)
+                    .unwrap()
+            })
+            .collect();
+        for (seg, id) in segments.iter_mut().zip(relabeled) {
+            seg.speaker_id = id;
+        }
+    }
+}
+
+/// Time between two turns, 0 when they overlap.
+fn gap(a: &Segment, b: &Segment) -> f32 {
+    (b.start - a.end).max(a.start - b.end).max(0.0)
+}
+
 fn main() {
     if let Err(e) = run() {
         eprintln!("sona-diarize: error: {}", e);
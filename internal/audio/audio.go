@@ -1,18 +1,18 @@
 package audio
 
 import (
-	"bytes"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
-
-	"github.com/thewh1teagle/sona/internal/wav"
 )
 
 var verbose bool
 
+// enhanceFilter removes long silences (ReadOptions.EnhanceAudio).
+const enhanceFilter = "silenceremove=stop_periods=-1:stop_duration=0.7:stop_threshold=-45dB"
+
 type ReadOptions struct {
 	EnhanceAudio bool
 }
@@ -53,92 +53,58 @@ func findFFmpeg() (string, error) {
 	return "", fmt.Errorf("ffmpeg not found: %w", err)
 }
 
-// ConvertToNativeWav converts any audio file to a 16kHz mono 16-bit PCM WAV file
-// on disk using ffmpeg. When enhanceAudio is true, a silence removal filter is applied.
-func ConvertToNativeWav(inputPath, outputPath string, enhanceAudio bool) error {
-	ffmpegPath, err := findFFmpeg()
-	if err != nil {
-		return err
-	}
-
-	args := []string{
-		"-i", inputPath,
-		"-ar", "16000",
-		"-ac", "1",
-	}
-	if enhanceAudio {
-		args = append(args, "-af", "silenceremove=stop_periods=-1:stop_duration=0.7:stop_threshold=-45dB")
-	}
-	args = append(args,
-		"-acodec", "pcm_s16le",
-		"-y",
-		outputPath,
-	)
-
-	cmd := exec.Command(ffmpegPath, args...)
-	var stderrBuf bytes.Buffer
-	if verbose {
-		cmd.Stderr = io.MultiWriter(os.Stderr, &stderrBuf)
-	} else {
-		cmd.Stderr = &stderrBuf
-	}
-
-	if err := cmd.Run(); err != nil {
-		stderr := stderrBuf.String()
-		if stderr != "" {
-			// Truncate stderr to avoid huge error messages
-			if len(stderr) > 500 {
-				stderr = stderr[:500] + "..."
-			}
-			return fmt.Errorf("ffmpeg WAV conversion failed: %w\nffmpeg stderr: %s", err, stderr)
+// ffmpegError wraps a failed ffmpeg run with the tail of its stderr.
+func ffmpegError(what string, err error, stderr string) error {
+	if stderr != "" {
+		// Truncate stderr to avoid huge error messages
+		if len(stderr) > 500 {
+			stderr = stderr[:500] + "..."
 		}
-		return fmt.Errorf("ffmpeg WAV conversion failed: %w", err)
+		return fmt.Errorf("ffmpeg %s failed: %w\nffmpeg stderr: %s", what, err, stderr)
 	}
-	return nil
+	return fmt.Errorf("ffmpeg %s failed: %w", what, err)
 }
 
 // Read decodes audio from an io.ReadSeeker into float32 samples at 16kHz mono.
-// If the input is a native 16kHz/mono/16-bit PCM WAV, it is decoded directly.
-// Otherwise, ffmpeg is used to convert the audio.
+// WAV (PCM or float, any rate, up to 32 channels), FLAC, MP3 and Ogg
+// Vorbis are decoded in-process. Other formats are converted with ffmpeg.
 func Read(r io.ReadSeeker) ([]float32, error) {
 	return ReadWithOptions(r, ReadOptions{})
 }
 
+// ReadWithOptions is Read with options.
 func ReadWithOptions(r io.ReadSeeker, opts ReadOptions) ([]float32, error) {
-	h, err := wav.ReadHeader(r)
-	if err == nil && h.IsNative() && !opts.EnhanceAudio {
-		return wav.Read(r)
-	}
-
-	// Not a native WAV (or enhancement requested) — need ffmpeg
-	r.Seek(0, io.SeekStart)
-
-	// Save to temp file for ffmpeg input
-	tmp, err := os.CreateTemp("", "sona-*.audio")
+	samples, err := decode(r)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create temp file: %w", err)
-	}
-	defer os.Remove(tmp.Name())
-
-	if _, err := io.Copy(tmp, r); err != nil {
-		tmp.Close()
-		return nil, fmt.Errorf("failed to write temp file: %w", err)
+		// ffmpeg is the last resort for formats we can't decode ourselves.
+		if _, ffErr := findFFmpeg(); ffErr != nil {
+			return nil, fmt.Errorf("%w (and %v)", err, ffErr)
+		}
+		if verbose {
+			fmt.Fprintf(os.Stderr, "built-in decoding failed (%v), falling back to ffmpeg\n", err)
+		}
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if samples, err = convertFile(r); err != nil {
+			return nil, err
+		}
 	}
-	tmp.Close()
-
-	// Convert to native WAV via ffmpeg
-	nativeWav := tmp.Name() + ".wav"
-	if err := ConvertToNativeWav(tmp.Name(), nativeWav, opts.EnhanceAudio); err != nil {
-		return nil, err
+	if opts.EnhanceAudio {
+		return Enhance(samples)
 	}
-	defer os.Remove(nativeWav)
+	return samples, nil
+}
 
-	f, err := os.Open(nativeWav)
+// convertFile decodes r with ffmpeg through a temp file, for inputs
+// ffmpeg must be able to seek in (e.g. MP4 with its index at the end).
+func convertFile(r io.Reader) ([]float32, error) {
+	src, err := spoolFFmpeg(r)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open converted file: %w", err)
+		return nil, err
 	}
-	defer f.Close()
-	return wav.Read(f)
+	defer src.close()
+	return newStream(src).readAll()
 }
 
 // ReadFile opens an audio file by path and returns float32 samples at 16kHz mono.
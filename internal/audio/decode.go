@@ -0,0 +1,251 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/hajimehoshi/go-mp3"
+	"github.com/jfreymuth/oggvorbis"
+	"github.com/mewkiz/flac"
+	"github.com/thewh1teagle/sona/internal/wav"
+)
+
+// SampleRate is the rate all decoded audio is converted to, in Hz.
+const SampleRate = wav.SampleRate
+
+var errUnsupportedFormat = errors.New("unsupported audio format")
+
+// sniffSize is how much of the input is inspected to identify its format.
+const sniffSize = 4096
+
+// sniff identifies the container from the first bytes of a file. WAV is
+// only reported when wav.Read supports its encoding.
+func sniff(head []byte) string {
+	switch {
+	case len(head) >= 12 && string(head[0:4]) == "RIFF" && string(head[8:12]) == "WAVE":
+		if h, err := wav.ReadHeader(bytes.NewReader(head)); err != nil || !h.Supported() {
+			return "wav (unsupported encoding)"
+		}
+		return "wav"
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		return "flac"
+	case bytes.HasPrefix(head, []byte("OggS")):
+		// The first page holds the codec's identification header.
+		if bytes.Contains(head[:min(len(head), 64)], []byte("\x01vorbis")) {
+			return "vorbis"
+		}
+		return "ogg"
+	case len(head) >= 8 && string(head[4:8]) == "ftyp":
+		return "mp4"
+	case bytes.HasPrefix(head, []byte("ID3")), isMP3Frame(head):
+		return "mp3"
+	}
+	return ""
+}
+
+// isMP3Frame reports whether head starts with an MPEG audio Layer III
+// frame header. The frame sync alone also matches ADTS AAC and Layer I/II,
+// which go-mp3 cannot decode, so the layer, version, bitrate and sample
+// rate fields are checked too.
+func isMP3Frame(head []byte) bool {
+	if len(head) < 3 || head[0] != 0xFF || head[1]&0xE0 != 0xE0 {
+		return false
+	}
+	version := head[1] >> 3 & 0x03
+	layer := head[1] >> 1 & 0x03
+	bitrate := head[2] >> 4
+	rate := head[2] >> 2 & 0x03
+	return version != 0x01 && layer == 0x01 && bitrate != 0x0F && rate != 0x03
+}
+
+// decode converts WAV, FLAC, MP3 and Ogg Vorbis to 16 kHz mono in-process. Other
+// formats return errUnsupportedFormat and are left to ffmpeg.
+func decode(r io.ReadSeeker) ([]float32, error) {
+	head := make([]byte, sniffSize)
+	n, _ := io.ReadFull(r, head)
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return decodeFormat(sniff(head[:n]), r)
+}
+
+// decodeFormat decodes r, whose format was identified by sniff. r is read
+// sequentially.
+func decodeFormat(format string, r io.Reader) ([]float32, error) {
+	src, err := newDecoder(format, r)
+	if err != nil {
+		return nil, err
+	}
+	return newStream(src).readAll()
+}
+
+// source produces mono samples at its own sample rate, a piece at a time.
+// read returns io.EOF after the last sample.
+type source interface {
+	rate() int
+	read(dst []float32) (int, error)
+	close() error
+}
+
+// newDecoder returns the in-process decoder for a format identified by
+// sniff.
+func newDecoder(format string, r io.Reader) (source, error) {
+	switch format {
+	case "wav":
+		d, err := wav.NewDecoder(r)
+		if err != nil {
+			return nil, err
+		}
+		return wavSource{d}, nil
+	case "flac":
+		return newFLACSource(r)
+	case "mp3":
+		return newMP3Source(r)
+	case "vorbis":
+		return newVorbisSource(r)
+	case "":
+		return nil, errUnsupportedFormat
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnsupportedFormat, format)
+	}
+}
+
+// wavSource reads PCM or float WAV of any rate and channel count.
+type wavSource struct{ d *wav.Decoder }
+
+func (s wavSource) rate() int                       { return int(s.d.SampleRate) }
+func (s wavSource) read(dst []float32) (int, error) { return s.d.Read(dst) }
+func (s wavSource) close() error                    { return nil }
+
+// flacSource reads a FLAC stream frame by frame and mixes it down to mono.
+type flacSource struct {
+	stream  *flac.Stream
+	scale   float32
+	pending []float32 // decoded samples of the current frame not yet read
+}
+
+func newFLACSource(r io.Reader) (*flacSource, error) {
+	stream, err := flac.New(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid FLAC stream: %w", err)
+	}
+	return &flacSource{stream: stream, scale: float32(int64(1) << (stream.Info.BitsPerSample - 1))}, nil
+}
+
+func (s *flacSource) rate() int { return int(s.stream.Info.SampleRate) }
+
+func (s *flacSource) read(dst []float32) (int, error) {
+	for len(s.pending) == 0 {
+		f, err := s.stream.ParseNext()
+		if err == io.EOF {
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to decode FLAC frame: %w", err)
+		}
+		n := f.Subframes[0].NSamples
+		s.pending = s.pending[:0]
+		for i := 0; i < n; i++ {
+			var sum float32
+			for _, sub := range f.Subframes {
+				sum += float32(sub.Samples[i])
+			}
+			s.pending = append(s.pending, sum/float32(len(f.Subframes))/s.scale)
+		}
+	}
+	n := copy(dst, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+func (s *flacSource) close() error { return s.stream.Close() }
+
+// mp3Source reads an MP3 stream. go-mp3 always produces 16-bit stereo,
+// which is mixed down to mono.
+type mp3Source struct {
+	d   *mp3.Decoder
+	buf []byte
+}
+
+func newMP3Source(r io.Reader) (*mp3Source, error) {
+	d, err := mp3.NewDecoder(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MP3 stream: %w", err)
+	}
+	return &mp3Source{d: d}, nil
+}
+
+func (s *mp3Source) rate() int { return s.d.SampleRate() }
+
+func (s *mp3Source) read(dst []float32) (int, error) {
+	if cap(s.buf) < len(dst)*4 {
+		s.buf = make([]byte, len(dst)*4)
+	}
+	n, err := io.ReadFull(s.d, s.buf[:len(dst)*4])
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	if err != nil && err != io.EOF {
+		return 0, fmt.Errorf("failed to decode MP3: %w", err)
+	}
+	pcm := s.buf[:n-n%4]
+	for i := range len(pcm) / 4 {
+		left := int16(binary.LittleEndian.Uint16(pcm[i*4:]))
+		right := int16(binary.LittleEndian.Uint16(pcm[i*4+2:]))
+		dst[i] = (float32(left) + float32(right)) / 2 / math.MaxInt16
+	}
+	if len(pcm) == 0 {
+		return 0, io.EOF
+	}
+	return len(pcm) / 4, nil
+}
+
+func (s *mp3Source) close() error { return nil }
+
+// vorbisSource reads an Ogg Vorbis stream, whose samples come
+// interleaved, and mixes it down to mono.
+type vorbisSource struct {
+	r   *oggvorbis.Reader
+	buf []float32
+}
+
+func newVorbisSource(r io.Reader) (*vorbisSource, error) {
+	vr, err := oggvorbis.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Ogg Vorbis stream: %w", err)
+	}
+	return &vorbisSource{r: vr}, nil
+}
+
+func (s *vorbisSource) rate() int { return s.r.SampleRate() }
+
+func (s *vorbisSource) read(dst []float32) (int, error) {
+	channels := s.r.Channels()
+	// Up to 255 channels; a pass is bounded like wav.Decoder's.
+	want := min(len(dst), 1<<18/channels) * channels
+	if cap(s.buf) < want {
+		s.buf = make([]float32, want)
+	}
+	n, err := s.r.Read(s.buf[:want])
+	if err != nil && err != io.EOF {
+		return 0, fmt.Errorf("failed to decode Ogg Vorbis: %w", err)
+	}
+	frames := n / channels
+	for i := range frames {
+		var sum float32
+		for _, v := range s.buf[i*channels : (i+1)*channels] {
+			sum += v
+		}
+		dst[i] = sum / float32(channels)
+	}
+	if frames == 0 {
+		return 0, io.EOF
+	}
+	return frames, nil
+}
+
+func (s *vorbisSource) close() error { return nil }
@@ -0,0 +1,273 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/thewh1teagle/sona/internal/wav"
+)
+
+func TestSniff(t *testing.T) {
+	var native bytes.Buffer
+	wav.Write(&native, make([]float32, 16))
+	adpcm := append([]byte(nil), native.Bytes()...)
+	adpcm[20] = 2 // WAVE_FORMAT_ADPCM
+
+	tests := []struct {
+		head string
+		want string
+	}{
+		{native.String(), "wav"},
+		{string(adpcm), "wav (unsupported encoding)"},
+		{"\x00\x00\x00\x20ftypM4A ", "mp4"},
+		{"fLaC\x00\x00\x00\x22", "flac"},
+		{"OggS\x00\x02", "ogg"},
+		{"OggS\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00\x12\xd8p\x15\x00\x00\x00\x00?\xbc\xf6\xa7\x01\x1e\x01vorbis", "vorbis"},
+		{"ID3\x04\x00", "mp3"},
+		{"\xff\xfb\x90\x64", "mp3"},
+		{"\xff\xf1\x50\x80", ""}, // ADTS AAC
+		{"\xff\xf9\x50\x80", ""}, // ADTS AAC, MPEG-2
+		{"\xff\xfd\x90\x64", ""}, // MPEG-1 Layer II
+		{"\xff\xfb\xf0\x64", ""}, // bad bitrate index
+		{"\xff\xfb\x9c\x64", ""}, // reserved sample rate
+		{"\xff\xeb\x90\x64", ""}, // reserved version
+		{"hello", ""},
+	}
+	for _, tt := range tests {
+		if got := sniff([]byte(tt.head)); got != tt.want {
+			t.Errorf("sniff(%q) = %q, want %q", tt.head, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeWAV(t *testing.T) {
+	var buf bytes.Buffer
+	wav.Write(&buf, []float32{0, 0.5, -0.5, 0})
+	samples, err := decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 4 || math.Abs(float64(samples[1]-0.5)) > 1e-3 {
+		t.Errorf("decoded %v, want [0 0.5 -0.5 0]", samples)
+	}
+}
+
+func TestNewStreamWAV(t *testing.T) {
+	var buf bytes.Buffer
+	wav.Write(&buf, []float32{0, 0.5, -0.5, 0})
+	// A stream without Seek, delivering one byte at a time.
+	s, err := NewStream(iotest.OneByteReader(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	samples, err := s.readAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 4 || math.Abs(float64(samples[2]+0.5)) > 1e-3 {
+		t.Errorf("decoded %v, want [0 0.5 -0.5 0]", samples)
+	}
+}
+
+func TestNewStreamFallsBackToFFmpeg(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+	if _, err := exec.LookPath("ffmpeg"); err == nil {
+		t.Skip("a real ffmpeg on PATH takes precedence over the fake one")
+	}
+	// The fake ffmpeg echoes its input back as "PCM", so the sample count
+	// shows whether the bytes read by the failed decoder were replayed.
+	fake := filepath.Join(t.TempDir(), "ffmpeg")
+	if err := os.WriteFile(fake, []byte("#!/bin/sh\ncat\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SONA_FFMPEG_PATH", fake)
+
+	// A WAV header followed by a chunk longer than the sniffed head and
+	// no data chunk: it sniffs as WAV, but the decoder fails past the head.
+	var buf bytes.Buffer
+	wav.Write(&buf, nil)
+	input := buf.Bytes()[:36]
+	input = append(input, "LIST"...)
+	input = binary.LittleEndian.AppendUint32(input, 2*sniffSize)
+	input = append(input, make([]byte, 2*sniffSize)...)
+	binary.LittleEndian.PutUint32(input[4:], uint32(len(input)-8))
+
+	s, err := NewStream(bytes.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	samples, err := s.readAll()
+	if err != nil || len(samples) != len(input)/2 {
+		t.Errorf("got %d samples, %v; want %d", len(samples), err, len(input)/2)
+	}
+}
+
+func TestFFmpegSourceDoesNotWaitForInput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+	if _, err := exec.LookPath("ffmpeg"); err == nil {
+		t.Skip("a real ffmpeg on PATH takes precedence over the fake one")
+	}
+	fake := filepath.Join(t.TempDir(), "ffmpeg")
+	if err := os.WriteFile(fake, []byte("#!/bin/sh\ncat\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SONA_FFMPEG_PATH", fake)
+
+	// Closing returns while the input is still being produced.
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	src, err := startFFmpeg(pr, []string{"-i", "pipe:0"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := make(chan struct{})
+	go func() {
+		src.close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("close waited for the input")
+	}
+
+	// Input that fails to arrive is an error, not a short recording.
+	src, err = startFFmpeg(iotest.ErrReader(io.ErrUnexpectedEOF), []string{"-i", "pipe:0"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.close()
+	if _, err := newStream(src).readAll(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got %v, want the input's error", err)
+	}
+}
+
+func TestNewLiveStream(t *testing.T) {
+	if _, err := NewLiveStream(bytes.NewReader(nil), "mp3"); err == nil {
+		t.Error("expected an error for an unsupported container")
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+	if _, err := exec.LookPath("ffmpeg"); err == nil {
+		t.Skip("a real ffmpeg on PATH takes precedence over the fake one")
+	}
+	fake := filepath.Join(t.TempDir(), "ffmpeg")
+	if err := os.WriteFile(fake, []byte("#!/bin/sh\ncat\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SONA_FFMPEG_PATH", fake)
+
+	s, err := NewLiveStream(bytes.NewReader(make([]byte, 64)), "ogg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if samples, err := s.readAll(); err != nil || len(samples) != 32 {
+		t.Errorf("got %d samples, %v; want 32", len(samples), err)
+	}
+}
+
+func TestPCM16Reader(t *testing.T) {
+	// Odd-sized reads split a sample across calls.
+	p := newPCM16Reader(iotest.OneByteReader(bytes.NewReader([]byte{0xff, 0x7f, 0x01, 0x80, 0x00})))
+	var samples []float32
+	dst := make([]float32, 4)
+	for {
+		n, err := p.read(dst)
+		samples = append(samples, dst[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(samples) != 2 || samples[0] != 1 || samples[1] != -1 {
+		t.Errorf("got %v, want [1 -1] (trailing odd byte dropped)", samples)
+	}
+}
+
+func TestStreamResamples(t *testing.T) {
+	var buf bytes.Buffer
+	wav.Write(&buf, make([]float32, SampleRate))
+	file := buf.Bytes()
+	binary.LittleEndian.PutUint32(file[24:], 44100) // relabel as 44.1 kHz
+	binary.LittleEndian.PutUint32(file[28:], 44100*2)
+
+	s, err := NewStream(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	total := 0
+	dst := make([]float32, 1000)
+	for {
+		n, err := s.ReadSamples(dst)
+		total += n
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := SampleRate * SampleRate / 44100; total != want {
+		t.Errorf("streamed %d samples, want %d", total, want)
+	}
+}
+
+func TestDecodeVorbis(t *testing.T) {
+	// One second of 44.1 kHz mono, from jfreymuth/oggvorbis's test data.
+	file, err := os.ReadFile("testdata/vorbis.ogg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples, err := decode(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != SampleRate {
+		t.Fatalf("decoded %d samples, want %d", len(samples), SampleRate)
+	}
+	var energy float64
+	for _, v := range samples {
+		energy += float64(v) * float64(v)
+	}
+	if energy == 0 {
+		t.Error("decoded silence")
+	}
+
+	// Streamed from a reader that cannot seek, as uploads are.
+	s, err := NewStream(iotest.OneByteReader(bytes.NewReader(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	streamed, err := s.readAll()
+	if err != nil || len(streamed) != len(samples) {
+		t.Errorf("streamed %d samples, %v; want %d", len(streamed), err, len(samples))
+	}
+}
+
+func TestDecodeUnsupported(t *testing.T) {
+	if _, err := decode(bytes.NewReader([]byte("OggS\x00\x02 opus"))); !errors.Is(err, errUnsupportedFormat) {
+		t.Errorf("ogg: got %v, want errUnsupportedFormat", err)
+	}
+}
@@ -0,0 +1,384 @@
+package audio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/thewh1teagle/sona/internal/wav"
+)
+
+// Stream decodes audio incrementally into 16kHz mono samples, so a long
+// recording never has to be in memory at once.
+type Stream struct {
+	src     source
+	rs      *wav.Resampler
+	buf     []float32 // decoded samples at the source rate
+	pending []float32 // resampled samples not yet read
+	eof     bool
+}
+
+// NewStream starts decoding r. WAV, FLAC, MP3 and Ogg Vorbis are decoded
+// in-process as they are read, falling back to ffmpeg when the in-process
+// decoder rejects the header; other formats are piped through ffmpeg's
+// stdin and read back as raw PCM from its stdout, so nothing touches the
+// disk. MP4/MOV
+// containers are the exception: they may keep their index at the end, so
+// they are spooled to a temp file first. The caller must Close the stream.
+func NewStream(r io.Reader) (*Stream, error) {
+	br := bufio.NewReaderSize(r, sniffSize)
+	head, _ := br.Peek(sniffSize)
+
+	var src source
+	var err error
+	switch format := sniff(head); format {
+	case "wav", "flac", "mp3", "vorbis":
+		// Keep what the decoder reads while parsing the header, so ffmpeg
+		// can be given the whole input if it gives up.
+		rec := &recorder{r: br}
+		src, err = newDecoder(format, rec)
+		if err != nil {
+			if verbose {
+				fmt.Fprintf(os.Stderr, "built-in decoding failed (%v), falling back to ffmpeg\n", err)
+			}
+			var ffErr error
+			if src, ffErr = startFFmpeg(io.MultiReader(bytes.NewReader(rec.buf.Bytes()), br), []string{"-i", "pipe:0"}, ""); ffErr != nil {
+				err = fmt.Errorf("%w (and %v)", err, ffErr)
+			} else {
+				err = nil
+			}
+		}
+		rec.stop()
+	case "mp4":
+		src, err = spoolFFmpeg(br)
+	default:
+		src, err = startFFmpeg(br, []string{"-i", "pipe:0"}, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newStream(src), nil
+}
+
+// liveDemuxers maps the containers NewLiveStream accepts to ffmpeg's
+// demuxer for them.
+var liveDemuxers = map[string]string{
+	"ogg":  "ogg",      // Ogg Opus, e.g. from opus-recorder
+	"webm": "matroska", // WebM Opus, e.g. from a browser's MediaRecorder
+}
+
+// NewLiveStream decodes an Opus (or other codec) stream in container from
+// r as it arrives, through ffmpeg. Unlike NewStream it does not sniff the
+// input and ffmpeg is told not to probe or buffer it, so samples come out
+// shortly after the bytes go in. The caller must Close the stream.
+func NewLiveStream(r io.Reader, container string) (*Stream, error) {
+	demuxer, ok := liveDemuxers[container]
+	if !ok {
+		return nil, fmt.Errorf("unsupported live container %q", container)
+	}
+	src, err := startFFmpeg(r, []string{
+		"-fflags", "nobuffer", "-probesize", "32", "-analyzeduration", "0",
+		"-f", demuxer, "-i", "pipe:0",
+	}, "")
+	if err != nil {
+		return nil, err
+	}
+	return newStream(src), nil
+}
+
+// recorder passes reads through, keeping a copy of the bytes read until
+// stop is called.
+type recorder struct {
+	r       io.Reader
+	buf     bytes.Buffer
+	stopped bool
+}
+
+func (rec *recorder) Read(p []byte) (int, error) {
+	n, err := rec.r.Read(p)
+	if !rec.stopped {
+		rec.buf.Write(p[:n])
+	}
+	return n, err
+}
+
+func (rec *recorder) stop() {
+	rec.stopped = true
+	rec.buf = bytes.Buffer{}
+}
+
+// OpenStream opens an audio file by path for incremental decoding.
+func OpenStream(path string) (*Stream, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := NewStream(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	src := s.src
+	s.src = closeSource{src, func() error {
+		err := src.close()
+		if fErr := f.Close(); err == nil {
+			err = fErr
+		}
+		return err
+	}}
+	return s, nil
+}
+
+func newStream(src source) *Stream {
+	return &Stream{src: src, rs: wav.NewResampler(src.rate(), SampleRate), buf: make([]float32, 1<<15)}
+}
+
+// ReadSamples decodes up to len(dst) samples into dst. It returns io.EOF
+// once the audio has ended.
+func (s *Stream) ReadSamples(dst []float32) (int, error) {
+	for len(s.pending) == 0 {
+		if s.eof {
+			return 0, io.EOF
+		}
+		n, err := s.src.read(s.buf)
+		if n > 0 {
+			s.pending = s.rs.Process(s.buf[:n])
+		}
+		if err == io.EOF {
+			s.pending = append(s.pending, s.rs.Flush()...)
+			s.eof = true
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(dst, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+// Close stops decoding and releases the decoder, ending ffmpeg if it is
+// still running.
+func (s *Stream) Close() error {
+	return s.src.close()
+}
+
+// readAll decodes the rest of the stream.
+func (s *Stream) readAll() ([]float32, error) {
+	var samples []float32
+	chunk := make([]float32, 1<<16)
+	for {
+		n, err := s.ReadSamples(chunk)
+		samples = append(samples, chunk[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("audio file contains no samples")
+	}
+	return samples, nil
+}
+
+// closeSource replaces a source's close.
+type closeSource struct {
+	source
+	closeFn func() error
+}
+
+func (s closeSource) close() error { return s.closeFn() }
+
+// Enhance removes long silences from 16kHz mono samples with ffmpeg.
+func Enhance(samples []float32) ([]float32, error) {
+	pcm := make([]byte, len(samples)*2)
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(pcm[i*2:], uint16(int16(max(-1, min(1, s))*math.MaxInt16)))
+	}
+	input := []string{"-f", "s16le", "-ar", strconv.Itoa(SampleRate), "-ac", "1", "-i", "pipe:0"}
+	src, err := startFFmpeg(bytes.NewReader(pcm), input, enhanceFilter)
+	if err != nil {
+		return nil, err
+	}
+	defer src.close()
+	return newStream(src).readAll()
+}
+
+// ffmpegSource reads the 16kHz mono s16le PCM ffmpeg writes to stdout.
+type ffmpegSource struct {
+	cmd    *exec.Cmd
+	pcm    pcm16Reader
+	stdin  io.WriteCloser // nil without input to feed
+	fed    chan error     // the error reading the input, once it is all fed
+	stderr bytes.Buffer
+	done   bool
+}
+
+// startFFmpeg runs ffmpeg with the given input arguments, feeding in to
+// its stdin; filter is an optional -af filter graph. in is fed from a
+// goroutine of our own rather than by exec, so closing the source never
+// waits for a slow producer (e.g. a client still uploading) to finish.
+func startFFmpeg(in io.Reader, input []string, filter string) (*ffmpegSource, error) {
+	ffmpegPath, err := findFFmpeg()
+	if err != nil {
+		return nil, err
+	}
+
+	args := append(append([]string{}, input...), "-ar", strconv.Itoa(SampleRate), "-ac", "1")
+	if filter != "" {
+		args = append(args, "-af", filter)
+	}
+	args = append(args, "-f", "s16le", "-acodec", "pcm_s16le", "pipe:1")
+
+	s := &ffmpegSource{cmd: exec.Command(ffmpegPath, args...)}
+	s.cmd.WaitDelay = time.Second // don't wait on pipes held open by orphaned children
+	if in != nil {
+		if s.stdin, err = s.cmd.StdinPipe(); err != nil {
+			return nil, err
+		}
+	}
+	if verbose {
+		s.cmd.Stderr = io.MultiWriter(os.Stderr, &s.stderr)
+	} else {
+		s.cmd.Stderr = &s.stderr
+	}
+	stdout, err := s.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	s.pcm = newPCM16Reader(stdout)
+	if in != nil {
+		s.fed = make(chan error, 1)
+		go s.feed(in)
+	}
+	return s, nil
+}
+
+// feed copies in to ffmpeg's stdin and closes it. Write errors are
+// ignored: they only mean ffmpeg exited or the source was closed.
+func (s *ffmpegSource) feed(in io.Reader) {
+	r := &readErrReader{r: in}
+	io.Copy(s.stdin, r)
+	s.fed <- r.err // before ffmpeg can see EOF and finish
+	s.stdin.Close()
+}
+
+// readErrReader records the first error other than EOF its reader returns.
+type readErrReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *readErrReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+func (s *ffmpegSource) rate() int { return SampleRate }
+
+func (s *ffmpegSource) read(dst []float32) (int, error) {
+	n, err := s.pcm.read(dst)
+	if err == io.EOF {
+		s.done = true
+		waitErr := s.cmd.Wait()
+		// Input that failed to arrive would otherwise look like a short
+		// recording. If ffmpeg finished without reading all of it, there is
+		// no need to wait for the rest.
+		select {
+		case feedErr := <-s.fed:
+			if feedErr != nil {
+				return n, fmt.Errorf("failed to read input: %w", feedErr)
+			}
+		default:
+		}
+		if waitErr != nil {
+			return n, ffmpegError("decoding", waitErr, s.stderr.String())
+		}
+	} else if err != nil {
+		return n, fmt.Errorf("failed to read ffmpeg output: %w", err)
+	}
+	return n, err
+}
+
+func (s *ffmpegSource) close() error {
+	if s.done {
+		return nil
+	}
+	s.done = true
+	if s.stdin != nil {
+		s.stdin.Close() // stops feed at its next write
+	}
+	s.cmd.Process.Kill()
+	s.cmd.Wait()
+	return nil
+}
+
+// spoolFFmpeg copies r to a temp file and decodes that with ffmpeg, for
+// inputs ffmpeg must be able to seek in (e.g. MP4 with its index at the
+// end). The file is removed on close.
+func spoolFFmpeg(r io.Reader) (source, error) {
+	tmp, err := os.CreateTemp("", "sona-*.audio")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("failed to write temp file: %w", err)
+	}
+	src, err := startFFmpeg(nil, []string{"-i", tmp.Name()}, "")
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return closeSource{src, func() error {
+		err := src.close()
+		os.Remove(tmp.Name())
+		return err
+	}}, nil
+}
+
+// pcm16Reader decodes little-endian 16-bit mono PCM.
+type pcm16Reader struct {
+	r   io.Reader
+	buf []byte
+	odd []byte // a trailing byte left over from the previous read
+}
+
+func newPCM16Reader(r io.Reader) pcm16Reader {
+	return pcm16Reader{r: r, buf: make([]byte, 1<<16)}
+}
+
+// read decodes up to len(dst) samples. A trailing odd byte at EOF is
+// dropped.
+func (p *pcm16Reader) read(dst []float32) (int, error) {
+	want := min(len(dst)*2, len(p.buf))
+	copy(p.buf, p.odd)
+	n, err := p.r.Read(p.buf[len(p.odd):want])
+	n += len(p.odd)
+	p.odd = append(p.odd[:0], p.buf[n-n%2:n]...)
+	for i := range n / 2 {
+		dst[i] = float32(int16(binary.LittleEndian.Uint16(p.buf[i*2:]))) / math.MaxInt16
+	}
+	if err == io.EOF && n/2 > 0 {
+		err = nil // report EOF on the next call
+	}
+	return n / 2, err
+}
@@ -0,0 +1,162 @@
+package diarize
+
+import (
+	"context"
+	"math"
+	"slices"
+)
+
+// merge records that clusters a and b (named by their first member) were
+// joined at distance dist.
+type merge struct {
+	a, b int
+	dist float64
+}
+
+// cluster groups vectors by average-linkage agglomerative clustering on
+// cosine distance, stopping once the closest clusters are farther apart
+// than threshold. The number of clusters is then kept within [lo, hi]
+// (hi 0 = no bound) by merging more or fewer clusters. Labels are numbered
+// from 0 in order of first appearance. It stops early with ctx's error.
+func cluster(ctx context.Context, vectors [][]float32, threshold float64, lo, hi int) ([]int, error) {
+	merges, err := linkage(ctx, vectors)
+	if err != nil {
+		return nil, err
+	}
+	k := 0 // merges to apply
+	for k < len(merges) && merges[k].dist <= threshold {
+		k++
+	}
+	n := len(vectors)
+	if hi > 0 && n-k > hi {
+		k = n - hi
+	}
+	if lo > 0 && n-k < lo {
+		k = max(n-lo, 0)
+	}
+
+	parent := make([]int, len(vectors))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for _, m := range merges[:k] {
+		parent[find(m.b)] = find(m.a)
+	}
+
+	labels := make([]int, len(vectors))
+	ids := map[int]int{}
+	for i := range vectors {
+		root := find(i)
+		id, ok := ids[root]
+		if !ok {
+			id = len(ids)
+			ids[root] = id
+		}
+		labels[i] = id
+	}
+	return labels, nil
+}
+
+// linkage builds the average-linkage dendrogram of vectors with the
+// nearest-neighbour chain algorithm and returns its merges by increasing
+// distance. With unit vectors, the mean cosine similarity between two
+// clusters is the dot product of their sums over the product of their
+// sizes, so no distance matrix is kept. ctx is checked at every step, since
+// the whole run is quadratic in the number of vectors.
+func linkage(ctx context.Context, vectors [][]float32) ([]merge, error) {
+	n := len(vectors)
+	sums := make([][]float64, n)
+	sizes := make([]float64, n)
+	for i, v := range vectors {
+		sums[i] = normalize(v)
+		sizes[i] = 1
+	}
+	active := make([]bool, n)
+	for i := range active {
+		active[i] = true
+	}
+	dist := func(a, b int) float64 {
+		var dot float64
+		for k := range sums[a] {
+			dot += sums[a][k] * sums[b][k]
+		}
+		return 1 - dot/(sizes[a]*sizes[b])
+	}
+
+	merges := make([]merge, 0, max(n-1, 0))
+	var chain []int
+	for remaining := n; remaining > 1; {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if len(chain) == 0 {
+			for i := range active {
+				if active[i] {
+					chain = append(chain, i)
+					break
+				}
+			}
+		}
+		a := chain[len(chain)-1]
+		// Prefer the previous chain element on ties so the chain ends.
+		best, bestDist := -1, math.Inf(1)
+		if len(chain) > 1 {
+			best = chain[len(chain)-2]
+			bestDist = dist(a, best)
+		}
+		for b := range active {
+			if active[b] && b != a {
+				if d := dist(a, b); d < bestDist {
+					best, bestDist = b, d
+				}
+			}
+		}
+		if len(chain) > 1 && best == chain[len(chain)-2] {
+			chain = chain[:len(chain)-2]
+			a, b := min(a, best), max(a, best)
+			merges = append(merges, merge{a, b, bestDist})
+			for k := range sums[a] {
+				sums[a][k] += sums[b][k]
+			}
+			sizes[a] += sizes[b]
+			active[b] = false
+			remaining--
+			continue
+		}
+		chain = append(chain, best)
+	}
+	slices.SortStableFunc(merges, func(x, y merge) int {
+		switch {
+		case x.dist < y.dist:
+			return -1
+		case x.dist > y.dist:
+			return 1
+		}
+		return 0
+	})
+	return merges, nil
+}
+
+// normalize returns v scaled to unit length.
+func normalize(v []float32) []float64 {
+	var norm float64
+	for _, x := range v {
+		norm += float64(x) * float64(x)
+	}
+	norm = math.Sqrt(norm)
+	out := make([]float64, len(v))
+	if norm == 0 {
+		return out
+	}
+	for i, x := range v {
+		out[i] = float64(x) / norm
+	}
+	return out
+}
@@ -1,11 +1,8 @@
 package diarize
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"os"
-	"os/exec"
-	"path/filepath"
 )
 
 // Segment represents a speaker segment from diarization.
@@ -13,66 +10,43 @@ type Segment struct {
 	Start     float64 `json:"start"`
 	End       float64 `json:"end"`
 	SpeakerID int     `json:"speaker_id"`
-}
-
-// findDiarizer checks for sona-diarize in this order:
-// 1. System sona-diarize from $PATH
-// 2. SONA_DIARIZE_PATH env var (warns and continues if set but not found)
-// 3. Bundled sona-diarize next to the current binary
-func findDiarizer() (string, error) {
-	path, err := exec.LookPath("sona-diarize")
-	if err == nil {
-		return path, nil
-	}
 
-	if envPath := os.Getenv("SONA_DIARIZE_PATH"); envPath != "" {
-		if _, statErr := os.Stat(envPath); statErr == nil {
-			return envPath, nil
-		}
-		fmt.Fprintf(os.Stderr, "warning: SONA_DIARIZE_PATH set to %q but not found, continuing search\n", envPath)
-	}
-
-	if exe, exErr := os.Executable(); exErr == nil {
-		candidates := []string{
-			filepath.Join(filepath.Dir(exe), "sona-diarize"),
-			filepath.Join(filepath.Dir(exe), "sona-diarize.exe"),
-		}
-		for _, candidate := range candidates {
-			if _, statErr := os.Stat(candidate); statErr == nil {
-				return candidate, nil
-			}
-		}
-	}
-
-	return "", fmt.Errorf("sona-diarize not found: %w", err)
+	// Set by Identify when the speaker matches an enrolled profile.
+	Name       string  `json:"name,omitempty"`
+	Similarity float64 `json:"similarity,omitempty"`
 }
 
-// Available reports whether the sona-diarize binary can be found.
-func Available() bool {
-	_, err := findDiarizer()
-	return err == nil
+// Diarizer finds who speaks when in a 16 kHz mono WAV file on disk,
+// within the constraints of opts. Speaker IDs start at 0 in order of first
+// appearance and are only meaningful within one result. Diarize stops
+// early with ctx's error once ctx is done.
+type Diarizer interface {
+	Diarize(ctx context.Context, audioPath string, opts Options) ([]Segment, error)
 }
 
-// Diarize runs sona-diarize on the given audio file using the given model
-// and returns speaker segments. The audioPath must be a WAV file on disk.
-func Diarize(modelPath, audioPath string) ([]Segment, error) {
-	binPath, err := findDiarizer()
-	if err != nil {
-		return nil, err
-	}
-
-	cmd := exec.Command(binPath, modelPath, audioPath)
-	cmd.Stderr = os.Stderr
-
-	out, err := cmd.Output()
-	if err != nil {
-		return nil, fmt.Errorf("sona-diarize failed: %w", err)
-	}
+// EmbeddingDiarizer is a Diarizer that also returns each speaker's voice
+// embedding, so Identify needs no second pass over the audio.
+type EmbeddingDiarizer interface {
+	Diarizer
+	DiarizeEmbeddings(ctx context.Context, audioPath string, opts Options) ([]Segment, map[int][]float32, error)
+	EmbedderName() string
+}
 
-	var segments []Segment
-	if err := json.Unmarshal(out, &segments); err != nil {
-		return nil, fmt.Errorf("sona-diarize returned invalid JSON: %w", err)
+// New returns the diarizer with the given name: "sona-diarize" (the
+// default for "", and the recommended one) runs the external binary with
+// modelPath, and "native" runs the in-process Pipeline with
+// SpectralEmbedder. Native is a simple built-in mode for when no model or
+// binary is at hand: it has no speaker embedding model, only separates
+// clearly different voices, and is never chosen by default.
+func New(name, modelPath string) (Diarizer, error) {
+	switch name {
+	case "", "sona-diarize":
+		if modelPath == "" {
+			return nil, fmt.Errorf("sona-diarize needs a model")
+		}
+		return Subprocess{ModelPath: modelPath}, nil
+	case "native":
+		return NewPipeline(), nil
 	}
-
-	return segments, nil
+	return nil, fmt.Errorf("unknown diarizer %q (want sona-diarize or native)", name)
 }
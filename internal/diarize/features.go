@@ -0,0 +1,210 @@
+package diarize
+
+import (
+	"math"
+	"math/cmplx"
+	"slices"
+
+	"github.com/thewh1teagle/sona/internal/wav"
+)
+
+// Analysis frames: 25 ms every 10 ms, zero-padded to fftSize.
+const (
+	frameLen   = wav.SampleRate * 25 / 1000
+	frameShift = wav.SampleRate * 10 / 1000
+	fftSize    = 512
+)
+
+// EnergySegmenter marks as speech the frames well above the recording's
+// noise floor, fills short pauses and drops short bursts.
+type EnergySegmenter struct {
+	MinSpeech  float64 // seconds; shorter bursts are dropped (0 = 0.25)
+	MinSilence float64 // seconds; shorter pauses are filled (0 = 0.3)
+}
+
+func (e EnergySegmenter) Speech(samples []float32) ([]Span, error) {
+	minSpeech, minSilence := e.MinSpeech, e.MinSilence
+	if minSpeech == 0 {
+		minSpeech = 0.25
+	}
+	if minSilence == 0 {
+		minSilence = 0.3
+	}
+
+	n := len(samples) / frameShift
+	if n == 0 {
+		return nil, nil
+	}
+	levels := make([]float64, n)
+	for i := range levels {
+		frame := samples[i*frameShift : min(i*frameShift+frameLen, len(samples))]
+		var sum float64
+		for _, s := range frame {
+			sum += float64(s) * float64(s)
+		}
+		levels[i] = 10 * math.Log10(sum/float64(len(frame))+1e-10)
+	}
+
+	// Speech sits 10 dB over the noise floor, but never more than 20 dB
+	// under the loudest frame (audio with no pauses is all speech) and
+	// never under -55 dBFS.
+	sorted := slices.Clone(levels)
+	slices.Sort(sorted)
+	floor, peak := sorted[n/10], sorted[n-1]
+	threshold := max(-55, min(floor+10, peak-20))
+
+	var frames []Span // runs of speech frames
+	for i := 0; i < n; i++ {
+		if levels[i] <= threshold {
+			continue
+		}
+		j := i
+		for j < n && levels[j] > threshold {
+			j++
+		}
+		if last := len(frames) - 1; last >= 0 && float64(i-frames[last].End)*frameShift < minSilence*wav.SampleRate {
+			frames[last].End = j
+		} else {
+			frames = append(frames, Span{i, j})
+		}
+		i = j
+	}
+
+	var speech []Span
+	for _, f := range frames {
+		span := Span{f.Start * frameShift, min(f.End*frameShift+frameLen, len(samples))}
+		if float64(span.End-span.Start) >= minSpeech*wav.SampleRate {
+			speech = append(speech, span)
+		}
+	}
+	return speech, nil
+}
+
+// SpectralEmbedder describes a voice by the mean and standard deviation of
+// its mel-frequency cepstral coefficients. It is a simple built-in
+// feature, not a speaker embedding model: it separates speakers with
+// clearly different voices, while a neural Embedder would do much better
+// on similar ones.
+type SpectralEmbedder struct{}
+
+const (
+	melBands = 40
+	cepstra  = 20 // c1..c19 are kept; c0 only tracks loudness
+)
+
+var melFilters = buildMelFilters(melBands, fftSize, wav.SampleRate, 20, 7600)
+
+func (SpectralEmbedder) Name() string { return "spectral-mfcc" }
+
+func (SpectralEmbedder) Embed(samples []float32) ([]float32, error) {
+	var frames [][]float64
+	buf := make([]complex128, fftSize)
+	logMel := make([]float64, melBands)
+	for start := 0; start+frameLen <= len(samples); start += frameShift {
+		for i := range buf {
+			buf[i] = 0
+		}
+		for i, s := range samples[start : start+frameLen] {
+			hamming := 0.54 - 0.46*math.Cos(2*math.Pi*float64(i)/(frameLen-1))
+			buf[i] = complex(float64(s)*hamming, 0)
+		}
+		fft(buf)
+		for b, filter := range melFilters {
+			var energy float64
+			for k, w := range filter {
+				if w != 0 {
+					energy += w * real(buf[k]*cmplx.Conj(buf[k]))
+				}
+			}
+			logMel[b] = math.Log(energy + 1e-10)
+		}
+		c := dct(logMel, cepstra)
+		for k := range c { // lifter: without it c1 dominates the distance
+			c[k] *= float64(k)
+		}
+		frames = append(frames, c[1:])
+	}
+
+	dims := cepstra - 1
+	embedding := make([]float32, 2*dims)
+	if len(frames) == 0 {
+		return embedding, nil
+	}
+	for k := 0; k < dims; k++ {
+		var mean, sq float64
+		for _, f := range frames {
+			mean += f[k]
+		}
+		mean /= float64(len(frames))
+		for _, f := range frames {
+			sq += (f[k] - mean) * (f[k] - mean)
+		}
+		embedding[k] = float32(mean)
+		embedding[dims+k] = float32(math.Sqrt(sq / float64(len(frames))))
+	}
+	return embedding, nil
+}
+
+// buildMelFilters returns triangular filters over the power spectrum of
+// an n-point FFT, evenly spaced on the mel scale from lo to hi Hz.
+func buildMelFilters(bands, n, rate int, lo, hi float64) [][]float64 {
+	mel := func(hz float64) float64 { return 2595 * math.Log10(1+hz/700) }
+	hz := func(m float64) float64 { return 700 * (math.Pow(10, m/2595) - 1) }
+	edges := make([]float64, bands+2) // in FFT bins
+	for i := range edges {
+		m := mel(lo) + (mel(hi)-mel(lo))*float64(i)/float64(bands+1)
+		edges[i] = hz(m) * float64(n) / float64(rate)
+	}
+	filters := make([][]float64, bands)
+	for b := range filters {
+		filters[b] = make([]float64, n/2+1)
+		left, center, right := edges[b], edges[b+1], edges[b+2]
+		for k := range filters[b] {
+			x := float64(k)
+			switch {
+			case x > left && x <= center:
+				filters[b][k] = (x - left) / (center - left)
+			case x > center && x < right:
+				filters[b][k] = (right - x) / (right - center)
+			}
+		}
+	}
+	return filters
+}
+
+// dct returns the first n coefficients of the DCT-II of x.
+func dct(x []float64, n int) []float64 {
+	out := make([]float64, n)
+	for k := range out {
+		for i, v := range x {
+			out[k] += v * math.Cos(math.Pi*float64(k)*(float64(i)+0.5)/float64(len(x)))
+		}
+	}
+	return out
+}
+
+// fft transforms x in place. len(x) must be a power of two.
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j |= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a, b := x[start+k], w*x[start+k+size/2]
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				w *= step
+			}
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package diarize
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/thewh1teagle/sona/internal/wav"
+)
+
+// Span is a stretch of audio as sample indices, End exclusive.
+type Span struct{ Start, End int }
+
+// Segmenter finds the speech in 16 kHz mono audio.
+type Segmenter interface {
+	Speech(samples []float32) ([]Span, error)
+}
+
+// Embedder maps a stretch of 16 kHz mono speech to a vector that is close,
+// by cosine distance, for the same speaker. A neural speaker embedding
+// model (ONNX or ggml) plugs in here.
+type Embedder interface {
+	Embed(samples []float32) ([]float32, error)
+	// Name identifies the embedding space. Embeddings are only comparable
+	// between embedders of the same name.
+	Name() string
+}
+
+// Pipeline diarizes in-process: the Segmenter finds speech, each speech
+// region is cut into overlapping windows, the Embedder maps every window
+// to a vector, and the vectors are clustered by average-linkage cosine
+// distance. Adjacent windows of the same cluster form one Segment.
+type Pipeline struct {
+	Segmenter Segmenter
+	Embedder  Embedder
+	Window    float64 // seconds of audio per embedding
+	Hop       float64 // seconds between window starts
+	Threshold float64 // cosine distance at which clusters stop merging
+}
+
+// Pipeline defaults, tuned for SpectralEmbedder.
+const (
+	defaultWindow    = 1.5
+	defaultHop       = 0.75
+	defaultThreshold = 0.3
+	minWindow        = 0.4 // shorter speech regions are not embedded
+)
+
+// NewPipeline returns the simple built-in Pipeline, made only of Go code:
+// EnergySegmenter and SpectralEmbedder. It needs no extra binary or model
+// file but is far less accurate than sona-diarize's neural model; no ONNX
+// or ggml Embedder is implemented, as the build has no runtime to load one.
+func NewPipeline() *Pipeline {
+	return &Pipeline{
+		Segmenter: EnergySegmenter{},
+		Embedder:  SpectralEmbedder{},
+		Window:    defaultWindow,
+		Hop:       defaultHop,
+		Threshold: defaultThreshold,
+	}
+}
+
+// Diarize reads a WAV file and diarizes it. The whole file is decoded into
+// memory. Speaker counts in opts bound the clustering. Reading, embedding
+// and clustering all stop once ctx is done.
+func (p *Pipeline) Diarize(ctx context.Context, audioPath string, opts Options) ([]Segment, error) {
+	segments, _, err := p.DiarizeEmbeddings(ctx, audioPath, opts)
+	return segments, err
+}
+
+// DiarizeEmbeddings is Diarize that also returns each speaker's voice
+// embedding, the mean of its windows' embeddings, for Identify.
+func (p *Pipeline) DiarizeEmbeddings(ctx context.Context, audioPath string, opts Options) ([]Segment, map[int][]float32, error) {
+	samples, err := readWAV(ctx, audioPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read audio for diarization: %w", err)
+	}
+	return p.diarizeSamples(ctx, samples, opts)
+}
+
+// EmbedderName names the Embedder, to tell which enrolled profiles its
+// embeddings can be compared with.
+func (p *Pipeline) EmbedderName() string {
+	return p.Embedder.Name()
+}
+
+// readWAV decodes the WAV file at path, giving up once ctx is done.
+func readWAV(ctx context.Context, path string) ([]float32, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return wav.Read(ctxReader{ctx, f})
+}
+
+// ctxReader fails reads with ctx's error once ctx is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// windows embeds the speech in samples in overlapping windows. Each window
+// owns the audio up to the next window's start, so owned ranges tile every
+// speech region without overlap.
+func (p *Pipeline) windows(ctx context.Context, samples []float32) ([]Span, [][]float32, error) {
+	speech, err := p.Segmenter.Speech(samples)
+	if err != nil {
+		return nil, nil, err
+	}
+	window := int(p.Window * wav.SampleRate)
+	hop := int(p.Hop * wav.SampleRate)
+	var owned []Span
+	var embeddings [][]float32
+	for _, region := range speech {
+		if region.End-region.Start < int(minWindow*wav.SampleRate) {
+			continue
+		}
+		for start := region.Start; start < region.End; start += hop {
+			end := min(start+window, region.End)
+			if start > region.Start && end-start < window {
+				// Fold a short tail into the previous window.
+				owned[len(owned)-1].End = region.End
+				break
+			}
+			if err := ctx.Err(); err != nil {
+				return nil, nil, err
+			}
+			e, err := p.Embedder.Embed(samples[start:end])
+			if err != nil {
+				return nil, nil, err
+			}
+			embeddings = append(embeddings, e)
+			owned = append(owned, Span{start, min(start+hop, region.End)})
+			if end == region.End {
+				owned[len(owned)-1].End = region.End
+				break
+			}
+		}
+	}
+	return owned, embeddings, nil
+}
+
+func (p *Pipeline) diarizeSamples(ctx context.Context, samples []float32, opts Options) ([]Segment, map[int][]float32, error) {
+	owned, embeddings, err := p.windows(ctx, samples)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lo, hi := opts.speakerRange()
+	labels, err := cluster(ctx, embeddings, p.Threshold, lo, hi)
+	if err != nil {
+		return nil, nil, err
+	}
+	var segments []Segment
+	for i, span := range owned {
+		start := float64(span.Start) / wav.SampleRate
+		end := float64(span.End) / wav.SampleRate
+		if last := len(segments) - 1; last >= 0 && segments[last].SpeakerID == labels[i] && owned[i-1].End == span.Start {
+			segments[last].End = end
+			continue
+		}
+		segments = append(segments, Segment{Start: start, End: end, SpeakerID: labels[i]})
+	}
+	segments = postprocess(segments, opts)
+
+	// Post-processing merges and renumbers speakers, so windows are
+	// grouped by the final segment they fall in.
+	bySpeaker := map[int][][]float32{}
+	for i, span := range owned {
+		mid := float64(span.Start+span.End) / 2 / wav.SampleRate
+		for _, seg := range segments {
+			if mid >= seg.Start && mid < seg.End {
+				bySpeaker[seg.SpeakerID] = append(bySpeaker[seg.SpeakerID], embeddings[i])
+				break
+			}
+		}
+	}
+	voices := make(map[int][]float32, len(bySpeaker))
+	for id, e := range bySpeaker {
+		voices[id] = meanEmbedding(e)
+	}
+	return segments, voices, nil
+}
+
+// EmbedSpeech embeds the speech in 16 kHz mono samples as one voice, the
+// way DiarizeEmbeddings embeds each speaker: the mean of its windows'
+// embeddings.
+func (p *Pipeline) EmbedSpeech(samples []float32) ([]float32, error) {
+	_, embeddings, err := p.windows(context.Background(), samples)
+	if err != nil {
+		return nil, err
+	}
+	if len(embeddings) == 0 {
+		return nil, errors.New("not enough speech in the sample")
+	}
+	return meanEmbedding(embeddings), nil
+}
+
+// meanEmbedding averages unit-length copies of vectors, so every window
+// counts the same.
+func meanEmbedding(vectors [][]float32) []float32 {
+	mean := make([]float32, len(vectors[0]))
+	for _, v := range vectors {
+		for k, x := range normalize(v) {
+			mean[k] += float32(x) / float32(len(vectors))
+		}
+	}
+	return mean
+}
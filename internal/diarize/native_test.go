@@ -0,0 +1,114 @@
+package diarize
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/thewh1teagle/sona/internal/wav"
+)
+
+// voice returns seconds of a synthetic voice: a glottal pulse train at f0
+// shaped by two formant resonators, with a slow pitch wobble.
+func voice(f0, f1, f2, seconds float64) []float32 {
+	n := int(seconds * wav.SampleRate)
+	out := make([]float32, n)
+	type resonator struct{ a1, a2, y1, y2 float64 }
+	newResonator := func(freq, bw float64) *resonator {
+		r := math.Exp(-math.Pi * bw / wav.SampleRate)
+		return &resonator{a1: 2 * r * math.Cos(2*math.Pi*freq/wav.SampleRate), a2: -r * r}
+	}
+	formants := []*resonator{newResonator(f1, 80), newResonator(f2, 120)}
+	phase := 0.0
+	for i := range out {
+		pitch := f0 * (1 + 0.05*math.Sin(2*math.Pi*3*float64(i)/wav.SampleRate))
+		phase += pitch / wav.SampleRate
+		x := 0.0
+		if phase >= 1 {
+			phase--
+			x = 1
+		}
+		for _, f := range formants {
+			y := x + f.a1*f.y1 + f.a2*f.y2
+			f.y2, f.y1 = f.y1, y
+			x = y
+		}
+		out[i] = float32(x)
+	}
+	// Scale to a speech-like level.
+	var peak float64
+	for _, s := range out {
+		peak = max(peak, math.Abs(float64(s)))
+	}
+	for i := range out {
+		out[i] = float32(float64(out[i]) / peak * 0.5)
+	}
+	return out
+}
+
+func TestPipelineSeparatesVoices(t *testing.T) {
+	low := func() []float32 { return voice(100, 500, 1500, 4) }
+	high := func() []float32 { return voice(220, 800, 2400, 4) }
+	pause := make([]float32, wav.SampleRate/2)
+
+	var samples []float32
+	for _, part := range [][]float32{low(), pause, high(), pause, low()} {
+		samples = append(samples, part...)
+	}
+	segments, _, err := NewPipeline().diarizeSamples(context.Background(), samples, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range segments {
+		got = append(got, fmt.Sprintf("%d@%.1f", s.SpeakerID, s.Start))
+	}
+	if want := "[0@0.0 1@4.5 0@9.0]"; fmt.Sprint(got) != want {
+		t.Errorf("segments = %v, want %s", got, want)
+	}
+}
+
+// clusterLabels formats the labels of an uncancelled clustering.
+func clusterLabels(vectors [][]float32, threshold float64, lo, hi int) string {
+	labels, err := cluster(context.Background(), vectors, threshold, lo, hi)
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprint(labels)
+}
+
+func TestCluster(t *testing.T) {
+	vectors := [][]float32{{1, 0}, {0, 1}, {0.9, 0.1}, {0.1, 0.9}, {1, 0.05}}
+	if got := clusterLabels(vectors, 0.1, 0, 0); got != "[0 1 0 1 0]" {
+		t.Errorf("cluster = %s, want [0 1 0 1 0]", got)
+	}
+	if got := clusterLabels(vectors, 2, 0, 0); got != "[0 0 0 0 0]" {
+		t.Errorf("cluster with a loose threshold = %s, want one cluster", got)
+	}
+}
+
+func TestClusterSpeakerBounds(t *testing.T) {
+	vectors := [][]float32{{1, 0}, {0, 1}, {0.9, 0.1}, {0.1, 0.9}, {1, 0.05}}
+	if got := clusterLabels(vectors, 0.1, 0, 1); got != "[0 0 0 0 0]" {
+		t.Errorf("cluster with max 1 = %s, want one cluster", got)
+	}
+	if got := clusterLabels(vectors, 2, 2, 2); got != "[0 1 0 1 0]" {
+		t.Errorf("cluster with exactly 2 = %s, want [0 1 0 1 0]", got)
+	}
+}
+
+func TestPipelineCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := NewPipeline().diarizeSamples(ctx, voice(100, 500, 1500, 3), Options{}); err != context.Canceled {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if _, err := linkage(ctx, [][]float32{{1, 0}, {0, 1}}); err != context.Canceled {
+		t.Errorf("linkage err = %v, want context.Canceled", err)
+	}
+	if _, err := NewPipeline().Diarize(ctx, "missing.wav", Options{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Diarize err = %v, want context.Canceled before the file is read", err)
+	}
+}
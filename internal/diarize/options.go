@@ -0,0 +1,157 @@
+package diarize
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+// Options constrains a diarization. Zero values leave a setting to the
+// diarizer.
+type Options struct {
+	NumSpeakers        int     // exact number of speakers; overrides Min/MaxSpeakers
+	MinSpeakers        int     // at least this many speakers
+	MaxSpeakers        int     // at most this many speakers
+	MinSegmentDuration float64 // seconds; shorter turns join a neighbouring speaker
+}
+
+// Validate reports inconsistent options.
+func (o Options) Validate() error {
+	switch {
+	case o.NumSpeakers < 0 || o.MinSpeakers < 0 || o.MaxSpeakers < 0:
+		return fmt.Errorf("speaker counts must not be negative")
+	case o.MaxSpeakers > 0 && o.MinSpeakers > o.MaxSpeakers:
+		return fmt.Errorf("min_speakers %d exceeds max_speakers %d", o.MinSpeakers, o.MaxSpeakers)
+	case o.MinSegmentDuration < 0:
+		return fmt.Errorf("min_segment_duration must not be negative")
+	}
+	return nil
+}
+
+// ValidateFor is Validate plus the limits of d. sona-diarize's Sortformer
+// model tracks at most subprocessSpeakers speakers and cannot be asked for
+// a minimum, so MinSpeakers is rejected rather than silently dropped.
+func (o Options) ValidateFor(d Diarizer) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	if _, ok := d.(Subprocess); !ok {
+		return nil
+	}
+	switch {
+	case o.MinSpeakers > 0:
+		return fmt.Errorf("min_speakers is not supported by sona-diarize (only by the simple built-in diarizer=native)")
+	case o.NumSpeakers > subprocessSpeakers:
+		return fmt.Errorf("sona-diarize tracks at most %d speakers", subprocessSpeakers)
+	}
+	return nil
+}
+
+// speakerRange returns the allowed number of speakers; hi is 0 when there
+// is no upper bound.
+func (o Options) speakerRange() (lo, hi int) {
+	if o.NumSpeakers > 0 {
+		return o.NumSpeakers, o.NumSpeakers
+	}
+	return o.MinSpeakers, o.MaxSpeakers
+}
+
+// postprocess applies opts to segments from any diarizer: speakers beyond
+// the upper bound are folded into the nearest remaining speaker, starting
+// with the one who talks least, turns shorter than MinSegmentDuration join
+// the speaker before them, and speakers are renumbered in order of first
+// appearance. The lower bound cannot be enforced after the fact.
+func postprocess(segments []Segment, opts Options) []Segment {
+	segments = slices.Clone(segments)
+	slices.SortStableFunc(segments, func(a, b Segment) int {
+		switch {
+		case a.Start < b.Start:
+			return -1
+		case a.Start > b.Start:
+			return 1
+		}
+		return 0
+	})
+
+	if _, hi := opts.speakerRange(); hi > 0 {
+		limitSpeakers(segments, hi)
+	}
+	if opts.MinSegmentDuration > 0 {
+		for i := range segments {
+			if segments[i].End-segments[i].Start >= opts.MinSegmentDuration {
+				continue
+			}
+			switch {
+			case i > 0:
+				segments[i].SpeakerID = segments[i-1].SpeakerID
+			case i+1 < len(segments):
+				segments[i].SpeakerID = segments[i+1].SpeakerID
+			}
+		}
+	}
+
+	// Join consecutive turns of one speaker separated by less than a
+	// minimum segment.
+	var out []Segment
+	for _, seg := range segments {
+		if last := len(out) - 1; last >= 0 && out[last].SpeakerID == seg.SpeakerID && seg.Start-out[last].End <= opts.MinSegmentDuration {
+			out[last].End = max(out[last].End, seg.End)
+			continue
+		}
+		out = append(out, seg)
+	}
+	renumber(out)
+	return out
+}
+
+// limitSpeakers relabels segments, sorted by start, until at most n
+// speakers remain. Each segment of the least talkative speaker goes to the
+// speaker of the nearest segment in time.
+func limitSpeakers(segments []Segment, n int) {
+	for {
+		talk := map[int]float64{}
+		for _, seg := range segments {
+			talk[seg.SpeakerID] += seg.End - seg.Start
+		}
+		if len(talk) <= n {
+			return
+		}
+		quietest := -1
+		for id, d := range talk {
+			if quietest < 0 || d < talk[quietest] || d == talk[quietest] && id > quietest {
+				quietest = id
+			}
+		}
+		relabeled := slices.Clone(segments)
+		for i, seg := range segments {
+			if seg.SpeakerID != quietest {
+				continue
+			}
+			best, bestGap := -1, math.Inf(1)
+			for _, other := range segments {
+				if other.SpeakerID == quietest {
+					continue
+				}
+				gap := max(other.Start-seg.End, seg.Start-other.End, 0)
+				if gap < bestGap {
+					best, bestGap = other.SpeakerID, gap
+				}
+			}
+			relabeled[i].SpeakerID = best
+		}
+		copy(segments, relabeled)
+	}
+}
+
+// renumber relabels speakers 0, 1, ... in order of first appearance.
+func renumber(segments []Segment) {
+	ids := map[int]int{}
+	for i, seg := range segments {
+		id, ok := ids[seg.SpeakerID]
+		if !ok {
+			id = len(ids)
+			ids[seg.SpeakerID] = id
+		}
+		segments[i].SpeakerID = id
+	}
+}
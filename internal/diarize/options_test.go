@@ -0,0 +1,70 @@
+package diarize
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPostprocess(t *testing.T) {
+	segments := []Segment{
+		{Start: 0, End: 4, SpeakerID: 2},
+		{Start: 4, End: 4.2, SpeakerID: 0}, // quietest speaker: folded into speaker 2, the first nearest
+		{Start: 4.2, End: 8, SpeakerID: 1},
+		{Start: 8, End: 9, SpeakerID: 3}, // next quietest: folded into speaker 1
+		{Start: 12, End: 15, SpeakerID: 2},
+	}
+	got := postprocess(segments, Options{MaxSpeakers: 2, MinSegmentDuration: 0.5})
+	want := []Segment{{Start: 0, End: 4.2, SpeakerID: 0}, {Start: 4.2, End: 9, SpeakerID: 1}, {Start: 12, End: 15, SpeakerID: 0}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("postprocess = %v, want %v", got, want)
+	}
+}
+
+func TestPostprocessMinSegment(t *testing.T) {
+	segments := []Segment{{Start: 0, End: 3, SpeakerID: 0}, {Start: 3, End: 3.2, SpeakerID: 1}, {Start: 3.2, End: 6, SpeakerID: 0}, {Start: 6, End: 9, SpeakerID: 1}}
+	got := postprocess(segments, Options{MinSegmentDuration: 0.5})
+	want := []Segment{{Start: 0, End: 6, SpeakerID: 0}, {Start: 6, End: 9, SpeakerID: 1}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("postprocess = %v, want %v", got, want)
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	if err := (Options{MinSpeakers: 3, MaxSpeakers: 2}).Validate(); err == nil {
+		t.Error("min_speakers > max_speakers accepted")
+	}
+	if err := (Options{NumSpeakers: 2, MinSegmentDuration: 0.5}).Validate(); err != nil {
+		t.Errorf("valid options rejected: %v", err)
+	}
+}
+
+func TestOptionsValidateFor(t *testing.T) {
+	sub := Subprocess{ModelPath: "model.onnx"}
+	for _, opts := range []Options{{MinSpeakers: 2}, {NumSpeakers: 5}} {
+		if err := opts.ValidateFor(sub); err == nil {
+			t.Errorf("sona-diarize accepted %+v", opts)
+		}
+		if err := opts.ValidateFor(NewPipeline()); err != nil {
+			t.Errorf("native rejected %+v: %v", opts, err)
+		}
+	}
+	if err := (Options{MaxSpeakers: 8}).ValidateFor(sub); err != nil {
+		t.Errorf("sona-diarize rejected max_speakers: %v", err)
+	}
+}
+
+func TestSubprocessArgs(t *testing.T) {
+	tests := []struct {
+		opts Options
+		want string
+	}{
+		{Options{}, "[m.onnx a.wav]"},
+		{Options{MaxSpeakers: 3}, "[m.onnx a.wav --max-speakers 3]"},
+		{Options{NumSpeakers: 2, MaxSpeakers: 3}, "[m.onnx a.wav --num-speakers 2]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(subprocessArgs("m.onnx", "a.wav", tt.opts)); got != tt.want {
+			t.Errorf("subprocessArgs(%+v) = %s, want %s", tt.opts, got, tt.want)
+		}
+	}
+}
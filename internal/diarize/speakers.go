@@ -0,0 +1,230 @@
+package diarize
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/thewh1teagle/sona/internal/wav"
+)
+
+// Profile is an enrolled speaker: the mean embedding of their voice
+// samples, and the Embedder that produced it.
+type Profile struct {
+	Name      string    `json:"name"`
+	Embedder  string    `json:"embedder"` // Embedder.Name; other embedders' vectors are not comparable
+	Embedding []float32 `json:"embedding"`
+	Samples   int       `json:"samples"` // enrollment clips averaged into Embedding
+}
+
+// Store keeps enrolled speaker profiles in a JSON file. It is safe for
+// concurrent use.
+type Store struct {
+	mu       sync.Mutex
+	path     string
+	profiles []Profile // sorted by name
+}
+
+// OpenStore loads the profiles saved at path. A missing file is an empty
+// store; it is created on the first enrollment.
+func OpenStore(path string) (*Store, error) {
+	s := &Store{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.profiles); err != nil {
+		return nil, fmt.Errorf("invalid speaker store %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Profiles returns the enrolled speakers sorted by name.
+func (s *Store) Profiles() []Profile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.profiles)
+}
+
+// Enroll adds a voice sample's embedding, made by the named embedder, to
+// the named speaker, creating the profile if needed, and saves the store.
+// A speaker enrolled with another embedder must be removed first.
+func (s *Store) Enroll(name, embedder string, embedding []float32) (Profile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, found := slices.BinarySearchFunc(s.profiles, name, func(p Profile, name string) int {
+		return cmp.Compare(p.Name, name)
+	})
+	profiles := slices.Clone(s.profiles)
+	if !found {
+		profiles = slices.Insert(profiles, i, Profile{Name: name, Embedder: embedder, Embedding: make([]float32, len(embedding))})
+	}
+	p := profiles[i]
+	if p.Embedder != embedder {
+		return Profile{}, fmt.Errorf("%s was enrolled with the %q embedder, not %q; remove and re-enroll them", name, p.Embedder, embedder)
+	}
+	if len(p.Embedding) != len(embedding) {
+		return Profile{}, fmt.Errorf("embedding has %d dimensions, %s was enrolled with %d", len(embedding), name, len(p.Embedding))
+	}
+	// Running mean over the enrollment clips.
+	mean := make([]float32, len(embedding))
+	for k := range mean {
+		mean[k] = (p.Embedding[k]*float32(p.Samples) + embedding[k]) / float32(p.Samples+1)
+	}
+	p.Embedding, p.Samples = mean, p.Samples+1
+	profiles[i] = p
+	if err := s.save(profiles); err != nil {
+		return Profile{}, err
+	}
+	s.profiles = profiles
+	return p, nil
+}
+
+// Remove deletes the named speaker and reports whether it was enrolled.
+func (s *Store) Remove(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.profiles, func(p Profile) bool { return p.Name == name })
+	if i < 0 {
+		return false, nil
+	}
+	profiles := slices.Delete(slices.Clone(s.profiles), i, i+1)
+	if err := s.save(profiles); err != nil {
+		return false, err
+	}
+	s.profiles = profiles
+	return true, nil
+}
+
+// save writes profiles to a temp file next to the store and renames it
+// into place, so a crash never leaves a partial file.
+func (s *Store) save(profiles []Profile) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".speakers-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// DefaultThreshold is the cosine similarity a speaker embedding needs to
+// take an enrolled name. It is calibrated for SpectralEmbedder by
+// TestIdentifyThreshold: another take of the same voice scores above 0.98,
+// while a voice 10% higher with formants 4% up already reaches 0.97.
+const DefaultThreshold = 0.975
+
+// identifyAudio is how much of each speaker's audio SpeakerEmbeddings
+// embeds.
+const identifyAudio = 60 * wav.SampleRate
+
+// SpeakerEmbeddings embeds each speaker of segments, diarized from the WAV
+// file at audioPath, with p: up to a minute of their audio, embedded as
+// p.EmbedSpeech does. It is for diarizers that are not an
+// EmbeddingDiarizer; speakers with too little speech are left out.
+func SpeakerEmbeddings(ctx context.Context, audioPath string, segments []Segment, p *Pipeline) (map[int][]float32, error) {
+	if len(segments) == 0 {
+		return nil, nil
+	}
+	samples, err := readWAV(ctx, audioPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audio for speaker identification: %w", err)
+	}
+	audioBySpeaker := map[int][]float32{}
+	for _, seg := range segments {
+		a := audioBySpeaker[seg.SpeakerID]
+		start := min(int(seg.Start*wav.SampleRate), len(samples))
+		end := min(int(seg.End*wav.SampleRate), len(samples), start+identifyAudio-len(a))
+		audioBySpeaker[seg.SpeakerID] = append(a, samples[start:max(start, end)]...)
+	}
+	embeddings := map[int][]float32{}
+	for speaker, a := range audioBySpeaker {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		e, err := p.EmbedSpeech(a)
+		if err != nil {
+			continue // not enough speech to tell who it is
+		}
+		embeddings[speaker] = e
+	}
+	return embeddings, nil
+}
+
+// Identify names the speakers of segments after enrolled profiles, given
+// each speaker's voice embedding from the named embedder. A speaker takes
+// the name of the most similar profile by cosine similarity; a profile
+// names at most one speaker, best matches first, and only at or above
+// threshold. Profiles enrolled with another embedder are never compared.
+// Matched segments get Name and Similarity set.
+func Identify(segments []Segment, embeddings map[int][]float32, embedder string, profiles []Profile, threshold float64) []Segment {
+	type match struct {
+		speaker, profile int
+		similarity       float64
+	}
+	var matches []match
+	for speaker, e := range embeddings {
+		v := normalize(e)
+		for j, p := range profiles {
+			if p.Embedder != embedder || len(p.Embedding) != len(e) {
+				continue
+			}
+			var sim float64
+			for k, x := range normalize(p.Embedding) {
+				sim += x * v[k]
+			}
+			if sim >= threshold {
+				matches = append(matches, match{speaker, j, sim})
+			}
+		}
+	}
+	slices.SortFunc(matches, func(a, b match) int {
+		switch {
+		case a.similarity > b.similarity:
+			return -1
+		case a.similarity < b.similarity:
+			return 1
+		}
+		return a.speaker - b.speaker
+	})
+
+	named := map[int]match{}
+	used := map[int]bool{}
+	for _, m := range matches {
+		if _, ok := named[m.speaker]; ok || used[m.profile] {
+			continue
+		}
+		named[m.speaker] = m
+		used[m.profile] = true
+	}
+	out := slices.Clone(segments)
+	for i, seg := range out {
+		if m, ok := named[seg.SpeakerID]; ok {
+			out[i].Name = profiles[m.profile].Name
+			out[i].Similarity = m.similarity
+		}
+	}
+	return out
+}
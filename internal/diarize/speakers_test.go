@@ -0,0 +1,149 @@
+package diarize
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/thewh1teagle/sona/internal/wav"
+)
+
+func TestStoreEnroll(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sona", "speakers.json")
+	s, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Enroll("bob", "e1", []float32{1, 0})
+	s.Enroll("alice", "e1", []float32{0, 1})
+	if p, err := s.Enroll("alice", "e1", []float32{0, 3}); err != nil || p.Samples != 2 || p.Embedding[1] != 2 {
+		t.Fatalf("Enroll = %+v, %v; want 2 samples with mean [0 2]", p, err)
+	}
+	if _, err := s.Enroll("bob", "e1", []float32{1, 0, 0}); err == nil {
+		t.Error("Enroll accepted an embedding of another size")
+	}
+	if _, err := s.Enroll("bob", "e2", []float32{1, 0}); err == nil {
+		t.Error("Enroll accepted an embedding from another embedder")
+	}
+
+	// Profiles survive a reopen, sorted by name.
+	s, err = OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := s.Profiles(); len(p) != 2 || p[0].Name != "alice" || p[1].Name != "bob" || p[1].Embedder != "e1" {
+		t.Fatalf("Profiles = %+v", p)
+	}
+	if ok, err := s.Remove("bob"); !ok || err != nil {
+		t.Fatalf("Remove = %v, %v", ok, err)
+	}
+	if ok, _ := s.Remove("bob"); ok {
+		t.Error("Remove of a missing speaker reported true")
+	}
+}
+
+// Synthetic speakers for identification: near is a slightly different
+// voice from low, and each has a second take with a shifted pitch and
+// vowel, as another recording of the same person would.
+type testSpeaker struct{ f0, f1, f2 float64 }
+
+var (
+	lowSpeaker  = testSpeaker{100, 500, 1500}
+	nearSpeaker = testSpeaker{110, 520, 1550}
+	midSpeaker  = testSpeaker{130, 600, 1700}
+	highSpeaker = testSpeaker{220, 800, 2400}
+)
+
+func (s testSpeaker) take(seconds float64) []float32 {
+	return voice(s.f0, s.f1, s.f2, seconds)
+}
+
+func (s testSpeaker) otherTake(seconds float64) []float32 {
+	return voice(s.f0*1.03, s.f1*1.02, s.f2*0.98, seconds)
+}
+
+func similarity(a, b []float32) float64 {
+	var sim float64
+	for k, x := range normalize(a) {
+		sim += x * normalize(b)[k]
+	}
+	return sim
+}
+
+// TestIdentifyThreshold calibrates DefaultThreshold for SpectralEmbedder:
+// every speaker's second take clears it, and no other speaker does, down
+// to a voice 10% higher with formants 4% up.
+func TestIdentifyThreshold(t *testing.T) {
+	p := NewPipeline()
+	speakers := map[string]testSpeaker{"low": lowSpeaker, "near": nearSpeaker, "mid": midSpeaker, "high": highSpeaker}
+	for enrolledName, enrolled := range speakers {
+		profile, err := p.EmbedSpeech(enrolled.take(3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, s := range speakers {
+			e, err := p.EmbedSpeech(s.otherTake(5))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sim := similarity(profile, e)
+			if same := name == enrolledName; same != (sim >= DefaultThreshold) {
+				t.Errorf("%s against %s enrolled: similarity %.4f, want same speaker %v at threshold %v", name, enrolledName, sim, same, DefaultThreshold)
+			}
+		}
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	p := NewPipeline()
+	var profiles []Profile
+	for name, s := range map[string]testSpeaker{"low": lowSpeaker, "high": highSpeaker, "mid": midSpeaker} {
+		e, err := p.EmbedSpeech(s.take(3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		profiles = append(profiles, Profile{Name: name, Embedder: p.EmbedderName(), Embedding: e, Samples: 1})
+	}
+
+	// A meeting between high and near, who is not enrolled and must not
+	// be taken for low.
+	pause := make([]float32, wav.SampleRate/2)
+	var samples []float32
+	for _, part := range [][]float32{highSpeaker.otherTake(4), pause, nearSpeaker.otherTake(4)} {
+		samples = append(samples, part...)
+	}
+	path := filepath.Join(t.TempDir(), "meeting.wav")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wav.Write(f, samples)
+	f.Close()
+
+	segments, embeddings, err := p.DiarizeEmbeddings(context.Background(), path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(embeddings) != 2 {
+		t.Fatalf("got embeddings for %d speakers, want 2", len(embeddings))
+	}
+	got := Identify(segments, embeddings, p.EmbedderName(), profiles, DefaultThreshold)
+	if got[0].Name != "high" || got[0].Similarity < DefaultThreshold || got[len(got)-1].Name != "" {
+		t.Errorf("Identify = %+v, want high, then an unnamed speaker", got)
+	}
+
+	// The subprocess path embeds the same speakers from the audio.
+	fromAudio, err := SpeakerEmbeddings(context.Background(), path, segments, p)
+	if err != nil || len(fromAudio) != 2 {
+		t.Fatalf("SpeakerEmbeddings = %d speakers, %v", len(fromAudio), err)
+	}
+	if got := Identify(segments, fromAudio, p.EmbedderName(), profiles, DefaultThreshold); got[0].Name != "high" || got[len(got)-1].Name != "" {
+		t.Errorf("Identify from audio = %+v, want high, then an unnamed speaker", got)
+	}
+
+	// Profiles from another embedder are never compared.
+	if got := Identify(segments, embeddings, "other", profiles, 0); got[0].Name != "" {
+		t.Errorf("Identify with another embedder = %+v, want no names", got)
+	}
+}
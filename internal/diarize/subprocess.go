@@ -0,0 +1,101 @@
+package diarize
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// subprocessSpeakers is the most speakers sona-diarize's model tracks.
+const subprocessSpeakers = 4
+
+// findDiarizer checks for sona-diarize in this order:
+// 1. System sona-diarize from $PATH
+// 2. SONA_DIARIZE_PATH env var (warns and continues if set but not found)
+// 3. Bundled sona-diarize next to the current binary
+func findDiarizer() (string, error) {
+	path, err := exec.LookPath("sona-diarize")
+	if err == nil {
+		return path, nil
+	}
+
+	if envPath := os.Getenv("SONA_DIARIZE_PATH"); envPath != "" {
+		if _, statErr := os.Stat(envPath); statErr == nil {
+			return envPath, nil
+		}
+		fmt.Fprintf(os.Stderr, "warning: SONA_DIARIZE_PATH set to %q but not found, continuing search\n", envPath)
+	}
+
+	if exe, exErr := os.Executable(); exErr == nil {
+		candidates := []string{
+			filepath.Join(filepath.Dir(exe), "sona-diarize"),
+			filepath.Join(filepath.Dir(exe), "sona-diarize.exe"),
+		}
+		for _, candidate := range candidates {
+			if _, statErr := os.Stat(candidate); statErr == nil {
+				return candidate, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("sona-diarize not found: %w", err)
+}
+
+// Available reports whether the sona-diarize binary can be found.
+func Available() bool {
+	_, err := findDiarizer()
+	return err == nil
+}
+
+// Subprocess diarizes by running the sona-diarize binary, found by
+// findDiarizer, on a WAV file and parsing its JSON output.
+type Subprocess struct {
+	ModelPath string // model passed to sona-diarize
+}
+
+// Diarize runs sona-diarize on the given audio file and returns speaker
+// segments. The audioPath must be a WAV file on disk. NumSpeakers and
+// MaxSpeakers are passed to sona-diarize; MinSpeakers is not supported
+// (see Options.ValidateFor). The output is postprocessed like any
+// diarizer's. The process is killed when ctx is done.
+func (d Subprocess) Diarize(ctx context.Context, audioPath string, opts Options) ([]Segment, error) {
+	binPath, err := findDiarizer()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, binPath, subprocessArgs(d.ModelPath, audioPath, opts)...)
+	cmd.Stderr = os.Stderr
+	cmd.WaitDelay = time.Second // don't wait on pipes held open by orphaned children
+
+	out, err := cmd.Output()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, fmt.Errorf("sona-diarize stopped: %w", ctxErr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sona-diarize failed: %w", err)
+	}
+
+	var segments []Segment
+	if err := json.Unmarshal(out, &segments); err != nil {
+		return nil, fmt.Errorf("sona-diarize returned invalid JSON: %w", err)
+	}
+
+	return postprocess(segments, opts), nil
+}
+
+// subprocessArgs returns the sona-diarize command line for opts.
+func subprocessArgs(modelPath, audioPath string, opts Options) []string {
+	args := []string{modelPath, audioPath}
+	if opts.NumSpeakers > 0 {
+		args = append(args, "--num-speakers", strconv.Itoa(opts.NumSpeakers))
+	} else if opts.MaxSpeakers > 0 {
+		args = append(args, "--max-speakers", strconv.Itoa(opts.MaxSpeakers))
+	}
+	return args
+}
@@ -1,17 +1,15 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
-	"io"
-	"log"
 	"net/http"
-	"os"
+	"strconv"
+	"sync"
 	"sync/atomic"
-	"time"
 
-	"github.com/thewh1teagle/sona/internal/audio"
 	"github.com/thewh1teagle/sona/internal/diarize"
+	"github.com/thewh1teagle/sona/internal/transcript"
 	"github.com/thewh1teagle/sona/internal/whisper"
 )
 
@@ -22,14 +20,12 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 }
 
 // handleReady returns 200 if a model is loaded, 503 otherwise.
+// The reported model is the default one used when a request names none.
 func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	s.mu.Lock()
-	loaded := s.ctx != nil
-	name := s.modelName
-	s.mu.Unlock()
+	models := s.loadedModels()
 
-	if !loaded {
+	if len(models) == 0 {
 		w.WriteHeader(http.StatusServiceUnavailable)
 		json.NewEncoder(w).Encode(map[string]string{
 			"status":  "not_ready",
@@ -39,14 +35,17 @@ func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
 	}
 	json.NewEncoder(w).Encode(map[string]string{
 		"status": "ready",
-		"model":  name,
+		"model":  models[0].name,
 	})
 }
 
-// handleModelLoad loads a model from a path in the JSON body.
+// handleModelLoad loads a model from a path in the JSON body. The model is
+// registered under the optional name (default: file name), replacing any
+// model already loaded under it.
 func (s *Server) handleModelLoad(w http.ResponseWriter, r *http.Request) {
 	var body struct {
 		Path      string `json:"path"`
+		Name      string `json:"name,omitempty"`
 		GpuDevice *int   `json:"gpu_device,omitempty"` // optional; nil = whisper default
 		NoGpu     bool   `json:"no_gpu,omitempty"`
 	}
@@ -60,7 +59,8 @@ func (s *Server) handleModelLoad(w http.ResponseWriter, r *http.Request) {
 		gpuDevice = *body.GpuDevice
 	}
 
-	if err := s.LoadModel(body.Path, gpuDevice, body.NoGpu); err != nil {
+	name, err := s.LoadModel(body.Name, body.Path, gpuDevice, body.NoGpu)
+	if err != nil {
 		writeError(w, http.StatusInternalServerError, ErrCodeInternalError, "failed to load model: "+err.Error())
 		return
 	}
@@ -68,217 +68,95 @@ func (s *Server) handleModelLoad(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
 		"status": "loaded",
-		"model":  s.modelName,
+		"model":  name,
 	})
 }
 
-// handleModelUnload frees the loaded model.
+// handleModelUnload frees the model named in the path, or every loaded
+// model for DELETE /v1/models (idempotent).
 func (s *Server) handleModelUnload(w http.ResponseWriter, r *http.Request) {
-	s.UnloadModel()
+	if name := r.PathValue("name"); name != "" {
+		if !s.UnloadModel(name) {
+			writeError(w, http.StatusNotFound, ErrCodeModelNotFound, "model not loaded: "+name)
+			return
+		}
+	} else {
+		s.UnloadAll()
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "unloaded"})
 }
 
 // handleTranscription processes an audio file and returns the result
-// in the requested format. Rejects concurrent requests with 429.
+// in the requested format. Requests wait in a FIFO queue while another
+// transcription runs; 429 is returned only when the queue is full.
 func (s *Server) handleTranscription(w http.ResponseWriter, r *http.Request) {
-	// Reject if busy (one job at a time).
-	if !s.mu.TryLock() {
-		writeError(w, http.StatusTooManyRequests, ErrCodeBusy, "server is busy with another transcription")
-		return
-	}
-	defer s.mu.Unlock()
-
-	if s.ctx == nil {
+	if !s.hasModel() {
 		writeError(w, http.StatusServiceUnavailable, ErrCodeNoModel, "no model loaded")
 		return
 	}
 
-	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
-
-	file, _, err := r.FormFile("file")
-	if err != nil {
-		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "missing or invalid 'file' field: "+err.Error())
+	req, m, t := s.parseQueuedRequest(w, r)
+	if req == nil {
 		return
 	}
-	defer file.Close()
-
-	diarizeModel := r.FormValue("diarize_model")
-
-	// If diarization requested, save upload to temp file, then convert to
-	// native 16kHz mono PCM WAV so sona-diarize can read it. The converted
-	// file is also used for whisper (skips its own ffmpeg pass).
-	var tempAudioPath string
-	var fileReader io.ReadSeeker = file
-	if diarizeModel != "" {
-		tmp, tmpErr := os.CreateTemp("", "sona-diar-*.audio")
-		if tmpErr != nil {
-			writeError(w, http.StatusInternalServerError, ErrCodeInternalError, "failed to create temp file: "+tmpErr.Error())
-			return
-		}
-		defer os.Remove(tmp.Name())
-		if _, copyErr := io.Copy(tmp, file); copyErr != nil {
-			tmp.Close()
-			writeError(w, http.StatusInternalServerError, ErrCodeInternalError, "failed to buffer upload: "+copyErr.Error())
-			return
-		}
-		tmp.Close()
+	defer req.close()
+	defer t.release()
+	w.Header().Set("X-Queue-Position", strconv.Itoa(t.position()))
 
-		// Convert to native WAV for diarization (and reuse for whisper).
-		nativeWav := tmp.Name() + ".wav"
-		if convErr := audio.ConvertToNativeWav(tmp.Name(), nativeWav, false); convErr != nil {
-			log.Printf("failed to convert audio to native WAV: %v", convErr)
-			writeError(w, http.StatusBadRequest, ErrCodeInvalidAudio, "failed to convert audio for diarization: "+convErr.Error())
-			return
-		}
-		defer os.Remove(nativeWav)
-		tempAudioPath = nativeWav
-
-		// Reopen converted file for audio decoding
-		reopened, reopenErr := os.Open(nativeWav)
-		if reopenErr != nil {
-			writeError(w, http.StatusInternalServerError, ErrCodeInternalError, "failed to reopen converted file: "+reopenErr.Error())
-			return
-		}
-		defer reopened.Close()
-		fileReader = reopened
-	}
-
-	samples, err := audio.ReadWithOptions(fileReader, audio.ReadOptions{
-		EnhanceAudio: parseBoolFormValue(r.FormValue("enhance_audio")),
-	})
-	if err != nil {
-		writeError(w, http.StatusBadRequest, ErrCodeInvalidAudio, "invalid audio file: "+err.Error())
-		return
-	}
-	if len(samples) == 0 {
-		writeError(w, http.StatusBadRequest, ErrCodeInvalidAudio, "audio file contains no samples")
+	if req.stream {
+		s.handleStreamingTranscription(w, r, m, t, req)
 		return
 	}
 
-	// Start diarization in background if requested.
+	// Start diarization in background if requested; it is killed if the
+	// client goes away.
 	type diarResult struct {
 		segments []diarize.Segment
-		err      error
-	}
-	var diarCh chan diarResult
-	if diarizeModel != "" && tempAudioPath != "" {
-		diarCh = make(chan diarResult, 1)
-		go func() {
-			segs, dErr := diarize.Diarize(diarizeModel, tempAudioPath)
-			diarCh <- diarResult{segs, dErr}
-		}()
-	}
-
-	samplingStrategy := r.FormValue("sampling_strategy")
-	stableTimestamps := parseBoolFormValue(r.FormValue("stable_timestamps"))
-	vadModelPath := r.FormValue("vad_model")
-	if stableTimestamps && vadModelPath == "" {
-		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "'vad_model' is required when 'stable_timestamps' is true")
-		return
-	}
-
-	opts := whisper.TranscribeOptions{
-		Language:         r.FormValue("language"),
-		DetectLanguage:   parseBoolFormValue(r.FormValue("detect_language")),
-		Translate:        parseBoolFormValue(r.FormValue("translate")),
-		Threads:          parseIntFormValue(r.FormValue("n_threads")),
-		Prompt:           r.FormValue("prompt"),
-		Verbose:          s.verbose,
-		Temperature:      parseFloatFormValue(r.FormValue("temperature")),
-		MaxTextCtx:       parseIntFormValue(r.FormValue("max_text_ctx")),
-		WordTimestamps:   parseBoolFormValue(r.FormValue("word_timestamps")),
-		MaxSegmentLen:    parseIntFormValue(r.FormValue("max_segment_len")),
-		SamplingGreedy:   samplingStrategy != "beam_search",
-		BestOf:           parseIntFormValue(r.FormValue("best_of")),
-		BeamSize:         parseIntFormValue(r.FormValue("beam_size")),
-		StableTimestamps: stableTimestamps,
-		VadModelPath:     vadModelPath,
-	}
-
-	responseFormat := r.FormValue("response_format")
-	if responseFormat == "" {
-		responseFormat = "json"
+		warnings []string
 	}
+	diarCh := make(chan diarResult, 1)
+	go func() {
+		segs, warnings := req.diarize(r.Context())
+		diarCh <- diarResult{segs, warnings}
+	}()
 
-	stream := parseBoolFormValue(r.FormValue("stream"))
-
-	if stream {
-		// Run diarization before streaming so speaker labels are available for each segment.
-		var diarStreamSegments []diarize.Segment
-		if diarizeModel != "" && tempAudioPath != "" {
-			segs, dErr := diarize.Diarize(diarizeModel, tempAudioPath)
-			if dErr != nil {
-				log.Printf("diarization failed (streaming without speakers): %v", dErr)
-			} else {
-				diarStreamSegments = segs
-			}
-		}
-		s.handleStreamingTranscription(w, r, samples, opts, diarStreamSegments)
-		return
+	// Non-streaming: wait for our turn, then set up abort on client disconnect.
+	if err := t.wait(r.Context(), nil); err != nil {
+		return // client gone while queued
 	}
 
-	// Non-streaming: set up abort on client disconnect.
 	var aborted atomic.Bool
 	go func() {
 		<-r.Context().Done()
 		aborted.Store(true)
 	}()
 
-	var result whisper.TranscribeResult
-	var transcribeErr error
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				transcribeErr = fmt.Errorf("internal error: %v", r)
-				log.Printf("panic during transcription: %v", r)
-			}
-		}()
-		result, transcribeErr = s.ctx.TranscribeStream(samples, opts, whisper.StreamCallbacks{
-			ShouldAbort: func() bool { return aborted.Load() },
-		})
-	}()
-	if transcribeErr != nil {
+	result, err := req.transcribe(m, whisper.StreamCallbacks{
+		ShouldAbort: func() bool { return aborted.Load() },
+	})
+	if err != nil {
 		if aborted.Load() {
 			return // client gone, nothing to write
 		}
-		writeError(w, http.StatusInternalServerError, ErrCodeInternalError, "transcription failed: "+transcribeErr.Error())
+		writeError(w, http.StatusInternalServerError, ErrCodeInternalError, "transcription failed: "+err.Error())
 		return
 	}
 
-	// Collect diarization results (skip silently on failure).
-	var diarSegments []diarize.Segment
-	if diarCh != nil {
-		dr := <-diarCh
-		if dr.err != nil {
-			log.Printf("diarization failed (skipping): %v", dr.err)
-		} else {
-			diarSegments = dr.segments
-		}
-	}
-
-	switch responseFormat {
-	case "verbose_json":
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(buildVerboseJSON(result.Segments, diarSegments))
-	case "text":
-		w.Header().Set("Content-Type", "text/plain")
-		io.WriteString(w, result.Text())
-	case "srt":
-		w.Header().Set("Content-Type", "text/plain")
-		io.WriteString(w, formatSRT(result.Segments))
-	case "vtt":
-		w.Header().Set("Content-Type", "text/plain")
-		io.WriteString(w, formatVTT(result.Segments))
-	default: // "json"
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"text": result.Text()})
-	}
+	// Collect diarization results; failures become warnings.
+	dr := <-diarCh
+	writeTranscriptionResult(w, req.responseFormat, result, transcript.Options{
+		Speakers:  dr.segments,
+		Warnings:  dr.warnings,
+		OmitWords: !req.words,
+	})
 }
 
 // handleStreamingTranscription writes newline-delimited JSON events
-// as segments and progress updates arrive during transcription.
-func (s *Server) handleStreamingTranscription(w http.ResponseWriter, r *http.Request, samples []float32, opts whisper.TranscribeOptions, diarSegments []diarize.Segment) {
+// as queue position, segments and progress updates arrive during transcription.
+// Speaker labels and diarization warnings follow as soon as diarization,
+// which runs concurrently, finishes.
+func (s *Server) handleStreamingTranscription(w http.ResponseWriter, r *http.Request, m *model, t *ticket, req *transcriptionRequest) {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		writeError(w, http.StatusInternalServerError, ErrCodeInternalError, "streaming not supported")
@@ -290,7 +168,86 @@ func (s *Server) handleStreamingTranscription(w http.ResponseWriter, r *http.Req
 	w.Header().Set("Connection", "keep-alive")
 	w.WriteHeader(http.StatusOK)
 
+	// Events come from the queue, the whisper callbacks and the
+	// diarization goroutine; mu keeps them whole and in order.
+	var mu sync.Mutex
 	enc := json.NewEncoder(w)
+	send := func(events ...map[string]any) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, event := range events {
+			enc.Encode(event)
+		}
+		flusher.Flush()
+	}
+
+	// Diarization runs alongside the queue wait and transcription. Until it
+	// finishes, segments go out unlabeled and are remembered; when it does,
+	// each of them gets a speaker_update event and later segments are
+	// labeled as they are emitted.
+	var (
+		diarDone     bool
+		diarSegments []diarize.Segment
+		unlabeled    []whisper.Segment // segment events sent before diarization finished
+		closed       bool              // the handler returned; w must not be written
+	)
+	diarCtx, cancelDiar := context.WithCancel(r.Context())
+	diarFinished := make(chan struct{})
+	defer func() {
+		cancelDiar()
+		mu.Lock()
+		closed = true
+		mu.Unlock()
+	}()
+	if req.diarizer == nil {
+		diarDone = true
+		close(diarFinished)
+	} else {
+		go func() {
+			defer close(diarFinished)
+			segments, warnings := req.diarize(diarCtx)
+			mu.Lock()
+			defer mu.Unlock()
+			if closed {
+				return
+			}
+			diarDone, diarSegments = true, segments
+			for _, msg := range warnings {
+				enc.Encode(map[string]any{
+					"type":    "warning",
+					"message": msg,
+				})
+			}
+			if segments != nil {
+				for i, seg := range unlabeled {
+					_, speakers := transcript.AttributeSpeakers([]whisper.Segment{{Start: seg.Start, End: seg.End}}, segments)
+					if sp := speakers[0]; sp.ID >= 0 {
+						event := map[string]any{
+							"type":          "speaker_update",
+							"segment_index": i,
+							"speaker":       sp.Value(),
+						}
+						if sp.Name != "" {
+							event["speaker_similarity"] = sp.Similarity
+						}
+						enc.Encode(event)
+					}
+				}
+			}
+			unlabeled = nil
+			flusher.Flush()
+		}()
+	}
+
+	err := t.wait(r.Context(), func(position int) {
+		send(map[string]any{
+			"type":     "queued",
+			"position": position,
+		})
+	})
+	if err != nil {
+		return // client gone while queued
+	}
 
 	var aborted atomic.Bool
 	go func() {
@@ -300,78 +257,106 @@ func (s *Server) handleStreamingTranscription(w http.ResponseWriter, r *http.Req
 
 	cb := whisper.StreamCallbacks{
 		OnProgress: func(progress int) {
-			enc.Encode(map[string]any{
+			send(map[string]any{
 				"type":     "progress",
 				"progress": progress,
 			})
-			flusher.Flush()
 		},
 		OnSegment: func(seg whisper.Segment) {
-			event := map[string]any{
-				"type":  "segment",
-				"start": csToSeconds(seg.Start),
-				"end":   csToSeconds(seg.End),
-				"text":  seg.Text,
+			mu.Lock()
+			defer mu.Unlock()
+			defer flusher.Flush()
+			if diarSegments == nil {
+				if !diarDone {
+					unlabeled = append(unlabeled, seg)
+				}
+				enc.Encode(segmentEvent(seg, req.words))
+				return
 			}
-			if diarSegments != nil {
-				if sp := matchSpeaker(csToSeconds(seg.Start), csToSeconds(seg.End), diarSegments); sp >= 0 {
-					event["speaker"] = sp
+			// One event per speaker turn within the segment.
+			pieces, speakers := transcript.AttributeSpeakers([]whisper.Segment{seg}, diarSegments)
+			for i, piece := range pieces {
+				event := segmentEvent(piece, req.words)
+				if sp := speakers[i]; sp.ID >= 0 {
+					event["speaker"] = sp.Value()
+					if sp.Name != "" {
+						event["speaker_similarity"] = sp.Similarity
+					}
 				}
+				enc.Encode(event)
 			}
-			enc.Encode(event)
-			flusher.Flush()
 		},
 		ShouldAbort: func() bool { return aborted.Load() },
 	}
 
-	var result whisper.TranscribeResult
-	var transcribeErr error
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				transcribeErr = fmt.Errorf("internal error: %v", r)
-				log.Printf("panic during streaming transcription: %v", r)
-			}
-		}()
-		result, transcribeErr = s.ctx.TranscribeStream(samples, opts, cb)
-	}()
+	result, transcribeErr := req.transcribe(m, cb)
 	if transcribeErr != nil {
 		if !aborted.Load() {
-			enc.Encode(map[string]any{
+			send(map[string]any{
 				"type":    "error",
 				"message": transcribeErr.Error(),
 			})
-			flusher.Flush()
 		}
 		return
 	}
 
-	// Final result line.
-	enc.Encode(map[string]any{
+	// Speaker updates come before the final result line.
+	<-diarFinished
+	send(map[string]any{
 		"type": "result",
 		"text": result.Text(),
 	})
-	flusher.Flush()
 }
 
+// handleLanguageDetection identifies the spoken language of an upload
+// from its first 30 seconds, without transcribing it. It takes the same
+// multipart form as /v1/audio/transcriptions and waits in the same queue.
+func (s *Server) handleLanguageDetection(w http.ResponseWriter, r *http.Request) {
+	if !s.hasModel() {
+		writeError(w, http.StatusServiceUnavailable, ErrCodeNoModel, "no model loaded")
+		return
+	}
+
+	req, m, t := s.parseQueuedRequest(w, r)
+	if req == nil {
+		return
+	}
+	defer req.close()
+	defer t.release()
+	if err := t.wait(r.Context(), nil); err != nil {
+		return // client gone while queued
+	}
+
+	samples, err := req.head(30 * whisper.SampleRate)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternalError, "failed to read audio: "+err.Error())
+		return
+	}
+	lang, probs, err := m.detectLanguage(samples, req.opts.Threads)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternalError, "language detection failed: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"language":      lang,
+		"name":          whisper.LanguageName(lang),
+		"probability":   probs[lang],
+		"probabilities": probs,
+	})
+}
+
+// handleModels lists every loaded model in load order.
 func (s *Server) handleModels(w http.ResponseWriter, r *http.Request) {
-	s.mu.Lock()
-	name := s.modelName
-	loaded := s.ctx != nil
-	s.mu.Unlock()
-
-	var data []map[string]any
-	if loaded {
-		data = []map[string]any{
-			{
-				"id":       name,
-				"object":   "model",
-				"created":  time.Now().Unix(),
-				"owned_by": "local",
-			},
-		}
-	} else {
-		data = []map[string]any{}
+	data := []map[string]any{}
+	for _, m := range s.loadedModels() {
+		data = append(data, map[string]any{
+			"id":       m.name,
+			"object":   "model",
+			"created":  m.loadedAt.Unix(),
+			"owned_by": "local",
+		})
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -15,43 +15,146 @@ type docsTranscriptionForm struct {
 	Prompt         string        `form:"prompt"`
 	DetectLanguage bool          `form:"detect_language"`
 	EnhanceAudio   bool          `form:"enhance_audio"`
-	ResponseFormat string        `form:"response_format"`
+	ResponseFormat string        `form:"response_format" enum:"json,text,verbose_json,srt,vtt,tsv,lrc" doc:"Output format (default: json)"`
 	Stream         bool          `form:"stream"`
-	Model          string        `form:"model"`
+	Model          string        `form:"model" doc:"Name of a loaded model (default: first loaded). Send it before file, or as ?model=, so the request is queued before the upload is read"`
 	BeamSize       int           `form:"beam_size"`
 	BestOf         int           `form:"best_of"`
-	DiarizeModel   string        `form:"diarize_model"`
+	DiarizeModel   string        `form:"diarize_model" doc:"Model for sona-diarize; setting it enables diarization"`
+	Diarizer       string        `form:"diarizer" enum:"sona-diarize,native" doc:"sona-diarize (external binary with a neural model; default and recommended) or native (simple built-in mode without a model; only separates clearly different voices)"`
+	NumSpeakers    int           `form:"num_speakers" doc:"Exact number of speakers for diarization (overrides min/max; at most 4 with sona-diarize)"`
+	MinSpeakers    int           `form:"min_speakers" doc:"At least this many speakers (native diarizer only; rejected with sona-diarize)"`
+	MaxSpeakers    int           `form:"max_speakers" doc:"At most this many speakers; extra speakers are merged into the nearest one"`
+	MinSegmentDur  float32       `form:"min_segment_duration" doc:"Seconds; shorter speaker turns join the speaker before them"`
+	IdentifySpkrs  bool          `form:"identify_speakers" doc:"Name diarized speakers after voices enrolled at /v1/speakers; verbose_json then reports the name as speaker, with speaker_similarity"`
+	SpeakerThresh  float32       `form:"speaker_threshold" doc:"Cosine similarity in (0, 1] a speaker needs to take an enrolled name (default 0.975)"`
 	MaxSegLen      int           `form:"max_segment_len"`
 	MaxTextCtx     int           `form:"max_text_ctx"`
 	NThreads       int           `form:"n_threads"`
 	SamplingStrat  string        `form:"sampling_strategy"`
 	StabTimestamps bool          `form:"stable_timestamps"`
+	StableWorkers  int           `form:"stable_workers" doc:"With stable_timestamps, speech regions decoded at once on the model's idle --parallel workers (default 1, at most the number of CPUs)"`
+	CarryPrompt    bool          `form:"carry_prompt" doc:"With stable_timestamps, prompt each speech region with the end of the previous one's text (decodes regions one at a time)"`
 	Temperature    float32       `form:"temperature"`
 	Translate      bool          `form:"translate"`
-	VadModel       string        `form:"vad_model"`
+	VadModel       string        `form:"vad_model" doc:"Path to a GGML VAD model (e.g. Silero)"`
+	Vad            bool          `form:"vad" doc:"Skip non-speech with VAD before decoding (requires vad_model)"`
+	VadThreshold   float32       `form:"vad_threshold" doc:"Speech probability threshold (default 0.5)"`
+	VadMinSpeech   int           `form:"vad_min_speech_duration_ms" doc:"Ignore shorter speech (default 250)"`
+	VadMinSilence  int           `form:"vad_min_silence_duration_ms" doc:"Silence that ends a speech region (default 100)"`
+	VadMaxSpeech   float32       `form:"vad_max_speech_duration_s" doc:"Split longer speech regions (default: no limit)"`
+	VadSpeechPad   int           `form:"vad_speech_pad_ms" doc:"Padding around each speech region (default 30)"`
 	WordTimestamps bool          `form:"word_timestamps"`
+	Granularities  []string      `form:"timestamp_granularities[]" enum:"segment,word" doc:"Include 'word' for word timings in verbose_json and stream events"`
+	ChunkLength    int           `form:"chunk_length" doc:"Transcribe in windows of this many seconds (0 = whole file; uploads over the server's in-memory limit always use windows of 300)"`
+	ChunkOverlap   int           `form:"chunk_overlap" doc:"Seconds shared by consecutive windows (0 = 5)"`
+	ChunkOnSilence bool          `form:"chunk_on_silence" doc:"End windows in a pause detected by vad_model instead of overlapping them"`
+}
+
+type docsSpeakerEnrollForm struct {
+	File huma.FormFile `form:"file" doc:"Voice sample of the speaker alone; the first five minutes are used"`
+	Name string        `form:"name"`
+}
+
+type docsSpeakerEnrollInput struct {
+	RawBody huma.MultipartFormFiles[docsSpeakerEnrollForm]
+}
+
+type docsSpeakerEnrollOutput struct {
+	Body struct {
+		Status  string `json:"status"`
+		Name    string `json:"name"`
+		Samples int    `json:"samples" doc:"Voice samples averaged into the profile"`
+	}
+}
+
+type docsSpeakerListOutput struct {
+	Body struct {
+		Speakers []struct {
+			Name    string `json:"name"`
+			Samples int    `json:"samples"`
+		} `json:"speakers"`
+	}
+}
+
+type docsSpeakerNameInput struct {
+	Name string `path:"name"`
 }
 
 type docsTranscriptionInput struct {
 	RawBody huma.MultipartFormFiles[docsTranscriptionForm]
 }
 
+type docsStreamInput struct {
+	Model      string `query:"model" doc:"Name of a loaded model (default: first loaded)"`
+	Language   string `query:"language"`
+	Prompt     string `query:"prompt"`
+	Encoding   string `query:"encoding" enum:"pcm_s16le,pcm_f32le,ogg_opus,webm_opus" doc:"Format of binary audio frames: 16 kHz mono PCM, or an Opus stream in an Ogg or WebM container"`
+	SampleRate int    `query:"sample_rate" doc:"Must be 16000 when set (PCM only)"`
+	StepMs     int    `query:"step_ms" doc:"New audio between partial decodes (default 3000)"`
+	LengthMs   int    `query:"length_ms" doc:"Window length at which segments are finalized (default 10000)"`
+	KeepMs     int    `query:"keep_ms" doc:"Audio carried into the next window (default 200)"`
+}
+
 type docsTranscriptionOutput struct {
 	Body struct {
 		Text string `json:"text"`
 	}
 }
 
+type docsLanguageOutput struct {
+	Body struct {
+		Language      string             `json:"language" doc:"Language code, e.g. en"`
+		Name          string             `json:"name" doc:"Full language name, e.g. english"`
+		Probability   float32            `json:"probability"`
+		Probabilities map[string]float32 `json:"probabilities" doc:"Probability of every language by code"`
+	}
+}
+
+type docsVADOutput struct {
+	Body vadResponse
+}
+
+type docsJobOutput struct {
+	Body struct {
+		ID            string `json:"id"`
+		Object        string `json:"object"`
+		Model         string `json:"model"`
+		Status        string `json:"status" enum:"queued,running,completed,failed,cancelled"`
+		Progress      int    `json:"progress"`
+		QueuePosition int    `json:"queue_position,omitempty"`
+		CreatedAt     int64  `json:"created_at"`
+		FinishedAt    int64  `json:"finished_at,omitempty"`
+		Error         string `json:"error,omitempty"`
+	}
+}
+
+type docsJobInput struct {
+	ID string `path:"id"`
+}
+
+type docsJobResultInput struct {
+	ID     string `path:"id"`
+	Format string `query:"format"`
+}
+
 type docsModelsOutput struct {
 	Body map[string]any
 }
 
 type docsModelLoadInput struct {
 	Body struct {
-		Path string `json:"path"`
+		Path      string `json:"path"`
+		Name      string `json:"name,omitempty" doc:"Registry name (default: file name); replaces a model loaded under the same name"`
+		GpuDevice *int   `json:"gpu_device,omitempty"`
+		NoGpu     bool   `json:"no_gpu,omitempty"`
 	}
 }
 
+type docsModelNameInput struct {
+	Name string `path:"name"`
+}
+
 type docsModelLoadOutput struct {
 	Body struct {
 		Status string `json:"status"`
@@ -59,6 +162,29 @@ type docsModelLoadOutput struct {
 	}
 }
 
+type docsVADPathInput struct {
+	Body struct {
+		Path string `json:"path"`
+	}
+}
+
+type docsVADUnloadInput struct {
+	Path string `query:"path" doc:"Path of the VAD model to unload (default: all)"`
+}
+
+type docsVADLoadOutput struct {
+	Body struct {
+		Status string `json:"status"`
+		Path   string `json:"path"`
+	}
+}
+
+type docsVADListOutput struct {
+	Body struct {
+		Paths []string `json:"paths"`
+	}
+}
+
 type docsStatusOutput struct {
 	Body struct {
 		Status string `json:"status"`
@@ -87,6 +213,75 @@ func (s *Server) registerDocsRoutes(mux *http.ServeMux) {
 		return nil, huma.Error501NotImplemented("spec-only operation")
 	})
 
+	huma.Register(api, huma.Operation{
+		Method:      http.MethodPost,
+		Path:        "/v1/audio/language",
+		OperationID: "detectLanguage",
+		Summary:     "Detect the spoken language",
+		Description: "Identifies the language from the first 30 seconds of audio without transcribing it.",
+	}, func(context.Context, *docsTranscriptionInput) (*docsLanguageOutput, error) {
+		return nil, huma.Error501NotImplemented("spec-only operation")
+	})
+
+	huma.Register(api, huma.Operation{
+		Method:      http.MethodPost,
+		Path:        "/v1/audio/vad",
+		OperationID: "detectSpeech",
+		Summary:     "Detect speech regions",
+		Description: "Runs the VAD model named by vad_model over the upload and returns its speech regions in seconds. No whisper model is needed.",
+	}, func(context.Context, *docsTranscriptionInput) (*docsVADOutput, error) {
+		return nil, huma.Error501NotImplemented("spec-only operation")
+	})
+
+	huma.Register(api, huma.Operation{
+		Method:        http.MethodGet,
+		Path:          "/v1/audio/stream",
+		OperationID:   "streamTranscription",
+		Summary:       "Transcribe live audio over a WebSocket",
+		Description:   "Upgrades to a WebSocket. Send binary PCM or Opus frames and a {\"type\":\"end\"} text message; receive partial, progress, segment, result and error events as JSON text messages.",
+		DefaultStatus: http.StatusSwitchingProtocols,
+	}, func(context.Context, *docsStreamInput) (*struct{}, error) {
+		return nil, huma.Error501NotImplemented("spec-only operation")
+	})
+
+	huma.Register(api, huma.Operation{
+		Method:        http.MethodPost,
+		Path:          "/v1/jobs",
+		OperationID:   "createJob",
+		Summary:       "Create an asynchronous transcription job",
+		DefaultStatus: http.StatusAccepted,
+	}, func(context.Context, *docsTranscriptionInput) (*docsJobOutput, error) {
+		return nil, huma.Error501NotImplemented("spec-only operation")
+	})
+
+	huma.Register(api, huma.Operation{
+		Method:      http.MethodGet,
+		Path:        "/v1/jobs/{id}",
+		OperationID: "getJob",
+		Summary:     "Get job status and progress",
+	}, func(context.Context, *docsJobInput) (*docsJobOutput, error) {
+		return nil, huma.Error501NotImplemented("spec-only operation")
+	})
+
+	huma.Register(api, huma.Operation{
+		Method:      http.MethodGet,
+		Path:        "/v1/jobs/{id}/result",
+		OperationID: "getJobResult",
+		Summary:     "Get a completed job's transcription (format overrides response_format)",
+	}, func(context.Context, *docsJobResultInput) (*docsTranscriptionOutput, error) {
+		return nil, huma.Error501NotImplemented("spec-only operation")
+	})
+
+	huma.Register(api, huma.Operation{
+		Method:      http.MethodDelete,
+		Path:        "/v1/jobs/{id}",
+		OperationID: "deleteJob",
+		Summary:     "Cancel or delete a job",
+		Description: "Cancels a queued or running job, which stays visible as cancelled until finished jobs expire, or deletes a finished job. Returns the job's final state.",
+	}, func(context.Context, *docsJobInput) (*docsJobOutput, error) {
+		return nil, huma.Error501NotImplemented("spec-only operation")
+	})
+
 	huma.Register(api, huma.Operation{
 		Method:      http.MethodGet,
 		Path:        "/v1/models",
@@ -109,11 +304,77 @@ func (s *Server) registerDocsRoutes(mux *http.ServeMux) {
 		Method:      http.MethodDelete,
 		Path:        "/v1/models",
 		OperationID: "unloadModel",
-		Summary:     "Unload the current model",
+		Summary:     "Unload all models",
 	}, func(context.Context, *struct{}) (*docsStatusOutput, error) {
 		return nil, huma.Error501NotImplemented("spec-only operation")
 	})
 
+	huma.Register(api, huma.Operation{
+		Method:      http.MethodDelete,
+		Path:        "/v1/models/{name}",
+		OperationID: "unloadNamedModel",
+		Summary:     "Unload one model by name",
+	}, func(context.Context, *docsModelNameInput) (*docsStatusOutput, error) {
+		return nil, huma.Error501NotImplemented("spec-only operation")
+	})
+
+	huma.Register(api, huma.Operation{
+		Method:      http.MethodGet,
+		Path:        "/v1/vad",
+		OperationID: "listVADModels",
+		Summary:     "List cached VAD models",
+	}, func(context.Context, *struct{}) (*docsVADListOutput, error) {
+		return nil, huma.Error501NotImplemented("spec-only operation")
+	})
+
+	huma.Register(api, huma.Operation{
+		Method:      http.MethodPost,
+		Path:        "/v1/vad/load",
+		OperationID: "loadVADModel",
+		Summary:     "Load a VAD model into the cache",
+		Description: "Requests naming the same vad_model path reuse the loaded model instead of reading it again.",
+	}, func(context.Context, *docsVADPathInput) (*docsVADLoadOutput, error) {
+		return nil, huma.Error501NotImplemented("spec-only operation")
+	})
+
+	huma.Register(api, huma.Operation{
+		Method:      http.MethodDelete,
+		Path:        "/v1/vad",
+		OperationID: "unloadVADModels",
+		Summary:     "Unload cached VAD models",
+		Description: "Unloads the model at path, or every cached VAD model without it (idempotent).",
+	}, func(context.Context, *docsVADUnloadInput) (*docsStatusOutput, error) {
+		return nil, huma.Error501NotImplemented("spec-only operation")
+	})
+
+	huma.Register(api, huma.Operation{
+		Method:      http.MethodPost,
+		Path:        "/v1/speakers",
+		OperationID: "enrollSpeaker",
+		Summary:     "Enroll a voice sample under a name",
+		Description: "Adds the sample to the named speaker, creating them if needed. Transcriptions with identify_speakers=true match diarized speakers against enrolled ones.",
+	}, func(context.Context, *docsSpeakerEnrollInput) (*docsSpeakerEnrollOutput, error) {
+		return nil, huma.Error501NotImplemented("spec-only operation")
+	})
+
+	huma.Register(api, huma.Operation{
+		Method:      http.MethodGet,
+		Path:        "/v1/speakers",
+		OperationID: "listSpeakers",
+		Summary:     "List enrolled speakers",
+	}, func(context.Context, *struct{}) (*docsSpeakerListOutput, error) {
+		return nil, huma.Error501NotImplemented("spec-only operation")
+	})
+
+	huma.Register(api, huma.Operation{
+		Method:      http.MethodDelete,
+		Path:        "/v1/speakers/{name}",
+		OperationID: "deleteSpeaker",
+		Summary:     "Remove an enrolled speaker",
+	}, func(context.Context, *docsSpeakerNameInput) (*docsStatusOutput, error) {
+		return nil, huma.Error501NotImplemented("spec-only operation")
+	})
+
 	huma.Register(api, huma.Operation{
 		Method:      http.MethodGet,
 		Path:        "/health",
@@ -1,9 +1,13 @@
 package server
 
 const (
-	ErrCodeInvalidRequest = "invalid_request"
-	ErrCodeInvalidAudio   = "invalid_audio"
-	ErrCodeBusy           = "busy"
-	ErrCodeNoModel        = "no_model"
-	ErrCodeInternalError  = "internal_error"
+	ErrCodeInvalidRequest  = "invalid_request"
+	ErrCodeInvalidAudio    = "invalid_audio"
+	ErrCodeBusy            = "busy"
+	ErrCodeNoModel         = "no_model"
+	ErrCodeModelNotFound   = "model_not_found"
+	ErrCodeJobNotFound     = "job_not_found"
+	ErrCodeJobNotReady     = "job_not_ready"
+	ErrCodeSpeakerNotFound = "speaker_not_found"
+	ErrCodeInternalError   = "internal_error"
 )
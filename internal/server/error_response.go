@@ -2,6 +2,7 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 )
 
@@ -15,3 +16,12 @@ func writeError(w http.ResponseWriter, status int, code string, message string)
 		},
 	})
 }
+
+// writeModelError writes the response for an error from resolveModel.
+func writeModelError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errNoModel) {
+		writeError(w, http.StatusServiceUnavailable, ErrCodeNoModel, err.Error())
+		return
+	}
+	writeError(w, http.StatusNotFound, ErrCodeModelNotFound, err.Error())
+}
@@ -1,131 +1,46 @@
 package server
 
 import (
-	"fmt"
-	"strings"
-
-	"github.com/thewh1teagle/sona/internal/diarize"
+	"github.com/thewh1teagle/sona/internal/transcript"
 	"github.com/thewh1teagle/sona/internal/whisper"
 )
 
-// csToSeconds converts whisper centiseconds (10ms units) to seconds.
-func csToSeconds(cs int64) float64 {
-	return float64(cs) / 100.0
-}
-
-// csToSRTTime converts centiseconds to SRT timestamp format HH:MM:SS,mmm.
-func csToSRTTime(cs int64) string {
-	ms := cs * 10
-	s := ms / 1000
-	ms = ms % 1000
-	m := s / 60
-	s = s % 60
-	h := m / 60
-	m = m % 60
-	return fmt.Sprintf("%02d:%02d:%02d,%03d", h, m, s, ms)
-}
-
-// csToVTTTime converts centiseconds to WebVTT timestamp format HH:MM:SS.mmm.
-func csToVTTTime(cs int64) string {
-	ms := cs * 10
-	s := ms / 1000
-	ms = ms % 1000
-	m := s / 60
-	s = s % 60
-	h := m / 60
-	m = m % 60
-	return fmt.Sprintf("%02d:%02d:%02d.%03d", h, m, s, ms)
-}
-
-// formatSRT formats segments as SubRip (.srt) subtitles.
-func formatSRT(segments []whisper.Segment) string {
-	var sb strings.Builder
-	for i, seg := range segments {
-		if i > 0 {
-			sb.WriteByte('\n')
-		}
-		fmt.Fprintf(&sb, "%d\n%s --> %s\n%s\n",
-			i+1,
-			csToSRTTime(seg.Start),
-			csToSRTTime(seg.End),
-			strings.TrimSpace(seg.Text),
-		)
+// segmentEvent builds the NDJSON "segment" event for seg, with its word
+// timings if words is set.
+func segmentEvent(seg whisper.Segment, words bool) map[string]any {
+	event := map[string]any{
+		"type":  "segment",
+		"start": transcript.Seconds(seg.Start),
+		"end":   transcript.Seconds(seg.End),
+		"text":  seg.Text,
 	}
-	return sb.String()
-}
-
-// formatVTT formats segments as WebVTT (.vtt) subtitles.
-func formatVTT(segments []whisper.Segment) string {
-	var sb strings.Builder
-	sb.WriteString("WEBVTT\n\n")
-	for i, seg := range segments {
-		if i > 0 {
-			sb.WriteByte('\n')
-		}
-		fmt.Fprintf(&sb, "%s --> %s\n%s\n",
-			csToVTTTime(seg.Start),
-			csToVTTTime(seg.End),
-			strings.TrimSpace(seg.Text),
-		)
+	if words && len(seg.Words) > 0 {
+		event["words"] = transcript.VerboseWords(seg.Words)
 	}
-	return sb.String()
+	return event
 }
 
-// verboseSegment is the JSON representation of a segment in verbose_json format.
-type verboseSegment struct {
-	Start   float64 `json:"start"`
-	End     float64 `json:"end"`
-	Text    string  `json:"text"`
-	Speaker *int    `json:"speaker,omitempty"`
+// vadRegion is a speech region in the /v1/audio/vad response, in seconds.
+type vadRegion struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
 }
 
-// verboseJSON is the response body for response_format=verbose_json.
-type verboseJSON struct {
-	Text     string           `json:"text"`
-	Segments []verboseSegment `json:"segments"`
-}
-
-// buildVerboseJSON creates the verbose_json response structure.
-// If diarSegments is non-nil, each transcription segment is assigned
-// the speaker with maximum time overlap.
-func buildVerboseJSON(segments []whisper.Segment, diarSegments []diarize.Segment) verboseJSON {
-	text := whisper.TranscribeResult{Segments: segments}.Text()
-	vSegs := make([]verboseSegment, len(segments))
-	for i, seg := range segments {
-		vSegs[i] = verboseSegment{
-			Start: csToSeconds(seg.Start),
-			End:   csToSeconds(seg.End),
-			Text:  seg.Text,
-		}
-		if diarSegments != nil {
-			if sp := matchSpeaker(csToSeconds(seg.Start), csToSeconds(seg.End), diarSegments); sp >= 0 {
-				id := sp
-				vSegs[i].Speaker = &id
-			}
-		}
-	}
-	return verboseJSON{Text: text, Segments: vSegs}
+type vadResponse struct {
+	Duration       float64     `json:"duration"`
+	SpeechDuration float64     `json:"speech_duration"`
+	Segments       []vadRegion `json:"segments"`
 }
 
-// matchSpeaker finds the diarization segment with maximum overlap and
-// returns its speaker_id, or -1 if no overlap found.
-func matchSpeaker(start, end float64, diarSegments []diarize.Segment) int {
-	bestID := -1
-	bestOverlap := 0.0
-	for _, ds := range diarSegments {
-		oStart := start
-		if ds.Start > oStart {
-			oStart = ds.Start
-		}
-		oEnd := end
-		if ds.End < oEnd {
-			oEnd = ds.End
-		}
-		overlap := oEnd - oStart
-		if overlap > bestOverlap {
-			bestOverlap = overlap
-			bestID = ds.SpeakerID
-		}
+// buildVADResponse converts VAD speech regions to the /v1/audio/vad
+// response.
+func buildVADResponse(speech []whisper.SpeechSegment, duration int64) vadResponse {
+	resp := vadResponse{Duration: transcript.Seconds(duration), Segments: make([]vadRegion, len(speech))}
+	var total int64
+	for i, seg := range speech {
+		resp.Segments[i] = vadRegion{Start: transcript.Seconds(seg.Start), End: transcript.Seconds(seg.End)}
+		total += seg.End - seg.Start
 	}
-	return bestID
+	resp.SpeechDuration = transcript.Seconds(total)
+	return resp
 }
@@ -0,0 +1,298 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/thewh1teagle/sona/internal/diarize"
+	"github.com/thewh1teagle/sona/internal/transcript"
+	"github.com/thewh1teagle/sona/internal/whisper"
+)
+
+// jobRetention is how long finished jobs are kept before being pruned.
+const jobRetention = time.Hour
+
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobCompleted = "completed"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+// job is an asynchronous transcription created via POST /v1/jobs.
+type job struct {
+	id             string
+	model          string
+	createdAt      time.Time
+	responseFormat string
+	omitWords      bool // word timings were only timed for diarization
+	ticket         *ticket
+	cancel         context.CancelFunc
+	aborted        atomic.Bool
+
+	mu           sync.Mutex
+	status       string
+	progress     int
+	finishedAt   time.Time
+	result       whisper.TranscribeResult
+	diarSegments []diarize.Segment
+	warnings     []string
+	err          string
+}
+
+func (j *job) setStatus(status string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status = status
+}
+
+func (j *job) setProgress(progress int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.progress = progress
+}
+
+// finish records the final state of the job. The first final state
+// sticks, so a job cancelled by DELETE stays cancelled.
+func (j *job) finish(status string, result whisper.TranscribeResult, diarSegments []diarize.Segment, warnings []string, errMsg string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if !j.finishedAt.IsZero() {
+		return
+	}
+	j.status = status
+	j.result = result
+	j.diarSegments = diarSegments
+	j.warnings = warnings
+	j.err = errMsg
+	j.finishedAt = time.Now()
+	if status == jobCompleted {
+		j.progress = 100
+	}
+}
+
+// jobView is the JSON representation of a job's status.
+type jobView struct {
+	ID            string `json:"id"`
+	Object        string `json:"object"`
+	Model         string `json:"model"`
+	Status        string `json:"status"`
+	Progress      int    `json:"progress"`
+	QueuePosition int    `json:"queue_position,omitempty"`
+	CreatedAt     int64  `json:"created_at"`
+	FinishedAt    int64  `json:"finished_at,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+func (j *job) view() jobView {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	v := jobView{
+		ID:        j.id,
+		Object:    "transcription.job",
+		Model:     j.model,
+		Status:    j.status,
+		Progress:  j.progress,
+		CreatedAt: j.createdAt.Unix(),
+		Error:     j.err,
+	}
+	if j.status == jobQueued && j.ticket != nil {
+		v.QueuePosition = j.ticket.position()
+	}
+	if !j.finishedAt.IsZero() {
+		v.FinishedAt = j.finishedAt.Unix()
+	}
+	return v
+}
+
+// jobStore holds async jobs by id.
+type jobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+func newJobStore() *jobStore {
+	return &jobStore{jobs: make(map[string]*job)}
+}
+
+// add stores j and prunes jobs that finished more than jobRetention ago.
+func (st *jobStore) add(j *job) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for id, old := range st.jobs {
+		old.mu.Lock()
+		expired := !old.finishedAt.IsZero() && time.Since(old.finishedAt) > jobRetention
+		old.mu.Unlock()
+		if expired {
+			delete(st.jobs, id)
+		}
+	}
+	st.jobs[j.id] = j
+}
+
+func (st *jobStore) get(id string) *job {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.jobs[id]
+}
+
+func (st *jobStore) remove(id string) *job {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	j := st.jobs[id]
+	delete(st.jobs, id)
+	return j
+}
+
+func newJobID() string {
+	var b [12]byte
+	rand.Read(b[:])
+	return "job_" + hex.EncodeToString(b[:])
+}
+
+// handleJobCreate accepts the same multipart form as /v1/audio/transcriptions
+// and returns a job id immediately; the transcription runs in the background.
+func (s *Server) handleJobCreate(w http.ResponseWriter, r *http.Request) {
+	if !s.hasModel() {
+		writeError(w, http.StatusServiceUnavailable, ErrCodeNoModel, "no model loaded")
+		return
+	}
+
+	req, m, t := s.parseQueuedRequest(w, r)
+	if req == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{
+		id:             newJobID(),
+		model:          m.name,
+		createdAt:      time.Now(),
+		responseFormat: req.responseFormat,
+		omitWords:      !req.words,
+		ticket:         t,
+		cancel:         cancel,
+		status:         jobQueued,
+	}
+	s.jobs.add(j)
+	go s.runJob(ctx, j, m, req)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(j.view())
+}
+
+// runJob waits for the job's turn in the queue and transcribes it.
+func (s *Server) runJob(ctx context.Context, j *job, m *model, req *transcriptionRequest) {
+	defer req.close()
+	defer j.ticket.release()
+	defer j.cancel()
+
+	if err := j.ticket.wait(ctx, nil); err != nil {
+		j.finish(jobCancelled, whisper.TranscribeResult{}, nil, nil, "")
+		return
+	}
+
+	type diarResult struct {
+		segments []diarize.Segment
+		warnings []string
+	}
+	diarCh := make(chan diarResult, 1)
+	go func() {
+		segs, warnings := req.diarize(ctx)
+		diarCh <- diarResult{segs, warnings}
+	}()
+
+	j.setStatus(jobRunning)
+	result, err := req.transcribe(m, whisper.StreamCallbacks{
+		OnProgress:  j.setProgress,
+		ShouldAbort: j.aborted.Load,
+	})
+	if err != nil {
+		if j.aborted.Load() {
+			j.finish(jobCancelled, whisper.TranscribeResult{}, nil, nil, "")
+			return
+		}
+		j.finish(jobFailed, whisper.TranscribeResult{}, nil, nil, "transcription failed: "+err.Error())
+		return
+	}
+
+	dr := <-diarCh
+	j.finish(jobCompleted, result, dr.segments, dr.warnings, "")
+}
+
+// handleJobGet returns the status and progress of a job.
+func (s *Server) handleJobGet(w http.ResponseWriter, r *http.Request) {
+	j := s.jobs.get(r.PathValue("id"))
+	if j == nil {
+		writeError(w, http.StatusNotFound, ErrCodeJobNotFound, "job not found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(j.view())
+}
+
+// handleJobResult writes a completed job's transcription. The format query
+// parameter overrides the response_format the job was created with.
+func (s *Server) handleJobResult(w http.ResponseWriter, r *http.Request) {
+	j := s.jobs.get(r.PathValue("id"))
+	if j == nil {
+		writeError(w, http.StatusNotFound, ErrCodeJobNotFound, "job not found")
+		return
+	}
+
+	j.mu.Lock()
+	status, errMsg := j.status, j.err
+	result, diarSegments, warnings := j.result, j.diarSegments, j.warnings
+	j.mu.Unlock()
+
+	if status != jobCompleted {
+		msg := "job is " + status
+		if errMsg != "" {
+			msg += ": " + errMsg
+		}
+		writeError(w, http.StatusConflict, ErrCodeJobNotReady, msg)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = j.responseFormat
+	}
+	writeTranscriptionResult(w, format, result, transcript.Options{
+		Speakers:  diarSegments,
+		Warnings:  warnings,
+		OmitWords: j.omitWords,
+	})
+}
+
+// handleJobDelete cancels a queued or running job, which is then kept
+// like any finished job, or forgets a finished one. Either way it returns
+// the job's final state.
+func (s *Server) handleJobDelete(w http.ResponseWriter, r *http.Request) {
+	j := s.jobs.get(r.PathValue("id"))
+	if j == nil {
+		writeError(w, http.StatusNotFound, ErrCodeJobNotFound, "job not found")
+		return
+	}
+	j.mu.Lock()
+	finished := !j.finishedAt.IsZero()
+	j.mu.Unlock()
+	if finished {
+		s.jobs.remove(j.id)
+	} else {
+		j.aborted.Store(true)
+		j.cancel()
+		j.finish(jobCancelled, whisper.TranscribeResult{}, nil, nil, "")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(j.view())
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/thewh1teagle/sona/internal/whisper"
+)
+
+func addTestJob(s *Server, status string) *job {
+	_, cancel := context.WithCancel(context.Background())
+	j := &job{id: newJobID(), cancel: cancel, status: status, responseFormat: "json"}
+	s.jobs.add(j)
+	return j
+}
+
+func TestJobCreateNoModel(t *testing.T) {
+	s := New(false)
+	req := httptest.NewRequest("POST", "/v1/jobs", nil)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", w.Code)
+	}
+}
+
+func TestJobNotFound(t *testing.T) {
+	s := New(false)
+	for _, tc := range []struct{ method, path string }{
+		{"GET", "/v1/jobs/job_missing"},
+		{"GET", "/v1/jobs/job_missing/result"},
+		{"DELETE", "/v1/jobs/job_missing"},
+	} {
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s %s: expected 404, got %d", tc.method, tc.path, w.Code)
+		}
+	}
+}
+
+func TestJobStatus(t *testing.T) {
+	s := New(false)
+	j := addTestJob(s, jobRunning)
+	j.setProgress(42)
+
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/v1/jobs/"+j.id, nil))
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var body jobView
+	json.NewDecoder(w.Body).Decode(&body)
+	if body.ID != j.id || body.Status != jobRunning || body.Progress != 42 {
+		t.Errorf("got %+v, want id %s running at 42%%", body, j.id)
+	}
+}
+
+func TestJobResultNotReady(t *testing.T) {
+	s := New(false)
+	j := addTestJob(s, jobRunning)
+
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/v1/jobs/"+j.id+"/result", nil))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", w.Code)
+	}
+}
+
+func TestJobResultFormat(t *testing.T) {
+	s := New(false)
+	j := addTestJob(s, jobRunning)
+	j.finish(jobCompleted, whisper.TranscribeResult{Segments: []whisper.Segment{
+		{Start: 0, End: 250, Text: " Hello world"},
+	}}, nil, nil, "")
+
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/v1/jobs/"+j.id+"/result?format=srt", nil))
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	want := "1\n00:00:00,000 --> 00:00:02,500\nHello world\n"
+	if got := w.Body.String(); got != want {
+		t.Errorf("result = %q, want %q", got, want)
+	}
+}
+
+func TestJobDelete(t *testing.T) {
+	s := New(false)
+	j := addTestJob(s, jobRunning)
+
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest("DELETE", "/v1/jobs/"+j.id, nil))
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if !j.aborted.Load() {
+		t.Error("expected job to be aborted")
+	}
+	var v jobView
+	if err := json.NewDecoder(w.Body).Decode(&v); err != nil || v.Status != jobCancelled {
+		t.Errorf("DELETE returned %+v, %v; want the cancelled job", v, err)
+	}
+
+	// The cancelled job stays visible, and its runner cannot overwrite it.
+	j.finish(jobCompleted, whisper.TranscribeResult{}, nil, nil, "")
+	w = httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/v1/jobs/"+j.id, nil))
+	if err := json.NewDecoder(w.Body).Decode(&v); err != nil || v.Status != jobCancelled || v.FinishedAt == 0 {
+		t.Errorf("GET after DELETE = %d %+v, %v; want cancelled", w.Code, v, err)
+	}
+
+	// Deleting a finished job forgets it.
+	w = httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest("DELETE", "/v1/jobs/"+j.id, nil))
+	if w.Code != 200 || s.jobs.get(j.id) != nil {
+		t.Errorf("second DELETE = %d, want 200 and the job removed", w.Code)
+	}
+}
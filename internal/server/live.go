@@ -0,0 +1,508 @@
+package server
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/thewh1teagle/sona/internal/audio"
+	"github.com/thewh1teagle/sona/internal/transcript"
+	"github.com/thewh1teagle/sona/internal/whisper"
+)
+
+const (
+	liveDefaultStepMs   = 3000  // decode every 3s of new audio
+	liveDefaultLengthMs = 10000 // finalize once the window holds 10s
+	liveDefaultKeepMs   = 200   // audio carried into the next window
+	liveMaxMessageSize  = 4 << 20
+	liveMaxBacklogMs    = 30000 // audio buffered past a window before giving up
+)
+
+// errLiveBacklog is returned by liveStream.write once decodes have been
+// skipped for so long that the buffered audio is over its cap.
+var errLiveBacklog = errors.New("server is busy and live audio fell too far behind")
+
+// errLiveAudio marks audio the server failed to decode.
+var errLiveAudio = errors.New("invalid audio")
+
+// liveOpusEncodings maps the Opus encodings a live client may send to the
+// container audio.NewLiveStream reads them from.
+var liveOpusEncodings = map[string]string{
+	"ogg_opus":  "ogg",
+	"webm_opus": "webm",
+}
+
+// liveStream accumulates PCM from a live client and transcribes it in
+// sliding windows. Every step of new audio the current window is decoded
+// and reported as a partial hypothesis; once the window reaches length its
+// segments are finalized and the window restarts from the last (possibly
+// cut off) segment, or from the last keep samples.
+type liveStream struct {
+	transcribe func(samples []float32, final bool) ([]whisper.Segment, error)
+	emit       func(event map[string]any) error
+	step       int // samples of new audio between decodes
+	length     int // window size in samples that triggers finalization
+	keep       int // samples kept when a window is finalized as a whole
+	max        int // samples buffered before write fails, 0 for no limit
+
+	buf       []float32
+	offset    int64 // centiseconds of audio before buf[0]
+	sinceLast int
+	final     []whisper.Segment
+}
+
+// write appends samples and decodes the window once a step has accumulated.
+// If decodes were skipped (see errQueueFull) until more than max samples
+// are buffered, it fails with errLiveBacklog.
+func (ls *liveStream) write(samples []float32) error {
+	ls.buf = append(ls.buf, samples...)
+	if ls.max > 0 && len(ls.buf) > ls.max {
+		return errLiveBacklog
+	}
+	ls.sinceLast += len(samples)
+	if ls.sinceLast < ls.step {
+		return nil
+	}
+	return ls.decode(false)
+}
+
+// flush appends the last samples, then decodes and finalizes whatever
+// audio is left.
+func (ls *liveStream) flush(samples []float32) error {
+	ls.buf = append(ls.buf, samples...)
+	if len(ls.buf) == 0 {
+		return nil
+	}
+	return ls.decode(true)
+}
+
+// text returns the concatenated text of all finalized segments.
+func (ls *liveStream) text() string {
+	return whisper.TranscribeResult{Segments: ls.final}.Text()
+}
+
+func (ls *liveStream) decode(final bool) error {
+	ls.sinceLast = 0
+	segs, err := ls.transcribe(ls.buf, final)
+	if err != nil {
+		return err
+	}
+
+	if !final && len(ls.buf) < ls.length {
+		return ls.emit(map[string]any{
+			"type":  "partial",
+			"start": transcript.Seconds(ls.offset),
+			"end":   transcript.Seconds(ls.offset + whisper.SamplesToCs(len(ls.buf))),
+			"text":  whisper.TranscribeResult{Segments: segs}.Text(),
+		})
+	}
+
+	commit := segs
+	keepFrom := len(ls.buf)
+	if !final {
+		keepFrom = len(ls.buf) - ls.keep
+		if n := len(segs); n > 1 {
+			if start := whisper.CsToSamples(segs[n-1].Start); start > 0 && start < len(ls.buf) {
+				commit = segs[:n-1]
+				keepFrom = start
+			}
+		}
+		if keepFrom < 0 {
+			keepFrom = 0
+		}
+	}
+
+	for _, seg := range commit {
+		seg = seg.Shift(ls.offset)
+		ls.final = append(ls.final, seg)
+		if err := ls.emit(segmentEvent(seg, true)); err != nil {
+			return err
+		}
+	}
+
+	ls.offset += whisper.SamplesToCs(keepFrom)
+	ls.buf = append([]float32(nil), ls.buf[keepFrom:]...)
+	return nil
+}
+
+// pcmDecoder converts raw little-endian PCM frames to float32 samples,
+// carrying partial samples over to the next frame.
+type pcmDecoder struct {
+	bytesPerSample int
+	rest           []byte
+}
+
+func newPCMDecoder(encoding string) (*pcmDecoder, error) {
+	switch encoding {
+	case "", "pcm_s16le":
+		return &pcmDecoder{bytesPerSample: 2}, nil
+	case "pcm_f32le":
+		return &pcmDecoder{bytesPerSample: 4}, nil
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q (use pcm_s16le, pcm_f32le, ogg_opus or webm_opus)", encoding)
+	}
+}
+
+func (d *pcmDecoder) decode(frame []byte) []float32 {
+	data := append(d.rest, frame...)
+	n := len(data) / d.bytesPerSample
+	samples := make([]float32, n)
+	for i := 0; i < n; i++ {
+		b := data[i*d.bytesPerSample:]
+		if d.bytesPerSample == 2 {
+			samples[i] = float32(int16(binary.LittleEndian.Uint16(b))) / math.MaxInt16
+		} else {
+			samples[i] = math.Float32frombits(binary.LittleEndian.Uint32(b))
+		}
+	}
+	d.rest = append([]byte(nil), data[n*d.bytesPerSample:]...)
+	return samples
+}
+
+// liveBuffer hands samples from the client to the decode loop. put blocks
+// while more than max samples are waiting, so a client sending faster than
+// the server decodes is slowed down rather than buffered without bound.
+type liveBuffer struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	max     int
+	pending []float32
+	ended   bool
+	err     error
+	stopped bool
+	wake    chan struct{} // signalled when there is something to take
+}
+
+func newLiveBuffer(max int) *liveBuffer {
+	b := &liveBuffer{max: max, wake: make(chan struct{}, 1)}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// put adds samples, first waiting while the buffer is full.
+func (b *liveBuffer) put(samples []float32) {
+	b.mu.Lock()
+	for len(b.pending) >= b.max && !b.stopped {
+		b.cond.Wait()
+	}
+	b.pending = append(b.pending, samples...)
+	b.mu.Unlock()
+	b.notify()
+}
+
+// finish marks the end of the input; err is non-nil if it failed.
+func (b *liveBuffer) finish(err error) {
+	b.mu.Lock()
+	b.ended = true
+	b.err = err
+	b.mu.Unlock()
+	b.notify()
+}
+
+// take returns and clears the waiting samples, and whether the input has
+// ended and why.
+func (b *liveBuffer) take() (samples []float32, ended bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	samples, b.pending = b.pending, nil
+	b.cond.Broadcast()
+	return samples, b.ended, b.err
+}
+
+// stop releases a blocked put once nobody will take samples any more.
+func (b *liveBuffer) stop() {
+	b.mu.Lock()
+	b.stopped = true
+	b.cond.Broadcast()
+	b.mu.Unlock()
+}
+
+func (b *liveBuffer) notify() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// liveInput turns the client's binary frames into samples for a liveBuffer.
+type liveInput interface {
+	write(frame []byte) error
+	// end is called once no more frames will come; err is non-nil if the
+	// client failed.
+	end(err error)
+}
+
+// pcmInput decodes raw PCM frames as they arrive.
+type pcmInput struct {
+	dec *pcmDecoder
+	buf *liveBuffer
+}
+
+func (in *pcmInput) write(frame []byte) error {
+	in.buf.put(in.dec.decode(frame))
+	return nil
+}
+
+func (in *pcmInput) end(err error) { in.buf.finish(err) }
+
+// streamInput pipes frames of a container stream (e.g. Ogg Opus) into an
+// audio.Stream and puts what it decodes.
+type streamInput struct {
+	pw *io.PipeWriter
+}
+
+// newStreamInput starts decoding container frames into buf. The input
+// must be ended to stop the decoder.
+func newStreamInput(container string, buf *liveBuffer) (*streamInput, error) {
+	pr, pw := io.Pipe()
+	stream, err := audio.NewLiveStream(pr, container)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer stream.Close()
+		dst := make([]float32, 1<<14)
+		for {
+			n, err := stream.ReadSamples(dst)
+			if n > 0 {
+				buf.put(append([]float32(nil), dst[:n]...))
+			}
+			if err == io.EOF {
+				buf.finish(nil)
+				return
+			}
+			if err != nil {
+				pr.CloseWithError(err) // unblock the client's writes
+				buf.finish(fmt.Errorf("%w: %v", errLiveAudio, err))
+				return
+			}
+		}
+	}()
+	return &streamInput{pw: pw}, nil
+}
+
+func (in *streamInput) write(frame []byte) error {
+	_, err := in.pw.Write(frame)
+	return err
+}
+
+func (in *streamInput) end(err error) { in.pw.CloseWithError(err) }
+
+// parseMsFormValue reads a positive millisecond value, or def when unset.
+func parseMsFormValue(v string, def int) (int, error) {
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid duration %q (milliseconds)", v)
+	}
+	return n, nil
+}
+
+// handleAudioStream transcribes live audio over a WebSocket. The client
+// sends binary frames of 16 kHz mono PCM (encoding=pcm_s16le or pcm_f32le),
+// or of an Ogg or WebM Opus stream (encoding=ogg_opus or webm_opus), and a
+// {"type":"end"} text message when done. The server replies with "partial"
+// hypotheses for the current window, "progress" while a window decodes,
+// finalized "segment" events and a closing "result", using the same shapes
+// as NDJSON streaming.
+func (s *Server) handleAudioStream(w http.ResponseWriter, r *http.Request) {
+	if !s.hasModel() {
+		writeError(w, http.StatusServiceUnavailable, ErrCodeNoModel, "no model loaded")
+		return
+	}
+	m, err := s.resolveModel(r.FormValue("model"))
+	if err != nil {
+		writeModelError(w, err)
+		return
+	}
+	opts, err := s.parseTranscribeOptions(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	container, opus := liveOpusEncodings[r.FormValue("encoding")]
+	var pcm *pcmDecoder
+	if !opus {
+		if pcm, err = newPCMDecoder(r.FormValue("encoding")); err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		if rate := r.FormValue("sample_rate"); rate != "" && rate != strconv.Itoa(whisper.SampleRate) {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "only 16000 Hz audio is supported")
+			return
+		}
+	}
+	var stepMs, lengthMs, keepMs int
+	for _, p := range []struct {
+		dst  *int
+		name string
+		def  int
+	}{
+		{&stepMs, "step_ms", liveDefaultStepMs},
+		{&lengthMs, "length_ms", liveDefaultLengthMs},
+		{&keepMs, "keep_ms", liveDefaultKeepMs},
+	} {
+		if *p.dst, err = parseMsFormValue(r.FormValue(p.name), p.def); err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, p.name+": "+err.Error())
+			return
+		}
+	}
+
+	// At most a window and the backlog allowance are buffered between the
+	// client and the decoder; past that the client is slowed down.
+	maxBuffered := (lengthMs + liveMaxBacklogMs) * whisper.SampleRate / 1000
+	buf := newLiveBuffer(maxBuffered)
+	defer buf.stop()
+	var input liveInput = &pcmInput{dec: pcm, buf: buf}
+	if opus {
+		in, err := newStreamInput(container, buf)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, ErrCodeInternalError, "failed to start Opus decoder: "+err.Error())
+			return
+		}
+		defer in.end(errors.New("stream closed"))
+		input = in
+	}
+
+	releaseVAD := s.useCachedVAD(&opts)
+	defer releaseVAD()
+
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		return // Accept already wrote the handshake error
+	}
+	defer conn.CloseNow()
+	conn.SetReadLimit(liveMaxMessageSize)
+	ctx := r.Context()
+
+	// The reader goroutine collects audio while the loop below decodes, so
+	// a slow decode coalesces frames instead of stalling the client, up to
+	// the buffer's limit.
+	go func() {
+		for {
+			typ, data, err := conn.Read(ctx)
+			switch {
+			case err != nil:
+				if websocket.CloseStatus(err) != websocket.StatusNormalClosure {
+					input.end(err)
+				} else {
+					input.end(nil)
+				}
+				return
+			case typ == websocket.MessageBinary:
+				if input.write(data) != nil {
+					return // the decoder failed and reports why
+				}
+			default:
+				var msg struct {
+					Type string `json:"type"`
+				}
+				if json.Unmarshal(data, &msg) == nil && msg.Type == "end" {
+					input.end(nil)
+					return
+				}
+			}
+		}
+	}()
+
+	var emitMu sync.Mutex
+	emit := func(event map[string]any) error {
+		emitMu.Lock()
+		defer emitMu.Unlock()
+		return wsjson.Write(ctx, conn, event)
+	}
+	ls := &liveStream{
+		step:   stepMs * whisper.SampleRate / 1000,
+		length: lengthMs * whisper.SampleRate / 1000,
+		keep:   keepMs * whisper.SampleRate / 1000,
+		max:    maxBuffered,
+		emit:   emit,
+		transcribe: func(samples []float32, final bool) ([]whisper.Segment, error) {
+			// A partial decode is skipped while the queue is full, but the
+			// final one waits for room so the last window is not lost.
+			var t *ticket
+			var err error
+			if final {
+				t, err = m.queue.enqueueWait(ctx, s.QueueSize)
+			} else {
+				t, err = m.queue.enqueue(s.QueueSize)
+			}
+			if err != nil {
+				return nil, err
+			}
+			defer t.release()
+			if err := t.wait(ctx, nil); err != nil {
+				return nil, err
+			}
+			result, err := m.transcribe(samples, opts, whisper.StreamCallbacks{
+				OnProgress: func(progress int) {
+					emit(map[string]any{"type": "progress", "progress": progress})
+				},
+				ShouldAbort: func() bool { return ctx.Err() != nil },
+			})
+			return result.Segments, err
+		},
+	}
+
+	for {
+		select {
+		case <-buf.wake:
+		case <-ctx.Done():
+			return
+		}
+		samples, done, inErr := buf.take()
+
+		if inErr != nil && !errors.Is(inErr, errLiveAudio) {
+			return // client went away without finishing
+		}
+		if inErr != nil {
+			err = inErr
+		} else if done {
+			err = ls.flush(samples)
+		} else {
+			err = ls.write(samples)
+		}
+		if errors.Is(err, errQueueFull) && !done {
+			continue // model busy; retry with more audio on the next step
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				status := websocket.StatusInternalError
+				switch {
+				case errors.Is(err, errLiveBacklog):
+					status = websocket.StatusTryAgainLater
+				case errors.Is(err, errLiveAudio):
+					status = websocket.StatusInvalidFramePayloadData
+				}
+				emit(map[string]any{"type": "error", "message": err.Error()})
+				conn.Close(status, truncateCloseReason(err.Error()))
+			}
+			return
+		}
+		if done {
+			emit(map[string]any{"type": "result", "text": ls.text()})
+			conn.Close(websocket.StatusNormalClosure, "")
+			return
+		}
+	}
+}
+
+// truncateCloseReason fits a message into a WebSocket close frame.
+func truncateCloseReason(reason string) string {
+	const maxReason = 120
+	if len(reason) <= maxReason {
+		return reason
+	}
+	return strings.ToValidUTF8(reason[:maxReason], "")
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"encoding/binary"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/thewh1teagle/sona/internal/whisper"
+)
+
+// fakeLiveStream returns a liveStream whose transcribe reports one segment
+// per second of audio and records emitted events.
+func fakeLiveStream(step, length int) (*liveStream, *[]map[string]any) {
+	var events []map[string]any
+	ls := &liveStream{
+		step:   step,
+		length: length,
+		transcribe: func(samples []float32, final bool) ([]whisper.Segment, error) {
+			var segs []whisper.Segment
+			for start := 0; start < len(samples); start += whisper.SampleRate {
+				end := min(start+whisper.SampleRate, len(samples))
+				segs = append(segs, whisper.Segment{Start: whisper.SamplesToCs(start), End: whisper.SamplesToCs(end), Text: "x"})
+			}
+			return segs, nil
+		},
+		emit: func(event map[string]any) error {
+			events = append(events, event)
+			return nil
+		},
+	}
+	return ls, &events
+}
+
+func TestLiveStreamPartialThenCommit(t *testing.T) {
+	sec := whisper.SampleRate
+	ls, events := fakeLiveStream(sec, 3*sec)
+
+	ls.write(make([]float32, sec/2))
+	if len(*events) != 0 {
+		t.Fatalf("decoded before a full step: %v", *events)
+	}
+	ls.write(make([]float32, sec/2))
+	if len(*events) != 1 || (*events)[0]["type"] != "partial" {
+		t.Fatalf("expected one partial event, got %v", *events)
+	}
+
+	// Reaching the window length commits all but the last segment, which
+	// starts the next window.
+	ls.write(make([]float32, 2*sec))
+	var segments int
+	for _, e := range *events {
+		if e["type"] == "segment" {
+			segments++
+		}
+	}
+	if segments != 2 {
+		t.Errorf("committed %d segments, want 2", segments)
+	}
+	if ls.offset != 200 || len(ls.buf) != sec {
+		t.Errorf("offset = %d cs, buffered %d samples; want 200 cs and %d", ls.offset, len(ls.buf), sec)
+	}
+
+	ls.flush(nil)
+	if len(ls.final) != 3 {
+		t.Fatalf("final segments = %d, want 3", len(ls.final))
+	}
+	if last := ls.final[2]; last.Start != 200 || last.End != 300 {
+		t.Errorf("last segment = %d-%d cs, want 200-300", last.Start, last.End)
+	}
+	if got := ls.text(); got != "xxx" {
+		t.Errorf("text = %q, want %q", got, "xxx")
+	}
+}
+
+func TestLiveStreamBacklog(t *testing.T) {
+	sec := whisper.SampleRate
+	ls, _ := fakeLiveStream(sec, 3*sec)
+	ls.max = 5 * sec
+	ls.transcribe = func([]float32, bool) ([]whisper.Segment, error) {
+		return nil, errQueueFull
+	}
+	for i := 0; i < 5; i++ {
+		if err := ls.write(make([]float32, sec)); err != errQueueFull {
+			t.Fatalf("write %d: got %v, want errQueueFull", i, err)
+		}
+	}
+	if err := ls.write(make([]float32, sec)); err != errLiveBacklog {
+		t.Errorf("write past max: got %v, want errLiveBacklog", err)
+	}
+}
+
+func TestLiveBufferBlocksWhenFull(t *testing.T) {
+	b := newLiveBuffer(4)
+	b.put(make([]float32, 4))
+
+	put := make(chan struct{})
+	go func() {
+		b.put(make([]float32, 2))
+		close(put)
+	}()
+	select {
+	case <-put:
+		t.Fatal("put did not wait for a full buffer")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if samples, ended, _ := b.take(); len(samples) != 4 || ended {
+		t.Errorf("take = %d samples, ended %v; want 4, false", len(samples), ended)
+	}
+	<-put
+
+	b.finish(nil)
+	if samples, ended, err := b.take(); len(samples) != 2 || !ended || err != nil {
+		t.Errorf("take = %d samples, ended %v, %v; want 2, true, nil", len(samples), ended, err)
+	}
+
+	// stop releases a put nobody will take.
+	b.put(make([]float32, 4))
+	go b.stop()
+	b.put(make([]float32, 1))
+}
+
+func TestPCMDecoderSplitFrames(t *testing.T) {
+	d, err := newPCMDecoder("pcm_s16le")
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := make([]byte, 4)
+	binary.LittleEndian.PutUint16(frame, uint16(math.MaxInt16))
+	binary.LittleEndian.PutUint16(frame[2:], uint16(0))
+
+	if got := d.decode(frame[:3]); len(got) != 1 || got[0] != 1 {
+		t.Fatalf("first frame = %v, want [1]", got)
+	}
+	if got := d.decode(frame[3:]); len(got) != 1 || got[0] != 0 {
+		t.Fatalf("second frame = %v, want [0]", got)
+	}
+
+	if _, err := newPCMDecoder("opus"); err == nil {
+		t.Error("expected error for unsupported encoding")
+	}
+}
+
+func TestAudioStreamNoModel(t *testing.T) {
+	s := New(false)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/v1/audio/stream", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", w.Code)
+	}
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/thewh1teagle/sona/internal/whisper"
+)
+
+var (
+	errNoModel       = errors.New("no model loaded")
+	errModelNotFound = errors.New("model not found")
+	errModelUnloaded = errors.New("model was unloaded")
+)
+
+// transcriber runs inference: a whisper.Context or one of its States.
+type transcriber interface {
+	whisper.Worker // idle ones are lent to stable timestamps
+	TranscribeStream(samples []float32, opts whisper.TranscribeOptions, cb whisper.StreamCallbacks) (whisper.TranscribeResult, error)
+	TranscribeReader(src whisper.SampleReader, opts whisper.TranscribeOptions, cb whisper.StreamCallbacks) (whisper.TranscribeResult, error)
+	DetectLanguage(samples []float32, threads int) (string, map[string]float32, error)
+}
+
+// model is a loaded whisper model together with the queue of
+// transcriptions waiting to run on it.
+type model struct {
+	name     string
+	path     string
+	loadedAt time.Time
+	queue    *jobQueue
+
+	mu      sync.RWMutex     // read-held during inference, write-held while closing
+	ctx     *whisper.Context // nil once closed
+	states  []*whisper.State
+	workers chan transcriber // ctx and states not currently in use
+}
+
+// newModel wraps a loaded context. With parallel > 1 it allocates extra
+// decoding states so that many transcriptions share the loaded weights.
+func newModel(name, path string, ctx *whisper.Context, parallel int) (*model, error) {
+	if parallel < 1 {
+		parallel = 1
+	}
+	m := &model{
+		name:     name,
+		path:     path,
+		loadedAt: time.Now(),
+		queue:    newJobQueue(parallel),
+		ctx:      ctx,
+		workers:  make(chan transcriber, parallel),
+	}
+	if ctx == nil {
+		return m, nil
+	}
+	m.workers <- ctx
+	for i := 1; i < parallel; i++ {
+		state, err := ctx.NewState()
+		if err != nil {
+			m.close()
+			return nil, fmt.Errorf("allocating state %d of %d: %w", i+1, parallel, err)
+		}
+		m.states = append(m.states, state)
+		m.workers <- state
+	}
+	return m, nil
+}
+
+// transcribe runs inference on a free worker.
+func (m *model) transcribe(samples []float32, opts whisper.TranscribeOptions, cb whisper.StreamCallbacks) (result whisper.TranscribeResult, err error) {
+	err = m.run(func(worker transcriber) (err error) {
+		opts, giveBack := m.lendWorkers(opts)
+		defer giveBack()
+		result, err = worker.TranscribeStream(samples, opts, cb)
+		return err
+	})
+	return result, err
+}
+
+// transcribeReader runs chunked inference on a free worker.
+func (m *model) transcribeReader(src whisper.SampleReader, opts whisper.TranscribeOptions, cb whisper.StreamCallbacks) (result whisper.TranscribeResult, err error) {
+	err = m.run(func(worker transcriber) (err error) {
+		opts, giveBack := m.lendWorkers(opts)
+		defer giveBack()
+		result, err = worker.TranscribeReader(src, opts, cb)
+		return err
+	})
+	return result, err
+}
+
+// detectLanguage identifies the spoken language on a free worker.
+func (m *model) detectLanguage(samples []float32, threads int) (lang string, probs map[string]float32, err error) {
+	err = m.run(func(worker transcriber) (err error) {
+		lang, probs, err = worker.DetectLanguage(samples, threads)
+		return err
+	})
+	return lang, probs, err
+}
+
+// lendWorkers gives stable timestamps with stable_workers > 1 the model's
+// idle workers to decode regions on, rather than letting each request
+// allocate whisper states of its own. Their queue slots are taken with them,
+// so a lent worker counts against --parallel, and none are taken from jobs
+// waiting in line: with no idle worker the regions decode one at a time.
+// Call the returned func to hand them back.
+func (m *model) lendWorkers(opts whisper.TranscribeOptions) (whisper.TranscribeOptions, func()) {
+	if !opts.StableTimestamps || opts.CarryPrompt || opts.StableWorkers <= 1 {
+		return opts, func() {}
+	}
+	n := m.queue.takeIdle(opts.StableWorkers - 1)
+	opts.StableLent = make([]whisper.Worker, 0, n)
+	for range n {
+		opts.StableLent = append(opts.StableLent, <-m.workers) // a slot guarantees a free worker
+	}
+	return opts, func() {
+		for _, w := range opts.StableLent {
+			m.workers <- w.(transcriber)
+		}
+		m.queue.giveBack(n)
+	}
+}
+
+// run calls fn with a free worker, converting panics into errors. The
+// model's queue guarantees a worker is free for every running job. Fails
+// if the model was unloaded.
+func (m *model) run(fn func(worker transcriber) error) (err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.ctx == nil {
+		return errModelUnloaded
+	}
+	worker := <-m.workers
+	defer func() { m.workers <- worker }()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("internal error: %v", r)
+			log.Printf("panic during inference: %v", r)
+		}
+	}()
+	return fn(worker)
+}
+
+// close frees the model once running inferences have finished.
+func (m *model) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, state := range m.states {
+		state.Close()
+	}
+	m.states = nil
+	if m.ctx != nil {
+		m.ctx.Close()
+		m.ctx = nil
+	}
+}
+
+// resolveModel picks the model for a request's OpenAI "model" field. An
+// empty name selects the default (first loaded) model. Unknown names fall
+// back to the only loaded model so clients that always send e.g.
+// "whisper-1" keep working; with several models loaded they are an error.
+func (s *Server) resolveModel(name string) (*model, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.models) == 0 {
+		return nil, errNoModel
+	}
+	if name == "" {
+		return s.models[0], nil
+	}
+	for _, m := range s.models {
+		if m.name == name {
+			return m, nil
+		}
+	}
+	if len(s.models) == 1 {
+		return s.models[0], nil
+	}
+	return nil, fmt.Errorf("%w: %q", errModelNotFound, name)
+}
+
+// hasModel reports whether any model is loaded.
+func (s *Server) hasModel() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.models) > 0
+}
+
+// loadedModels returns a snapshot of the loaded models in load order.
+func (s *Server) loadedModels() []*model {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*model(nil), s.models...)
+}
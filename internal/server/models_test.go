@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/thewh1teagle/sona/internal/whisper"
+)
+
+// withModels registers placeholder models (no whisper context) by name.
+func withModels(s *Server, names ...string) {
+	for _, name := range names {
+		m, _ := newModel(name, "/models/"+name, nil, 1)
+		s.models = append(s.models, m)
+	}
+}
+
+func TestResolveModel(t *testing.T) {
+	s := New(false)
+	if _, err := s.resolveModel(""); !errors.Is(err, errNoModel) {
+		t.Fatalf("no models: got %v, want errNoModel", err)
+	}
+
+	withModels(s, "tiny")
+	for _, name := range []string{"", "tiny", "whisper-1"} {
+		m, err := s.resolveModel(name)
+		if err != nil || m.name != "tiny" {
+			t.Errorf("single model, resolveModel(%q) = %v, %v; want tiny", name, m, err)
+		}
+	}
+
+	withModels(s, "large-v3")
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"", "tiny"},
+		{"tiny", "tiny"},
+		{"large-v3", "large-v3"},
+	}
+	for _, tt := range tests {
+		m, err := s.resolveModel(tt.name)
+		if err != nil || m.name != tt.want {
+			t.Errorf("resolveModel(%q) = %v, %v; want %s", tt.name, m, err, tt.want)
+		}
+	}
+	if _, err := s.resolveModel("whisper-1"); !errors.Is(err, errModelNotFound) {
+		t.Errorf("unknown model with several loaded: got %v, want errModelNotFound", err)
+	}
+}
+
+func TestModelsListsAll(t *testing.T) {
+	s := New(false)
+	withModels(s, "tiny", "large-v3")
+	req := httptest.NewRequest("GET", "/v1/models", nil)
+	w := httptest.NewRecorder()
+	s.handleModels(w, req)
+
+	var body struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	json.NewDecoder(w.Body).Decode(&body)
+	if len(body.Data) != 2 || body.Data[0].ID != "tiny" || body.Data[1].ID != "large-v3" {
+		t.Errorf("got %+v, want [tiny large-v3]", body.Data)
+	}
+}
+
+func TestModelUnloadNamed(t *testing.T) {
+	s := New(false)
+	withModels(s, "tiny", "large-v3")
+
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest("DELETE", "/v1/models/tiny", nil))
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if models := s.loadedModels(); len(models) != 1 || models[0].name != "large-v3" {
+		t.Errorf("remaining models = %v, want [large-v3]", models)
+	}
+
+	w = httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest("DELETE", "/v1/models/tiny", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unloading missing model: expected 404, got %d", w.Code)
+	}
+}
+
+func TestLendWorkersCountsAgainstParallel(t *testing.T) {
+	m, _ := newModel("tiny", "/models/tiny", nil, 3)
+	for range 3 {
+		m.workers <- &whisper.State{}
+	}
+	running, _ := m.queue.enqueue(0)
+	defer running.release()
+	<-m.workers // the running job's own worker
+
+	opts := whisper.TranscribeOptions{StableTimestamps: true, StableWorkers: 4}
+	lent, giveBack := m.lendWorkers(opts)
+	if len(lent.StableLent) != 2 || m.queue.running != 3 {
+		t.Fatalf("lent %d workers, %d slots running; want 2 and 3", len(lent.StableLent), m.queue.running)
+	}
+	if _, err := m.queue.enqueue(0); err != errQueueFull {
+		t.Errorf("lent workers left a slot free: %v", err)
+	}
+	giveBack()
+	if len(m.workers) != 2 || m.queue.running != 1 {
+		t.Errorf("after giveBack: %d idle workers, %d slots running; want 2 and 1", len(m.workers), m.queue.running)
+	}
+
+	// With every slot taken nothing is lent, and no states are allocated
+	// instead.
+	for range 2 {
+		other, _ := m.queue.enqueue(0)
+		defer other.release()
+	}
+	if lent, giveBack := m.lendWorkers(opts); lent.StableLent == nil || len(lent.StableLent) != 0 {
+		t.Errorf("lent %v with every slot taken, want an empty, non-nil list", lent.StableLent)
+	} else {
+		giveBack()
+	}
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// errQueueFull is returned by enqueue when every waiting slot is taken.
+var errQueueFull = errors.New("transcription queue is full")
+
+// jobQueue admits transcription jobs in FIFO order. Up to slots jobs run at
+// once; the rest wait in line until a running job releases its ticket.
+type jobQueue struct {
+	mu      sync.Mutex
+	slots   int
+	running int
+	waiting []*ticket
+	room    chan struct{} // closed when the line moves, for enqueueWait
+}
+
+func newJobQueue(slots int) *jobQueue {
+	if slots < 1 {
+		slots = 1
+	}
+	return &jobQueue{slots: slots}
+}
+
+// ticket is a job's place in a jobQueue.
+type ticket struct {
+	q       *jobQueue
+	ready   chan struct{} // closed once the job may run
+	changed chan struct{} // signalled when the position in line changes
+	held    bool          // in line, but not ready to run until activate
+	running bool
+	done    bool
+}
+
+// enqueue takes a place in line. If a slot is free the ticket is ready
+// immediately; otherwise it waits behind the jobs already queued. limit is
+// the maximum number of waiting jobs; errQueueFull is returned beyond it.
+func (q *jobQueue) enqueue(limit int) (*ticket, error) {
+	return q.add(limit, false)
+}
+
+// hold takes a place in line, like enqueue, for a job that cannot run yet
+// (e.g. its upload is still arriving). The ticket never takes a slot until
+// activate, so jobs behind it that are ready run first, but it keeps its
+// place for when it is ready. A held ticket counts against limit, or takes
+// up a free slot's worth of room.
+func (q *jobQueue) hold(limit int) (*ticket, error) {
+	return q.add(limit, true)
+}
+
+// enqueueWait is enqueue for a job that must not be turned away: while the
+// queue is full it waits for room in line, until ctx is done.
+func (q *jobQueue) enqueueWait(ctx context.Context, limit int) (*ticket, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		t, err := q.addLocked(limit, false)
+		if err != errQueueFull {
+			return t, err
+		}
+		if q.room == nil {
+			q.room = make(chan struct{})
+		}
+		room := q.room
+		q.mu.Unlock()
+		select {
+		case <-room:
+		case <-ctx.Done():
+			q.mu.Lock()
+			return nil, ctx.Err()
+		}
+		q.mu.Lock()
+	}
+}
+
+func (q *jobQueue) add(limit int, held bool) (*ticket, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.addLocked(limit, held)
+}
+
+func (q *jobQueue) addLocked(limit int, held bool) (*ticket, error) {
+	// Free slots make room beyond limit, for jobs that will take them as
+	// soon as they are ready.
+	if len(q.waiting) >= limit+q.slots-q.running {
+		return nil, errQueueFull
+	}
+	t := &ticket{
+		q:       q,
+		ready:   make(chan struct{}),
+		changed: make(chan struct{}, 1),
+		held:    held,
+	}
+	q.waiting = append(q.waiting, t)
+	q.promoteLocked()
+	return t, nil
+}
+
+// activate makes a held ticket ready to run, in its place in line.
+func (t *ticket) activate() {
+	q := t.q
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	t.held = false
+	q.promoteLocked()
+}
+
+// takeIdle claims up to n free slots that no job in line is ready to take,
+// returning how many it got. They must be handed back with giveBack.
+func (q *jobQueue) takeIdle(n int) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, w := range q.waiting {
+		if !w.held {
+			return 0
+		}
+	}
+	k := max(0, min(n, q.slots-q.running))
+	q.running += k
+	return k
+}
+
+// giveBack returns n slots claimed by takeIdle.
+func (q *jobQueue) giveBack(n int) {
+	if n == 0 {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.running -= n
+	q.promoteLocked()
+}
+
+// position returns the 1-based place in line, or 0 once the job may run.
+func (t *ticket) position() int {
+	t.q.mu.Lock()
+	defer t.q.mu.Unlock()
+	return t.positionLocked()
+}
+
+func (t *ticket) positionLocked() int {
+	if t.running {
+		return 0
+	}
+	for i, w := range t.q.waiting {
+		if w == t {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// wait blocks until the job may run. onPosition, if non-nil, is called with
+// the current place in line on entry and whenever it changes. If ctx is done
+// first the ticket is released and ctx's error returned.
+func (t *ticket) wait(ctx context.Context, onPosition func(position int)) error {
+	last := -1
+	for {
+		if pos := t.position(); pos > 0 && pos != last {
+			last = pos
+			if onPosition != nil {
+				onPosition(pos)
+			}
+		}
+		select {
+		case <-t.ready:
+			return nil
+		case <-t.changed:
+		case <-ctx.Done():
+			t.release()
+			return ctx.Err()
+		}
+	}
+}
+
+// release gives up the ticket: a running job frees its slot for the next in
+// line, a waiting job leaves the queue. Safe to call more than once.
+func (t *ticket) release() {
+	q := t.q
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if t.done {
+		return
+	}
+	t.done = true
+
+	if t.running {
+		q.running--
+	} else {
+		for i, w := range q.waiting {
+			if w == t {
+				q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+				break
+			}
+		}
+	}
+	q.promoteLocked()
+}
+
+// promoteLocked hands free slots to the first tickets in line that are not
+// held, then tells the rest their position may have changed.
+func (q *jobQueue) promoteLocked() {
+	for i := 0; i < len(q.waiting) && q.running < q.slots; {
+		next := q.waiting[i]
+		if next.held {
+			i++
+			continue
+		}
+		q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+		q.running++
+		next.running = true
+		close(next.ready)
+	}
+	for _, w := range q.waiting {
+		select {
+		case w.changed <- struct{}{}:
+		default:
+		}
+	}
+	if q.room != nil {
+		close(q.room)
+		q.room = nil
+	}
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestQueueRunsImmediatelyWhenIdle(t *testing.T) {
+	q := newJobQueue(1)
+	tk, err := q.enqueue(0)
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if pos := tk.position(); pos != 0 {
+		t.Errorf("position = %d, want 0", pos)
+	}
+	if err := tk.wait(context.Background(), nil); err != nil {
+		t.Errorf("wait: %v", err)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	q := newJobQueue(1)
+	running, _ := q.enqueue(1)
+	defer running.release()
+	waiting, err := q.enqueue(1)
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	defer waiting.release()
+	if _, err := q.enqueue(1); err != errQueueFull {
+		t.Errorf("enqueue on full queue: got %v, want errQueueFull", err)
+	}
+}
+
+func TestQueueFIFO(t *testing.T) {
+	q := newJobQueue(1)
+	first, _ := q.enqueue(2)
+	second, _ := q.enqueue(2)
+	third, _ := q.enqueue(2)
+
+	if pos := second.position(); pos != 1 {
+		t.Errorf("second position = %d, want 1", pos)
+	}
+	if pos := third.position(); pos != 2 {
+		t.Errorf("third position = %d, want 2", pos)
+	}
+
+	positions := make(chan int, 4)
+	done := make(chan error, 1)
+	go func() {
+		done <- third.wait(context.Background(), func(p int) { positions <- p })
+	}()
+	if p := <-positions; p != 2 {
+		t.Errorf("first reported position = %d, want 2", p)
+	}
+
+	first.release()
+	if err := second.wait(context.Background(), nil); err != nil {
+		t.Fatalf("second wait: %v", err)
+	}
+	second.release()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("third wait: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("third job never started")
+	}
+	third.release()
+}
+
+func TestQueueCancelLeavesLine(t *testing.T) {
+	q := newJobQueue(1)
+	running, _ := q.enqueue(2)
+	cancelled, _ := q.enqueue(2)
+	last, _ := q.enqueue(2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := cancelled.wait(ctx, nil); err != context.Canceled {
+		t.Fatalf("wait with cancelled ctx: got %v, want context.Canceled", err)
+	}
+	if pos := last.position(); pos != 1 {
+		t.Errorf("position after cancellation = %d, want 1", pos)
+	}
+
+	running.release()
+	if pos := last.position(); pos != 0 {
+		t.Errorf("position after release = %d, want 0", pos)
+	}
+	last.release()
+}
+
+func TestQueueHoldKeepsPlaceWithoutSlot(t *testing.T) {
+	q := newJobQueue(1)
+	uploading, err := q.hold(1)
+	if err != nil {
+		t.Fatalf("hold: %v", err)
+	}
+	if pos := uploading.position(); pos != 1 {
+		t.Errorf("held position = %d, want 1", pos)
+	}
+
+	// A ready job runs while the held one is still uploading.
+	ready, err := q.enqueue(1)
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if pos := ready.position(); pos != 0 {
+		t.Errorf("ready position = %d, want 0", pos)
+	}
+	if _, err := q.enqueue(1); err != errQueueFull {
+		t.Errorf("enqueue past a held ticket: got %v, want errQueueFull", err)
+	}
+
+	// Once activated, the held job keeps its place ahead of later jobs.
+	uploading.activate()
+	ready.release()
+	if pos := uploading.position(); pos != 0 {
+		t.Errorf("position after activate and release = %d, want 0", pos)
+	}
+	uploading.release()
+}
+
+func TestQueueEnqueueWaitsForRoom(t *testing.T) {
+	q := newJobQueue(1)
+	running, _ := q.enqueue(0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := q.enqueueWait(ctx, 0); err != context.DeadlineExceeded {
+		t.Fatalf("enqueueWait on a full queue: got %v, want the ctx error", err)
+	}
+
+	got := make(chan *ticket)
+	go func() {
+		tk, _ := q.enqueueWait(context.Background(), 0)
+		got <- tk
+	}()
+	running.release()
+	select {
+	case tk := <-got:
+		if tk == nil || tk.position() != 0 {
+			t.Error("waiting job did not take the freed slot")
+		}
+		tk.release()
+	case <-time.After(time.Second):
+		t.Fatal("enqueueWait never found room")
+	}
+}
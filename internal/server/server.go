@@ -14,6 +14,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/thewh1teagle/sona/internal/diarize"
 	"github.com/thewh1teagle/sona/internal/whisper"
 )
 
@@ -21,59 +22,106 @@ const maxUploadSize = 15 << 30 // 15 GB
 
 type Server struct {
 	mu        sync.Mutex
-	ctx       *whisper.Context // nil when no model loaded
-	modelName string
-	modelPath string
+	models    []*model              // in load order; the first is the default
+	vads      map[string]*loadedVAD // VAD models loaded with POST /v1/vad/load, by path
 	verbose   bool
+	jobs      *jobStore
 	Version   string
 	Commit    string
+	QueueSize int // transcriptions allowed to wait per model for a free slot (0 = reject when busy)
+	Parallel  int // concurrent transcriptions per model, each on its own whisper state (0 = 1)
+	// MaxAudioInMemory is how many seconds of decoded audio an upload may
+	// keep in memory (0 = 30 minutes). Longer uploads are spooled to disk
+	// and transcribed in chunks.
+	MaxAudioInMemory int
+	// SpeakersPath is the JSON file holding enrolled speakers ("" =
+	// enrollment and identify_speakers are unavailable).
+	SpeakersPath string
+	// DiarizeTimeout bounds each diarization (0 = 30 minutes); the
+	// transcription is returned without speakers when it runs out.
+	DiarizeTimeout time.Duration
+	speakers       *diarize.Store // opened from SpeakersPath on first use
 }
 
 func New(verbose bool) *Server {
-	return &Server{verbose: verbose}
+	return &Server{verbose: verbose, jobs: newJobStore()}
 }
 
-// LoadModel loads a whisper model, unloading any existing one first.
+// LoadModel loads a whisper model under name (empty = file name of path),
+// replacing any model already loaded under that name.
 // gpuDevice selects the GPU (-1 = use whisper default).
-func (s *Server) LoadModel(path string, gpuDevice int, noGpu bool) error {
+func (s *Server) LoadModel(name, path string, gpuDevice int, noGpu bool) (string, error) {
+	if name == "" {
+		name = filepath.Base(path)
+	}
+	ctx, err := whisper.New(path, gpuDevice, noGpu)
+	if err != nil {
+		return "", err
+	}
+	m, err := newModel(name, path, ctx, s.Parallel)
+	if err != nil {
+		return "", err
+	}
+
 	s.mu.Lock()
-	defer s.mu.Unlock()
-	return s.loadModelLocked(path, gpuDevice, noGpu)
+	var old *model
+	replaced := false
+	for i, existing := range s.models {
+		if existing.name == name {
+			old = existing
+			s.models[i] = m
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		s.models = append(s.models, m)
+	}
+	s.mu.Unlock()
+
+	if old != nil {
+		old.close()
+	}
+	return name, nil
 }
 
-func (s *Server) loadModelLocked(path string, gpuDevice int, noGpu bool) error {
-	if s.ctx != nil {
-		s.ctx.Close()
-		s.ctx = nil
-		s.modelName = ""
-		s.modelPath = ""
+// UnloadModel frees the model loaded under name and reports whether it was
+// loaded. A running transcription on it finishes first.
+func (s *Server) UnloadModel(name string) bool {
+	s.mu.Lock()
+	var m *model
+	for i, existing := range s.models {
+		if existing.name == name {
+			m = existing
+			s.models = append(s.models[:i], s.models[i+1:]...)
+			break
+		}
 	}
+	s.mu.Unlock()
 
-	ctx, err := whisper.New(path, gpuDevice, noGpu)
-	if err != nil {
-		return err
+	if m == nil {
+		return false
 	}
-	s.ctx = ctx
-	s.modelPath = path
-	s.modelName = filepath.Base(path)
-	return nil
+	m.close()
+	return true
 }
 
-// UnloadModel frees the current model. Safe to call with no model loaded.
-func (s *Server) UnloadModel() {
+// UnloadAll frees every loaded model. Safe to call with no model loaded.
+func (s *Server) UnloadAll() {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-	if s.ctx != nil {
-		s.ctx.Close()
-		s.ctx = nil
-		s.modelName = ""
-		s.modelPath = ""
+	models := s.models
+	s.models = nil
+	s.mu.Unlock()
+
+	for _, m := range models {
+		m.close()
 	}
 }
 
 // Close frees all resources.
 func (s *Server) Close() {
-	s.UnloadModel()
+	s.UnloadAll()
+	s.UnloadAllVAD()
 }
 
 func recoveryMiddleware(next http.Handler) http.Handler {
@@ -94,8 +142,22 @@ func (s *Server) Handler() http.Handler {
 	mux.HandleFunc("GET /ready", s.handleReady)
 	mux.HandleFunc("POST /v1/models/load", s.handleModelLoad)
 	mux.HandleFunc("DELETE /v1/models", s.handleModelUnload)
+	mux.HandleFunc("DELETE /v1/models/{name}", s.handleModelUnload)
 	mux.HandleFunc("POST /v1/audio/transcriptions", s.handleTranscription)
+	mux.HandleFunc("GET /v1/audio/stream", s.handleAudioStream)
+	mux.HandleFunc("POST /v1/audio/language", s.handleLanguageDetection)
+	mux.HandleFunc("POST /v1/audio/vad", s.handleVAD)
+	mux.HandleFunc("GET /v1/vad", s.handleVADList)
+	mux.HandleFunc("POST /v1/vad/load", s.handleVADLoad)
+	mux.HandleFunc("DELETE /v1/vad", s.handleVADUnload)
 	mux.HandleFunc("GET /v1/models", s.handleModels)
+	mux.HandleFunc("POST /v1/speakers", s.handleSpeakerEnroll)
+	mux.HandleFunc("GET /v1/speakers", s.handleSpeakerList)
+	mux.HandleFunc("DELETE /v1/speakers/{name}", s.handleSpeakerDelete)
+	mux.HandleFunc("POST /v1/jobs", s.handleJobCreate)
+	mux.HandleFunc("GET /v1/jobs/{id}", s.handleJobGet)
+	mux.HandleFunc("GET /v1/jobs/{id}/result", s.handleJobResult)
+	mux.HandleFunc("DELETE /v1/jobs/{id}", s.handleJobDelete)
 	s.registerDocsRoutes(mux)
 	return recoveryMiddleware(mux)
 }
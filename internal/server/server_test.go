@@ -67,6 +67,16 @@ func TestTranscriptionNoModel(t *testing.T) {
 	}
 }
 
+func TestLanguageDetectionNoModel(t *testing.T) {
+	s := New(false)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest("POST", "/v1/audio/language", nil))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", w.Code)
+	}
+}
+
 func TestModelUnloadIdempotent(t *testing.T) {
 	s := New(false)
 	req := httptest.NewRequest("DELETE", "/v1/models", nil)
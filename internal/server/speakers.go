@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/thewh1teagle/sona/internal/diarize"
+	"github.com/thewh1teagle/sona/internal/whisper"
+)
+
+// maxEnrollSeconds is how much of an enrollment upload is embedded.
+const maxEnrollSeconds = 5 * 60
+
+var errNoSpeakerStore = errors.New("speaker enrollment is disabled (no speaker store configured)")
+
+// speakerStore returns the enrolled speakers at SpeakersPath, opening the
+// store on first use.
+func (s *Server) speakerStore() (*diarize.Store, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.speakers != nil {
+		return s.speakers, nil
+	}
+	if s.SpeakersPath == "" {
+		return nil, errNoSpeakerStore
+	}
+	store, err := diarize.OpenStore(s.SpeakersPath)
+	if err != nil {
+		return nil, err
+	}
+	s.speakers = store
+	return store, nil
+}
+
+// handleSpeakerEnroll adds a voice sample to the speaker named in the
+// form, creating them if needed. It takes the same multipart form as
+// /v1/audio/transcriptions plus "name"; the first five minutes of the
+// upload are used.
+func (s *Server) handleSpeakerEnroll(w http.ResponseWriter, r *http.Request) {
+	store, err := s.speakerStore()
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, ErrCodeInternalError, err.Error())
+		return
+	}
+	req := s.parseTranscriptionRequest(w, r, nil)
+	if req == nil {
+		return
+	}
+	defer req.close()
+
+	name := r.FormValue("name")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "'name' is required")
+		return
+	}
+	samples, err := req.head(maxEnrollSeconds * whisper.SampleRate)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternalError, "failed to read audio: "+err.Error())
+		return
+	}
+	p := diarize.NewPipeline()
+	embedding, err := p.EmbedSpeech(samples)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidAudio, "failed to embed voice: "+err.Error())
+		return
+	}
+	profile, err := store.Enroll(name, p.EmbedderName(), embedding)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternalError, "failed to enroll speaker: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":  "enrolled",
+		"name":    profile.Name,
+		"samples": profile.Samples,
+	})
+}
+
+// handleSpeakerList lists enrolled speakers by name.
+func (s *Server) handleSpeakerList(w http.ResponseWriter, r *http.Request) {
+	data := []map[string]any{}
+	if store, err := s.speakerStore(); err == nil {
+		for _, p := range store.Profiles() {
+			data = append(data, map[string]any{"name": p.Name, "samples": p.Samples})
+		}
+	} else if !errors.Is(err, errNoSpeakerStore) {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternalError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"speakers": data})
+}
+
+// handleSpeakerDelete removes the enrolled speaker named in the path.
+func (s *Server) handleSpeakerDelete(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	store, err := s.speakerStore()
+	if errors.Is(err, errNoSpeakerStore) {
+		writeError(w, http.StatusNotFound, ErrCodeSpeakerNotFound, "speaker not enrolled: "+name)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternalError, err.Error())
+		return
+	}
+	removed, err := store.Remove(name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternalError, "failed to remove speaker: "+err.Error())
+		return
+	}
+	if !removed {
+		writeError(w, http.StatusNotFound, ErrCodeSpeakerNotFound, "speaker not enrolled: "+name)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "removed"})
+}
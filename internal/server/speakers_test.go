@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/thewh1teagle/sona/internal/wav"
+	"github.com/thewh1teagle/sona/internal/whisper"
+)
+
+// enrollRequest builds a POST /v1/speakers upload of a two-second tone
+// with harmonics.
+func enrollRequest(name string) *http.Request {
+	samples := make([]float32, 2*whisper.SampleRate)
+	for i := range samples {
+		t := float64(i) / whisper.SampleRate
+		samples[i] = float32(0.3*math.Sin(2*math.Pi*150*t) + 0.1*math.Sin(2*math.Pi*450*t))
+	}
+	var audioBuf bytes.Buffer
+	wav.Write(&audioBuf, samples)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("name", name)
+	fw, _ := mw.CreateFormFile("file", "voice.wav")
+	fw.Write(audioBuf.Bytes())
+	mw.Close()
+	r := httptest.NewRequest("POST", "/v1/speakers", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestSpeakerEndpoints(t *testing.T) {
+	s := New(false)
+	s.SpeakersPath = filepath.Join(t.TempDir(), "speakers.json")
+	h := s.Handler()
+
+	for range 2 {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, enrollRequest("alice"))
+		if w.Code != http.StatusOK {
+			t.Fatalf("enroll: got %d %s", w.Code, w.Body.String())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/v1/speakers", nil))
+	var list struct {
+		Speakers []struct {
+			Name    string
+			Samples int
+		}
+	}
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil || len(list.Speakers) != 1 || list.Speakers[0].Samples != 2 {
+		t.Fatalf("GET /v1/speakers = %+v, %v; want alice with 2 samples", list, err)
+	}
+
+	for _, want := range []int{http.StatusOK, http.StatusNotFound} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("DELETE", "/v1/speakers/alice", nil))
+		if w.Code != want {
+			t.Errorf("DELETE /v1/speakers/alice: got %d, want %d", w.Code, want)
+		}
+	}
+}
+
+func TestSpeakerEnrollWithoutStore(t *testing.T) {
+	w := httptest.NewRecorder()
+	New(false).Handler().ServeHTTP(w, enrollRequest("alice"))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("enroll without a store: got %d, want 503", w.Code)
+	}
+}
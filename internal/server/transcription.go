@@ -0,0 +1,644 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thewh1teagle/sona/internal/audio"
+	"github.com/thewh1teagle/sona/internal/diarize"
+	"github.com/thewh1teagle/sona/internal/transcript"
+	"github.com/thewh1teagle/sona/internal/wav"
+	"github.com/thewh1teagle/sona/internal/whisper"
+)
+
+// transcriptionRequest is a decoded /v1/audio/transcriptions multipart form.
+// It is shared by the synchronous endpoint and async jobs.
+type transcriptionRequest struct {
+	samples        []float32 // decoded audio, nil if it was spooled to audioPath
+	audioPath      string    // native WAV on disk for uploads over Server.MaxAudioInMemory
+	audioLen       int       // length of the audio in samples
+	opts           whisper.TranscribeOptions
+	model          string // OpenAI "model" field, resolved by resolveModel
+	responseFormat string
+	words          bool // word timings asked for, not only forced by diarization
+	stream         bool
+	diarizer       diarize.Diarizer // nil unless diarization was requested
+	diarizeOpts    diarize.Options
+	profiles       []diarize.Profile // enrolled speakers to identify, if asked
+	speakerThresh  float64           // similarity needed to take an enrolled name
+	diarizeTimeout time.Duration
+	diarizePath    string   // native WAV on disk for the diarizer
+	tempFiles      []string // removed by close
+	releaseVAD     func()   // releases opts.VadContext, called by close
+}
+
+// close removes temp files created while parsing the request and releases
+// its VAD model.
+func (req *transcriptionRequest) close() {
+	for _, path := range req.tempFiles {
+		os.Remove(path)
+	}
+	req.tempFiles = nil
+	if req.releaseVAD != nil {
+		req.releaseVAD()
+		req.releaseVAD = nil
+	}
+}
+
+// diarize runs speaker diarization if it was requested, returning nil
+// segments otherwise. It gives up when ctx is done or after the request's
+// diarization timeout. Failures do not fail the transcription: they are
+// logged and returned as warnings for the client, with nil segments if
+// diarization itself failed.
+func (req *transcriptionRequest) diarize(ctx context.Context) ([]diarize.Segment, []string) {
+	if req.diarizer == nil || req.diarizePath == "" {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, req.diarizeTimeout)
+	defer cancel()
+
+	// A diarizer that ignores ctx is left to finish in the background
+	// rather than holding up the response.
+	type diarResult struct {
+		segments   []diarize.Segment
+		embeddings map[int][]float32 // per speaker, when profiles are set
+		embedder   string
+		err        error
+	}
+	done := make(chan diarResult, 1)
+	go func() {
+		var res diarResult
+		if ed, ok := req.diarizer.(diarize.EmbeddingDiarizer); ok && req.profiles != nil {
+			res.segments, res.embeddings, res.err = ed.DiarizeEmbeddings(ctx, req.diarizePath, req.diarizeOpts)
+			res.embedder = ed.EmbedderName()
+		} else {
+			res.segments, res.err = req.diarizer.Diarize(ctx, req.diarizePath, req.diarizeOpts)
+		}
+		done <- res
+	}()
+	var res diarResult
+	select {
+	case res = <-done:
+	case <-ctx.Done():
+		res.err = ctx.Err()
+	}
+	err := res.err
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %s", req.diarizeTimeout)
+	}
+	if err != nil {
+		log.Printf("diarization failed (continuing without speakers): %v", err)
+		return nil, []string{"diarization failed: " + err.Error()}
+	}
+	if req.profiles == nil {
+		return res.segments, nil
+	}
+	if res.embeddings == nil {
+		// The subprocess diarizer has no embeddings of its own, so its
+		// speakers are embedded from the audio as enrollments were.
+		p := diarize.NewPipeline()
+		res.embedder = p.EmbedderName()
+		res.embeddings, err = diarize.SpeakerEmbeddings(ctx, req.diarizePath, res.segments, p)
+		if err != nil {
+			log.Printf("speaker identification failed (keeping anonymous speakers): %v", err)
+			return res.segments, []string{"speaker identification failed: " + err.Error()}
+		}
+	}
+	return diarize.Identify(res.segments, res.embeddings, res.embedder, req.profiles, req.speakerThresh), nil
+}
+
+// transcribe runs whisper on the request's audio. Audio spooled to disk,
+// or any audio when chunk_length was given, is decoded in windows by
+// whisper.TranscribeReader.
+func (req *transcriptionRequest) transcribe(m *model, cb whisper.StreamCallbacks) (whisper.TranscribeResult, error) {
+	if req.audioPath == "" && req.opts.ChunkSeconds == 0 {
+		return m.transcribe(req.samples, req.opts, cb)
+	}
+	src, closeSrc, err := req.reader()
+	if err != nil {
+		return whisper.TranscribeResult{}, err
+	}
+	defer closeSrc()
+	return m.transcribeReader(src, req.opts, cb)
+}
+
+// reader returns the request's audio as a whisper.SampleReader, read from
+// disk if it was spooled. The caller must call the returned close func.
+func (req *transcriptionRequest) reader() (whisper.SampleReader, func(), error) {
+	if req.audioPath == "" {
+		return whisper.NewSampleReader(req.samples), func() {}, nil
+	}
+	stream, err := audio.OpenStream(req.audioPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open spooled audio: %w", err)
+	}
+	return sizedStream{stream, req.audioLen}, func() { stream.Close() }, nil
+}
+
+// sizedStream gives whisper the length of a spooled upload so chunked
+// transcription can report progress.
+type sizedStream struct {
+	*audio.Stream
+	n int
+}
+
+func (s sizedStream) Len() int { return s.n }
+
+// head returns up to n samples from the start of the audio.
+func (req *transcriptionRequest) head(n int) ([]float32, error) {
+	if req.audioPath == "" {
+		return req.samples[:min(n, len(req.samples))], nil
+	}
+	stream, err := audio.OpenStream(req.audioPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spooled audio: %w", err)
+	}
+	defer stream.Close()
+	samples := make([]float32, n)
+	read := 0
+	for read < n {
+		k, err := stream.ReadSamples(samples[read:])
+		read += k
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return samples[:read], nil
+}
+
+// defaultMaxAudioInMemory is Server.MaxAudioInMemory's default, in seconds.
+const defaultMaxAudioInMemory = 30 * 60
+
+// defaultDiarizeTimeout is Server.DiarizeTimeout's default.
+const defaultDiarizeTimeout = 30 * time.Minute
+
+// maxFormFieldSize caps each non-file multipart field.
+const maxFormFieldSize = 1 << 20
+
+var errInvalidForm = errors.New("missing or invalid 'file' field")
+
+// errNotAdmitted stops reading an upload that beforeFile turned away.
+var errNotAdmitted = errors.New("request not admitted")
+
+// parseQueuedRequest parses a transcription request and takes a ticket in
+// its model's queue. The place in line is held from when the "file" part is
+// reached, so a busy server refuses an upload without reading it; the model
+// is then named by the query string or a field sent before the file. The
+// ticket only becomes eligible for a run slot once the audio is decoded, so
+// a slow upload never keeps ready requests waiting. On failure it writes the
+// error response and returns a nil request. The caller must close the
+// request and release the ticket.
+func (s *Server) parseQueuedRequest(w http.ResponseWriter, r *http.Request) (*transcriptionRequest, *model, *ticket) {
+	var m *model
+	var t *ticket
+	admitted := ""
+	join := func(name string) bool {
+		var err error
+		if m, err = s.resolveModel(name); err != nil {
+			writeModelError(w, err)
+			return false
+		}
+		if t, err = m.queue.hold(s.QueueSize); err != nil {
+			writeError(w, http.StatusTooManyRequests, ErrCodeBusy, "server is busy and the transcription queue is full")
+			return false
+		}
+		admitted = name
+		return true
+	}
+	req := s.parseTranscriptionRequest(w, r, join)
+	if req == nil {
+		if t != nil {
+			t.release()
+		}
+		return nil, nil, nil
+	}
+	if req.model != admitted {
+		// The model field came after the file; queue again if it names
+		// another model.
+		if other, err := s.resolveModel(req.model); err != nil || other != m {
+			t.release()
+			if !join(req.model) {
+				req.close()
+				return nil, nil, nil
+			}
+		}
+	}
+	t.activate()
+	return req, m, t
+}
+
+// parseTranscriptionRequest reads the uploaded file and transcription
+// options from r. If admit is non-nil it is called with the requested
+// model once the file part is reached, before its audio is decoded; it
+// returns false after writing its own error response to stop there. On
+// failure it writes the error response and returns nil. The caller must
+// call close on the returned request.
+func (s *Server) parseTranscriptionRequest(w http.ResponseWriter, r *http.Request, admit func(model string) bool) *transcriptionRequest {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+
+	maxSeconds := s.MaxAudioInMemory
+	if maxSeconds <= 0 {
+		maxSeconds = defaultMaxAudioInMemory
+	}
+	var beforeFile func(model string) error
+	if admit != nil {
+		beforeFile = func(model string) error {
+			if !admit(model) {
+				return errNotAdmitted
+			}
+			return nil
+		}
+	}
+	upload, err := readMultipartAudio(r, maxSeconds*whisper.SampleRate, beforeFile)
+	if errors.Is(err, errNotAdmitted) {
+		return nil
+	}
+	if errors.Is(err, errInvalidForm) {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return nil
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidAudio, "invalid audio file: "+err.Error())
+		return nil
+	}
+	req := &transcriptionRequest{
+		samples:   upload.samples,
+		audioPath: upload.path,
+		audioLen:  upload.n,
+	}
+	if req.audioPath != "" {
+		req.tempFiles = append(req.tempFiles, req.audioPath)
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			req.close()
+		}
+	}()
+
+	// Diarization runs with a diarize_model (sona-diarize by default) or
+	// with diarizer=native, which needs no model.
+	if name, model := r.FormValue("diarizer"), r.FormValue("diarize_model"); name != "" || model != "" {
+		req.diarizeOpts = parseDiarizeOptions(r)
+		req.diarizeTimeout = s.DiarizeTimeout
+		if req.diarizeTimeout <= 0 {
+			req.diarizeTimeout = defaultDiarizeTimeout
+		}
+		req.diarizer, err = diarize.New(name, model)
+		if err == nil {
+			err = req.diarizeOpts.ValidateFor(req.diarizer)
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "invalid diarization options: "+err.Error())
+			return nil
+		}
+	}
+	if parseBoolFormValue(r.FormValue("identify_speakers")) {
+		if req.diarizer == nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "'identify_speakers' requires 'diarize_model' or 'diarizer'")
+			return nil
+		}
+		req.speakerThresh = diarize.DefaultThreshold
+		if v := r.FormValue("speaker_threshold"); v != "" {
+			thresh, err := strconv.ParseFloat(v, 64)
+			if err != nil || !(thresh > 0 && thresh <= 1) {
+				writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "'speaker_threshold' must be a number in (0, 1]")
+				return nil
+			}
+			req.speakerThresh = thresh
+		}
+		store, storeErr := s.speakerStore()
+		if storeErr != nil {
+			writeError(w, http.StatusServiceUnavailable, ErrCodeInternalError, storeErr.Error())
+			return nil
+		}
+		req.profiles = store.Profiles()
+	}
+
+	// Diarizers read a native 16kHz mono PCM WAV from disk; write the
+	// decoded audio out for them unless it was spooled there already.
+	// Enhancement only applies to whisper's input.
+	if req.diarizer != nil && req.audioPath != "" {
+		req.diarizePath = req.audioPath
+	} else if req.diarizer != nil {
+		nativeWav, tmpErr := os.CreateTemp("", "sona-diar-*.wav")
+		if tmpErr != nil {
+			writeError(w, http.StatusInternalServerError, ErrCodeInternalError, "failed to create temp file: "+tmpErr.Error())
+			return nil
+		}
+		req.tempFiles = append(req.tempFiles, nativeWav.Name())
+		writeErr := wav.Write(nativeWav, req.samples)
+		if closeErr := nativeWav.Close(); writeErr == nil {
+			writeErr = closeErr
+		}
+		if writeErr != nil {
+			writeError(w, http.StatusInternalServerError, ErrCodeInternalError, "failed to write audio for diarization: "+writeErr.Error())
+			return nil
+		}
+		req.diarizePath = nativeWav.Name()
+	}
+
+	if parseBoolFormValue(r.FormValue("enhance_audio")) && req.audioPath != "" {
+		log.Printf("enhance_audio ignored: %d s of audio was spooled to disk", req.audioLen/whisper.SampleRate)
+	} else if parseBoolFormValue(r.FormValue("enhance_audio")) {
+		req.samples, err = audio.Enhance(req.samples)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidAudio, "failed to enhance audio: "+err.Error())
+			return nil
+		}
+	}
+
+	req.opts, err = s.parseTranscribeOptions(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return nil
+	}
+	req.words = req.opts.WordTimestamps
+	if req.diarizer != nil {
+		req.opts.WordTimestamps = true // segments are split at speaker changes between words
+	}
+
+	req.responseFormat = r.FormValue("response_format")
+	if req.responseFormat == "" {
+		req.responseFormat = "json"
+	}
+	req.stream = parseBoolFormValue(r.FormValue("stream"))
+	req.model = r.FormValue("model")
+	req.releaseVAD = s.useCachedVAD(&req.opts)
+
+	ok = true
+	return req
+}
+
+// decodedUpload is an uploaded file decoded to 16kHz mono, either in
+// memory or, past the in-memory limit, in a native WAV temp file.
+type decodedUpload struct {
+	samples []float32
+	path    string
+	n       int // length in samples
+}
+
+// readMultipartAudio reads a multipart/form-data body part by part,
+// decoding the "file" part as it arrives instead of buffering the upload.
+// Other fields are stored in r.Form so FormValue works as usual; since
+// decoding does not depend on them, they may come before or after the file.
+// If beforeFile is non-nil it is called when the file part is reached with
+// the "model" field so far (or from the query string); an error stops
+// reading. Audio longer than maxSamples is spooled to disk.
+func readMultipartAudio(r *http.Request, maxSamples int, beforeFile func(model string) error) (decodedUpload, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return decodedUpload{}, fmt.Errorf("%w: %v", errInvalidForm, err)
+	}
+	if err := r.ParseForm(); err != nil { // query string only for multipart bodies
+		return decodedUpload{}, fmt.Errorf("%w: %v", errInvalidForm, err)
+	}
+
+	values := url.Values{}
+	var upload decodedUpload
+	haveFile := false
+	fail := func(err error) (decodedUpload, error) {
+		if upload.path != "" {
+			os.Remove(upload.path)
+		}
+		return decodedUpload{}, err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(fmt.Errorf("%w: %v", errInvalidForm, err))
+		}
+		name := part.FormName()
+		switch {
+		case name == "file" && !haveFile:
+			haveFile = true
+			if beforeFile != nil {
+				model := values.Get("model")
+				if model == "" {
+					model = r.Form.Get("model")
+				}
+				if err := beforeFile(model); err != nil {
+					return fail(err)
+				}
+			}
+			if upload, err = decodeUpload(part, maxSamples); err != nil {
+				return fail(err)
+			}
+		case part.FileName() != "":
+			// Ignore other uploads.
+		default:
+			value, err := io.ReadAll(io.LimitReader(part, maxFormFieldSize+1))
+			if err != nil {
+				return fail(fmt.Errorf("%w: %v", errInvalidForm, err))
+			}
+			if len(value) > maxFormFieldSize {
+				return fail(fmt.Errorf("%w: field %q is too large", errInvalidForm, name))
+			}
+			values.Add(name, string(value))
+		}
+		part.Close()
+	}
+	if !haveFile {
+		return decodedUpload{}, fmt.Errorf("%w: no file uploaded", errInvalidForm)
+	}
+
+	// Body values take precedence over the query string, as in ParseForm.
+	for k, vs := range values {
+		r.PostForm[k] = append(r.PostForm[k], vs...)
+		r.Form[k] = append(append([]string(nil), vs...), r.Form[k]...)
+	}
+	r.MultipartForm = &multipart.Form{Value: values}
+	return upload, nil
+}
+
+// decodeUpload decodes an uploaded file, keeping up to maxSamples in
+// memory. Longer audio is written to a native WAV temp file as it is
+// decoded, so memory stays bounded however long the recording is.
+func decodeUpload(r io.Reader, maxSamples int) (decodedUpload, error) {
+	stream, err := audio.NewStream(r)
+	if err != nil {
+		return decodedUpload{}, err
+	}
+	defer stream.Close()
+
+	var upload decodedUpload
+	var spool *os.File
+	var ww *wav.Writer
+	fail := func(err error) (decodedUpload, error) {
+		if spool != nil {
+			spool.Close()
+			os.Remove(spool.Name())
+		}
+		return decodedUpload{}, err
+	}
+	chunk := make([]float32, 1<<16)
+	for {
+		n, readErr := stream.ReadSamples(chunk)
+		if n > 0 && ww == nil && upload.n+n > maxSamples {
+			if spool, err = os.CreateTemp("", "sona-upload-*.wav"); err != nil {
+				return fail(fmt.Errorf("failed to create temp file: %w", err))
+			}
+			if ww, err = wav.NewWriter(spool); err == nil {
+				err = ww.Write(upload.samples)
+			}
+			if err != nil {
+				return fail(err)
+			}
+			upload.samples = nil
+		}
+		if n > 0 && ww != nil {
+			if err := ww.Write(chunk[:n]); err != nil {
+				return fail(err)
+			}
+		} else if n > 0 {
+			upload.samples = append(upload.samples, chunk[:n]...)
+		}
+		upload.n += n
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return fail(readErr)
+		}
+	}
+	if upload.n == 0 {
+		return fail(errors.New("audio file contains no samples"))
+	}
+	if spool != nil {
+		err := ww.Close()
+		if closeErr := spool.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fail(err)
+		}
+		upload.path = spool.Name()
+	}
+	return upload, nil
+}
+
+// parseTranscribeOptions reads whisper options from the form or query string.
+func (s *Server) parseTranscribeOptions(r *http.Request) (whisper.TranscribeOptions, error) {
+	samplingStrategy := r.FormValue("sampling_strategy")
+	stableTimestamps := parseBoolFormValue(r.FormValue("stable_timestamps"))
+	vadModelPath := r.FormValue("vad_model")
+	if stableTimestamps && vadModelPath == "" {
+		return whisper.TranscribeOptions{}, errors.New("'vad_model' is required when 'stable_timestamps' is true")
+	}
+	chunkOnSilence := parseBoolFormValue(r.FormValue("chunk_on_silence"))
+	if chunkOnSilence && vadModelPath == "" {
+		return whisper.TranscribeOptions{}, errors.New("'vad_model' is required when 'chunk_on_silence' is true")
+	}
+	vad := parseBoolFormValue(r.FormValue("vad"))
+	if vad && vadModelPath == "" {
+		return whisper.TranscribeOptions{}, errors.New("'vad_model' is required when 'vad' is true")
+	}
+
+	// Every stable worker holds a whisper state with its own KV cache.
+	stableWorkers := 0
+	if v := r.FormValue("stable_workers"); v != "" {
+		stableWorkers = parseIntFormValue(v)
+		if limit := whisper.MaxStableWorkers(); stableWorkers <= 0 || stableWorkers > limit {
+			return whisper.TranscribeOptions{}, fmt.Errorf("'stable_workers' must be between 1 and %d", limit)
+		}
+	}
+
+	opts := whisper.TranscribeOptions{
+		Language:         r.FormValue("language"),
+		DetectLanguage:   parseBoolFormValue(r.FormValue("detect_language")),
+		Translate:        parseBoolFormValue(r.FormValue("translate")),
+		Threads:          parseIntFormValue(r.FormValue("n_threads")),
+		Prompt:           r.FormValue("prompt"),
+		Verbose:          s.verbose,
+		Temperature:      parseFloatFormValue(r.FormValue("temperature")),
+		MaxTextCtx:       parseIntFormValue(r.FormValue("max_text_ctx")),
+		WordTimestamps:   parseBoolFormValue(r.FormValue("word_timestamps")) || wantWordGranularity(r),
+		MaxSegmentLen:    parseIntFormValue(r.FormValue("max_segment_len")),
+		SamplingGreedy:   samplingStrategy != "beam_search",
+		BestOf:           parseIntFormValue(r.FormValue("best_of")),
+		BeamSize:         parseIntFormValue(r.FormValue("beam_size")),
+		StableTimestamps: stableTimestamps,
+		StableWorkers:    stableWorkers,
+		CarryPrompt:      parseBoolFormValue(r.FormValue("carry_prompt")),
+		Vad:              vad,
+		VadModelPath:     vadModelPath,
+		VadParams:        parseVadParams(r),
+		ChunkSeconds:     parseIntFormValue(r.FormValue("chunk_length")),
+		ChunkOverlap:     parseIntFormValue(r.FormValue("chunk_overlap")),
+		ChunkOnSilence:   chunkOnSilence,
+	}
+	return opts, nil
+}
+
+// parseVadParams reads VAD tuning from the form or query string.
+func parseVadParams(r *http.Request) whisper.VadParams {
+	return whisper.VadParams{
+		Threshold:        parseFloatFormValue(r.FormValue("vad_threshold")),
+		MinSpeechMs:      parseIntFormValue(r.FormValue("vad_min_speech_duration_ms")),
+		MinSilenceMs:     parseIntFormValue(r.FormValue("vad_min_silence_duration_ms")),
+		MaxSpeechSeconds: parseFloatFormValue(r.FormValue("vad_max_speech_duration_s")),
+		SpeechPadMs:      parseIntFormValue(r.FormValue("vad_speech_pad_ms")),
+	}
+}
+
+// parseDiarizeOptions reads speaker count hints from the form or query
+// string.
+func parseDiarizeOptions(r *http.Request) diarize.Options {
+	return diarize.Options{
+		NumSpeakers:        parseIntFormValue(r.FormValue("num_speakers")),
+		MinSpeakers:        parseIntFormValue(r.FormValue("min_speakers")),
+		MaxSpeakers:        parseIntFormValue(r.FormValue("max_speakers")),
+		MinSegmentDuration: float64(parseFloatFormValue(r.FormValue("min_segment_duration"))),
+	}
+}
+
+// wantWordGranularity reports whether the OpenAI timestamp_granularities
+// field asks for word timestamps. r's form must already be parsed.
+func wantWordGranularity(r *http.Request) bool {
+	for _, key := range []string{"timestamp_granularities[]", "timestamp_granularities"} {
+		for _, v := range r.Form[key] {
+			for _, g := range strings.Split(v, ",") {
+				if strings.TrimSpace(g) == "word" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// writeTranscriptionResult writes result in the given response_format;
+// unknown formats are written as json.
+func writeTranscriptionResult(w http.ResponseWriter, responseFormat string, result whisper.TranscribeResult, opts transcript.Options) {
+	if !slices.Contains(transcript.Formats, responseFormat) {
+		responseFormat = "json"
+	}
+	body, err := transcript.Render(responseFormat, result, opts)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternalError, "failed to format transcription: "+err.Error())
+		return
+	}
+	if strings.HasSuffix(responseFormat, "json") {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", "text/plain")
+	}
+	w.Write(body)
+}
@@ -0,0 +1,304 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thewh1teagle/sona/internal/diarize"
+	"github.com/thewh1teagle/sona/internal/transcript"
+	"github.com/thewh1teagle/sona/internal/wav"
+	"github.com/thewh1teagle/sona/internal/whisper"
+)
+
+func TestParseTranscriptionRequestFieldsAfterFile(t *testing.T) {
+	var audioBuf bytes.Buffer
+	wav.Write(&audioBuf, make([]float32, 1600))
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("response_format", "srt")
+	fw, _ := mw.CreateFormFile("file", "audio.wav")
+	fw.Write(audioBuf.Bytes())
+	mw.WriteField("language", "he")
+	mw.Close()
+
+	r := httptest.NewRequest("POST", "/v1/audio/transcriptions?language=en&stream=true", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+
+	req := New(false).parseTranscriptionRequest(w, r, nil)
+	if req == nil {
+		t.Fatalf("parse failed: %d %s", w.Code, w.Body.String())
+	}
+	defer req.close()
+	if len(req.samples) != 1600 {
+		t.Errorf("got %d samples, want 1600", len(req.samples))
+	}
+	if req.responseFormat != "srt" || !req.stream {
+		t.Errorf("responseFormat = %q, stream = %v; want srt, true", req.responseFormat, req.stream)
+	}
+	if req.opts.Language != "he" {
+		t.Errorf("language = %q, want the body value he over the query", req.opts.Language)
+	}
+}
+
+func TestParseTranscriptionRequestDiarizeWords(t *testing.T) {
+	var audioBuf bytes.Buffer
+	wav.Write(&audioBuf, make([]float32, 1600))
+	for _, tt := range []struct {
+		query string
+		words bool
+	}{
+		{"?diarizer=native", false},
+		{"?diarizer=native&word_timestamps=true", true},
+		{"?diarizer=native&timestamp_granularities[]=word", true},
+	} {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, _ := mw.CreateFormFile("file", "audio.wav")
+		fw.Write(audioBuf.Bytes())
+		mw.Close()
+		r := httptest.NewRequest("POST", "/v1/audio/transcriptions"+tt.query, &body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		w := httptest.NewRecorder()
+		req := New(false).parseTranscriptionRequest(w, r, nil)
+		if req == nil {
+			t.Fatalf("%s: parse failed: %d %s", tt.query, w.Code, w.Body.String())
+		}
+		req.close()
+		// Words are always timed to split speakers, but only returned when asked for.
+		if !req.opts.WordTimestamps || req.words != tt.words {
+			t.Errorf("%s: WordTimestamps = %v, words = %v; want true, %v", tt.query, req.opts.WordTimestamps, req.words, tt.words)
+		}
+	}
+}
+
+func TestParseTranscriptionRequestDiarizerLimits(t *testing.T) {
+	var audioBuf bytes.Buffer
+	wav.Write(&audioBuf, make([]float32, 1600))
+	for _, query := range []string{
+		"?diarize_model=m.onnx&min_speakers=2",
+		"?diarize_model=m.onnx&num_speakers=5",
+		"?diarize_model=m.onnx&identify_speakers=true&speaker_threshold=0",
+		"?diarize_model=m.onnx&identify_speakers=true&speaker_threshold=1.5",
+		"?diarize_model=m.onnx&identify_speakers=true&speaker_threshold=NaN",
+	} {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, _ := mw.CreateFormFile("file", "audio.wav")
+		fw.Write(audioBuf.Bytes())
+		mw.Close()
+		r := httptest.NewRequest("POST", "/v1/audio/transcriptions"+query, &body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		w := httptest.NewRecorder()
+		if req := New(false).parseTranscriptionRequest(w, r, nil); req != nil || w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want 400", query, w.Code)
+		}
+	}
+}
+
+func TestParseTranscriptionRequestMissingFile(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("model", "tiny")
+	mw.Close()
+
+	r := httptest.NewRequest("POST", "/v1/audio/transcriptions", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	if req := New(false).parseTranscriptionRequest(w, r, nil); req != nil || w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without a file, got %d", w.Code)
+	}
+}
+
+func TestParseQueuedRequestQueuesBeforeDecoding(t *testing.T) {
+	s := New(false)
+	withModels(s, "tiny", "large-v3")
+	tiny, _ := s.resolveModel("tiny")
+	busy, _ := tiny.queue.enqueue(0)
+
+	var wavBuf bytes.Buffer
+	wav.Write(&wavBuf, make([]float32, 1600))
+	parse := func(query string, before, after map[string]string, audio []byte) (*transcriptionRequest, *model, *ticket, *httptest.ResponseRecorder) {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		for k, v := range before {
+			mw.WriteField(k, v)
+		}
+		fw, _ := mw.CreateFormFile("file", "audio.wav")
+		fw.Write(audio)
+		for k, v := range after {
+			mw.WriteField(k, v)
+		}
+		mw.Close()
+		r := httptest.NewRequest("POST", "/v1/audio/transcriptions"+query, &body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		w := httptest.NewRecorder()
+		req, m, tk := s.parseQueuedRequest(w, r)
+		return req, m, tk, w
+	}
+
+	// A full queue refuses the upload before its (invalid) audio is read.
+	garbage := []byte("not audio")
+	for _, tt := range []struct {
+		query  string
+		before map[string]string
+	}{
+		{"", map[string]string{"model": "tiny"}},
+		{"?model=tiny", nil},
+	} {
+		if req, _, _, w := parse(tt.query, tt.before, nil, garbage); req != nil || w.Code != http.StatusTooManyRequests {
+			t.Errorf("query %q, fields %v: got %d, want 429 before decoding", tt.query, tt.before, w.Code)
+		}
+	}
+
+	// Another model's queue is joined, and left again on a bad upload.
+	large, _ := s.resolveModel("large-v3")
+	if req, _, _, w := parse("?model=large-v3", nil, nil, garbage); req != nil || w.Code != http.StatusBadRequest {
+		t.Errorf("invalid audio: got %d, want 400", w.Code)
+	}
+	if tk, err := large.queue.enqueue(0); err != nil {
+		t.Error("ticket of a failed upload was not released")
+	} else {
+		tk.release()
+	}
+
+	// A model named after the file is only known once the upload is read:
+	// the request queues for the default model, then moves.
+	busy.release()
+	req, m, tk, w := parse("", nil, map[string]string{"model": "large-v3"}, wavBuf.Bytes())
+	if req == nil {
+		t.Fatalf("parse failed: %d %s", w.Code, w.Body.String())
+	}
+	defer req.close()
+	defer tk.release()
+	if m != large || tk.position() != 0 {
+		t.Errorf("got model %s at position %d, want large-v3 running", m.name, tk.position())
+	}
+	if tiny.queue.running != 0 {
+		t.Error("the default model's ticket was not released")
+	}
+}
+
+func TestStableWorkersBounded(t *testing.T) {
+	s := New(false)
+	limit := whisper.MaxStableWorkers()
+	for _, tt := range []struct {
+		value string
+		ok    bool
+	}{
+		{"1", true},
+		{strconv.Itoa(limit), true},
+		{strconv.Itoa(limit + 1), false},
+		{"1000", false},
+		{"0", false},
+		{"-2", false},
+		{"many", false},
+	} {
+		r := httptest.NewRequest("POST", "/?stable_workers="+tt.value, nil)
+		opts, err := s.parseTranscribeOptions(r)
+		if (err == nil) != tt.ok {
+			t.Errorf("stable_workers=%s: err = %v, want ok %v", tt.value, err, tt.ok)
+		}
+		if err == nil && opts.StableWorkers != parseIntFormValue(tt.value) {
+			t.Errorf("stable_workers=%s: got %d", tt.value, opts.StableWorkers)
+		}
+	}
+}
+
+func TestParseTranscriptionRequestSpoolsLongAudio(t *testing.T) {
+	var audioBuf bytes.Buffer
+	wav.Write(&audioBuf, make([]float32, 3*whisper.SampleRate))
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "audio.wav")
+	fw.Write(audioBuf.Bytes())
+	mw.Close()
+
+	r := httptest.NewRequest("POST", "/v1/audio/transcriptions", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+
+	s := New(false)
+	s.MaxAudioInMemory = 1
+	req := s.parseTranscriptionRequest(w, r, nil)
+	if req == nil {
+		t.Fatalf("parse failed: %d %s", w.Code, w.Body.String())
+	}
+	if req.samples != nil || req.audioPath == "" || req.audioLen != 3*whisper.SampleRate {
+		t.Fatalf("samples = %d, audioPath = %q, audioLen = %d; want audio spooled to disk", len(req.samples), req.audioPath, req.audioLen)
+	}
+	head, err := req.head(100)
+	if err != nil || len(head) != 100 {
+		t.Errorf("head = %d samples, %v; want 100", len(head), err)
+	}
+	req.close()
+	if _, err := os.Stat(req.audioPath); !os.IsNotExist(err) {
+		t.Errorf("spooled audio not removed: %v", err)
+	}
+}
+
+// blockingDiarizer waits for its context to end.
+type blockingDiarizer struct{}
+
+func (blockingDiarizer) Diarize(ctx context.Context, audioPath string, opts diarize.Options) ([]diarize.Segment, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestDiarizeTimeoutWarns(t *testing.T) {
+	req := &transcriptionRequest{diarizer: blockingDiarizer{}, diarizePath: "audio.wav", diarizeTimeout: 10 * time.Millisecond}
+	segments, warnings := req.diarize(context.Background())
+	if segments != nil || len(warnings) != 1 || !strings.Contains(warnings[0], "timed out after 10ms") {
+		t.Errorf("diarize = %v, %q; want a timeout warning", segments, warnings)
+	}
+
+	w := httptest.NewRecorder()
+	writeTranscriptionResult(w, "verbose_json", whisper.TranscribeResult{}, transcript.Options{Speakers: segments, Warnings: warnings})
+	var v struct{ Warnings []string }
+	if err := json.NewDecoder(w.Body).Decode(&v); err != nil || len(v.Warnings) != 1 {
+		t.Errorf("verbose_json warnings = %q, %v", v.Warnings, err)
+	}
+}
+
+func TestWriteTranscriptionResultRenderError(t *testing.T) {
+	result := whisper.TranscribeResult{Segments: []whisper.Segment{{Text: " Hi", AvgLogprob: float32(math.NaN())}}}
+	w := httptest.NewRecorder()
+	writeTranscriptionResult(w, "verbose_json", result, transcript.Options{})
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "failed to format transcription") {
+		t.Errorf("got %d %s, want 500 with the render error", w.Code, w.Body.String())
+	}
+}
+
+// stuckDiarizer ignores its context until release is closed.
+type stuckDiarizer struct{ release chan struct{} }
+
+func (d stuckDiarizer) Diarize(ctx context.Context, audioPath string, opts diarize.Options) ([]diarize.Segment, error) {
+	<-d.release
+	return nil, nil
+}
+
+func TestDiarizeTimeoutIgnoredContext(t *testing.T) {
+	d := stuckDiarizer{make(chan struct{})}
+	defer close(d.release)
+	req := &transcriptionRequest{diarizer: d, diarizePath: "audio.wav", diarizeTimeout: 10 * time.Millisecond}
+	start := time.Now()
+	segments, warnings := req.diarize(context.Background())
+	if segments != nil || len(warnings) != 1 || !strings.Contains(warnings[0], "timed out") {
+		t.Errorf("diarize = %v, %q; want a timeout warning", segments, warnings)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("diarize returned after %s, want soon after the timeout", elapsed)
+	}
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/thewh1teagle/sona/internal/whisper"
+)
+
+// loadedVAD is a VAD model loaded with POST /v1/vad/load. It is closed once
+// it is unloaded and the last request using it has finished.
+type loadedVAD struct {
+	ctx      *whisper.VadContext
+	users    int
+	unloaded bool
+}
+
+// acquireVAD returns the loaded VAD model for path and a func to call when
+// done with it, or nil if path is not loaded. Unloading it meanwhile does
+// not close it under the caller.
+func (s *Server) acquireVAD(path string) (*whisper.VadContext, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v := s.vads[path]
+	if v == nil {
+		return nil, func() {}
+	}
+	v.users++
+	var once sync.Once
+	return v.ctx, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			v.users--
+			closeNow := v.unloaded && v.users == 0
+			s.mu.Unlock()
+			if closeNow {
+				v.ctx.Close()
+			}
+		})
+	}
+}
+
+// LoadVAD loads the VAD model at path into the cache, returning the
+// cached one if it is already loaded.
+func (s *Server) LoadVAD(path string) (*whisper.VadContext, error) {
+	s.mu.Lock()
+	if v := s.vads[path]; v != nil {
+		s.mu.Unlock()
+		return v.ctx, nil
+	}
+	s.mu.Unlock()
+
+	// Load outside the lock; if another request loaded the same path
+	// meanwhile, keep theirs.
+	ctx, err := whisper.NewVadContext(path, 0)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing := s.vads[path]; existing != nil {
+		ctx.Close()
+		return existing.ctx, nil
+	}
+	if s.vads == nil {
+		s.vads = map[string]*loadedVAD{}
+	}
+	s.vads[path] = &loadedVAD{ctx: ctx}
+	return ctx, nil
+}
+
+// UnloadVAD removes the VAD model loaded from path from the cache and
+// reports whether it was loaded. Requests already using it keep it until
+// they finish; it is freed after the last one.
+func (s *Server) UnloadVAD(path string) bool {
+	s.mu.Lock()
+	v := s.vads[path]
+	delete(s.vads, path)
+	s.mu.Unlock()
+
+	if v == nil {
+		return false
+	}
+	s.retireVAD(v)
+	return true
+}
+
+// UnloadAllVAD removes every cached VAD model, as UnloadVAD.
+func (s *Server) UnloadAllVAD() {
+	s.mu.Lock()
+	vads := s.vads
+	s.vads = nil
+	s.mu.Unlock()
+
+	for _, v := range vads {
+		s.retireVAD(v)
+	}
+}
+
+// retireVAD closes an unloaded model now, or marks it to be closed by its
+// last user.
+func (s *Server) retireVAD(v *loadedVAD) {
+	s.mu.Lock()
+	v.unloaded = true
+	closeNow := v.users == 0
+	s.mu.Unlock()
+	if closeNow {
+		v.ctx.Close()
+	}
+}
+
+// loadedVADs returns the paths of the cached VAD models, sorted.
+func (s *Server) loadedVADs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	paths := make([]string, 0, len(s.vads))
+	for path := range s.vads {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// useCachedVAD points opts at the loaded context for its VAD model, if it
+// was loaded with POST /v1/vad/load, so stable timestamps and chunking on
+// silence do not load it per request. Other paths are loaded for the one
+// request and freed after it, so clients cannot fill the cache. The
+// returned func releases the model and must be called when the request is
+// done. whisper.cpp's own VAD pass (opts.Vad) still loads it by path.
+func (s *Server) useCachedVAD(opts *whisper.TranscribeOptions) func() {
+	if opts.VadModelPath == "" || !(opts.StableTimestamps || opts.ChunkOnSilence) {
+		return func() {}
+	}
+	v, release := s.acquireVAD(opts.VadModelPath)
+	opts.VadContext = v
+	return release
+}
+
+// handleVAD returns the speech regions of an upload, found with the VAD
+// model named by vad_model. It needs no whisper model and does not queue.
+func (s *Server) handleVAD(w http.ResponseWriter, r *http.Request) {
+	req := s.parseTranscriptionRequest(w, r, nil)
+	if req == nil {
+		return
+	}
+	defer req.close()
+
+	if req.opts.VadModelPath == "" {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "'vad_model' is required")
+		return
+	}
+	src, closeSrc, err := req.reader()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternalError, err.Error())
+		return
+	}
+	defer closeSrc()
+
+	// A model that was not loaded with POST /v1/vad/load is loaded for
+	// this request only.
+	var speech []whisper.SpeechSegment
+	var duration int64
+	v, release := s.acquireVAD(req.opts.VadModelPath)
+	defer release()
+	if v != nil {
+		speech, duration, err = v.DetectSpeech(src, req.opts.VadParams)
+	} else {
+		speech, duration, err = whisper.DetectSpeech(req.opts.VadModelPath, src, req.opts.VadParams, 0)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternalError, "voice activity detection failed: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildVADResponse(speech, duration))
+}
+
+// handleVADLoad loads a VAD model from a path in the JSON body into the
+// cache used by vad_model.
+func (s *Server) handleVADLoad(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Path string `json:"path"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Path == "" {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "request body must contain {\"path\": \"...\"}")
+		return
+	}
+	if _, err := s.LoadVAD(body.Path); err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternalError, "failed to load VAD model: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "loaded",
+		"path":   body.Path,
+	})
+}
+
+// handleVADUnload frees the VAD model named by the path query parameter,
+// or every cached VAD model without one (idempotent).
+func (s *Server) handleVADUnload(w http.ResponseWriter, r *http.Request) {
+	if path := r.URL.Query().Get("path"); path != "" {
+		if !s.UnloadVAD(path) {
+			writeError(w, http.StatusNotFound, ErrCodeModelNotFound, "VAD model not loaded: "+path)
+			return
+		}
+	} else {
+		s.UnloadAllVAD()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "unloaded"})
+}
+
+// handleVADList lists the cached VAD models.
+func (s *Server) handleVADList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"paths": s.loadedVADs()})
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/thewh1teagle/sona/internal/whisper"
+)
+
+func TestVADUnloadEndpoints(t *testing.T) {
+	h := New(false).Handler()
+	tests := []struct {
+		method, path, body string
+		want               int
+	}{
+		{"DELETE", "/v1/vad?path=/models/silero.bin", "", http.StatusNotFound},
+		{"POST", "/v1/vad/unload", `{"path": "/models/silero.bin"}`, http.StatusNotFound},
+		{"POST", "/v1/vad/load", `{}`, http.StatusBadRequest},
+		{"DELETE", "/v1/vad", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+		if w.Code != tt.want {
+			t.Errorf("%s %s %s: got %d, want %d", tt.method, tt.path, tt.body, w.Code, tt.want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/v1/vad", nil))
+	var list struct{ Paths []string }
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil || list.Paths == nil || len(list.Paths) != 0 {
+		t.Errorf("GET /v1/vad = %v, %v; want an empty list", list.Paths, err)
+	}
+}
+
+func TestUseCachedVADOnlyWhenNeeded(t *testing.T) {
+	// whisper.cpp's own VAD pass loads the model by path, so nothing is
+	// taken from the cache for it.
+	s := New(false)
+	opts := whisper.TranscribeOptions{Vad: true, VadModelPath: "/models/silero.bin"}
+	s.useCachedVAD(&opts)()
+	if opts.VadContext != nil {
+		t.Errorf("context %v for whisper.cpp's VAD pass; want none", opts.VadContext)
+	}
+
+	// A path that was never loaded is left to load per request, and is
+	// not cached.
+	opts = whisper.TranscribeOptions{StableTimestamps: true, VadModelPath: "/models/silero.bin"}
+	s.useCachedVAD(&opts)()
+	if opts.VadContext != nil || len(s.loadedVADs()) != 0 {
+		t.Errorf("context %v, cache %v for an unloaded path; want neither", opts.VadContext, s.loadedVADs())
+	}
+}
+
+func TestUnloadVADWaitsForUsers(t *testing.T) {
+	s := New(false)
+	v := &loadedVAD{ctx: &whisper.VadContext{}}
+	s.vads = map[string]*loadedVAD{"/models/silero.bin": v}
+
+	opts := whisper.TranscribeOptions{ChunkOnSilence: true, VadModelPath: "/models/silero.bin"}
+	release := s.useCachedVAD(&opts)
+	if opts.VadContext != v.ctx {
+		t.Fatal("loaded model was not used")
+	}
+	if !s.UnloadVAD("/models/silero.bin") {
+		t.Fatal("UnloadVAD reported the model as not loaded")
+	}
+	if !v.unloaded || v.users != 1 {
+		t.Errorf("after unload: unloaded %v, users %d; want kept for its user", v.unloaded, v.users)
+	}
+	release()
+	release()
+	if v.users != 0 {
+		t.Errorf("users = %d after release, want 0", v.users)
+	}
+}
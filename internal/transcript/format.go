@@ -0,0 +1,338 @@
+// Package transcript renders transcription results in the output formats
+// shared by the server and the CLI.
+package transcript
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/thewh1teagle/sona/internal/diarize"
+	"github.com/thewh1teagle/sona/internal/whisper"
+)
+
+// Formats are the output formats Render accepts.
+var Formats = []string{"text", "json", "verbose_json", "srt", "vtt", "tsv", "lrc"}
+
+// Extensions are the file extensions of each format.
+var Extensions = map[string]string{
+	"text":         ".txt",
+	"json":         ".json",
+	"verbose_json": ".verbose.json",
+	"srt":          ".srt",
+	"vtt":          ".vtt",
+	"tsv":          ".tsv",
+	"lrc":          ".lrc",
+}
+
+// TrimExtension removes the longest of Extensions that path ends with, so
+// "out.verbose.json" becomes "out" rather than "out.verbose".
+func TrimExtension(path string) string {
+	best := ""
+	for _, format := range Formats {
+		if ext := Extensions[format]; strings.HasSuffix(path, ext) && len(ext) > len(best) {
+			best = ext
+		}
+	}
+	return strings.TrimSuffix(path, best)
+}
+
+// Options are the optional inputs of Render.
+type Options struct {
+	// Speakers is the diarization, if any: segments are split where the
+	// speaker changes and labeled.
+	Speakers []diarize.Segment
+	// Warnings are only reported by verbose_json, e.g. a failed diarization.
+	Warnings []string
+	// OmitWords leaves word timings out of verbose_json, for words that
+	// were only timed to split segments between speakers.
+	OmitWords bool
+}
+
+// Render formats result in one of Formats.
+func Render(format string, result whisper.TranscribeResult, opts Options) ([]byte, error) {
+	var speakers []Speaker
+	if opts.Speakers != nil {
+		result.Segments, speakers = AttributeSpeakers(result.Segments, opts.Speakers)
+	}
+	if opts.OmitWords {
+		segments := make([]whisper.Segment, len(result.Segments))
+		for i, seg := range result.Segments {
+			seg.Words = nil
+			segments[i] = seg
+		}
+		result.Segments = segments
+	}
+	switch format {
+	case "text":
+		return []byte(Text(result.Segments, speakers)), nil
+	case "json":
+		return marshalLine(map[string]string{"text": result.Text()})
+	case "verbose_json":
+		v := BuildVerboseJSON(result, speakers)
+		v.Warnings = opts.Warnings
+		return marshalLine(v)
+	case "srt":
+		return []byte(SRT(result.Segments, speakers)), nil
+	case "vtt":
+		return []byte(VTT(result.Segments, speakers)), nil
+	case "tsv":
+		return []byte(TSV(result.Segments, speakers)), nil
+	case "lrc":
+		return []byte(LRC(result.Segments, speakers)), nil
+	}
+	return nil, fmt.Errorf("unknown format %q (want one of %s)", format, strings.Join(Formats, ", "))
+}
+
+// marshalLine encodes v as json.Encoder does: compact, HTML-escaped and
+// newline-terminated.
+func marshalLine(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Seconds converts whisper centiseconds (10ms units) to seconds.
+func Seconds(cs int64) float64 {
+	return float64(cs) / 100.0
+}
+
+// csToSRTTime converts centiseconds to SRT timestamp format HH:MM:SS,mmm.
+func csToSRTTime(cs int64) string {
+	ms := cs * 10
+	s := ms / 1000
+	ms = ms % 1000
+	m := s / 60
+	s = s % 60
+	h := m / 60
+	m = m % 60
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", h, m, s, ms)
+}
+
+// csToVTTTime converts centiseconds to WebVTT timestamp format HH:MM:SS.mmm.
+func csToVTTTime(cs int64) string {
+	ms := cs * 10
+	s := ms / 1000
+	ms = ms % 1000
+	m := s / 60
+	s = s % 60
+	h := m / 60
+	m = m % 60
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", h, m, s, ms)
+}
+
+// csToLRCTime converts centiseconds to LRC timestamp format MM:SS.xx;
+// minutes go past 59 rather than adding an hours field.
+func csToLRCTime(cs int64) string {
+	return fmt.Sprintf("%02d:%02d.%02d", cs/6000, cs/100%60, cs%100)
+}
+
+// SRT formats segments as SubRip (.srt) subtitles. If speakers is
+// non-nil, cues of a known speaker are prefixed with "Speaker N: ".
+func SRT(segments []whisper.Segment, speakers []Speaker) string {
+	var sb strings.Builder
+	for i, seg := range segments {
+		if i > 0 {
+			sb.WriteByte('\n')
+		}
+		fmt.Fprintf(&sb, "%d\n%s --> %s\n%s\n",
+			i+1,
+			csToSRTTime(seg.Start),
+			csToSRTTime(seg.End),
+			labeled(seg, speakers, i),
+		)
+	}
+	return sb.String()
+}
+
+// VTT formats segments as WebVTT (.vtt) subtitles. If speakers is
+// non-nil, cues of a known speaker carry a <v Speaker N> voice tag.
+func VTT(segments []whisper.Segment, speakers []Speaker) string {
+	var sb strings.Builder
+	sb.WriteString("WEBVTT\n\n")
+	for i, seg := range segments {
+		if i > 0 {
+			sb.WriteByte('\n')
+		}
+		text := strings.TrimSpace(seg.Text)
+		if speakers != nil && speakers[i].ID >= 0 {
+			text = "<v " + speakers[i].Label() + ">" + text
+		}
+		fmt.Fprintf(&sb, "%s --> %s\n%s\n",
+			csToVTTTime(seg.Start),
+			csToVTTTime(seg.End),
+			text,
+		)
+	}
+	return sb.String()
+}
+
+// TSV formats segments as tab-separated values with a header row, times in
+// milliseconds as whisper.cpp writes them. If speakers is non-nil, a
+// speaker column holds each segment's label, empty when unknown.
+func TSV(segments []whisper.Segment, speakers []Speaker) string {
+	var sb strings.Builder
+	if speakers != nil {
+		sb.WriteString("start\tend\tspeaker\ttext\n")
+	} else {
+		sb.WriteString("start\tend\ttext\n")
+	}
+	for i, seg := range segments {
+		fmt.Fprintf(&sb, "%d\t%d\t", seg.Start*10, seg.End*10)
+		if speakers != nil {
+			if speakers[i].ID >= 0 {
+				sb.WriteString(speakers[i].Label())
+			}
+			sb.WriteByte('\t')
+		}
+		// Tabs and newlines would break the row.
+		sb.WriteString(strings.Join(strings.Fields(seg.Text), " "))
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// LRC formats segments as LRC lyrics, one [MM:SS.xx] line per segment. If
+// speakers is non-nil, lines of a known speaker are prefixed with
+// "Speaker N: ".
+func LRC(segments []whisper.Segment, speakers []Speaker) string {
+	var sb strings.Builder
+	for i, seg := range segments {
+		fmt.Fprintf(&sb, "[%s]%s\n", csToLRCTime(seg.Start), labeled(seg, speakers, i))
+	}
+	return sb.String()
+}
+
+// labeled returns the trimmed text of segments[i], prefixed with its
+// speaker's label when known.
+func labeled(seg whisper.Segment, speakers []Speaker, i int) string {
+	text := strings.TrimSpace(seg.Text)
+	if speakers != nil && speakers[i].ID >= 0 {
+		text = speakers[i].Label() + ": " + text
+	}
+	return text
+}
+
+// Text formats segments as plain text. If speakers is non-nil,
+// consecutive segments of one speaker form a turn, written on its own
+// line as "Speaker N: text"; segments of no known speaker get no label.
+func Text(segments []whisper.Segment, speakers []Speaker) string {
+	if speakers == nil {
+		return whisper.TranscribeResult{Segments: segments}.Text()
+	}
+	var sb strings.Builder
+	turn := -1 // speaker of the current turn
+	for i, seg := range segments {
+		text := strings.TrimSpace(seg.Text)
+		if text == "" {
+			continue
+		}
+		if sb.Len() > 0 && speakers[i].ID == turn {
+			sb.WriteByte(' ')
+			sb.WriteString(text)
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte('\n')
+		}
+		if turn = speakers[i].ID; turn >= 0 {
+			sb.WriteString(speakers[i].Label() + ": ")
+		}
+		sb.WriteString(text)
+	}
+	if sb.Len() > 0 {
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// VerboseSegment is the JSON representation of a segment in verbose_json format.
+type VerboseSegment struct {
+	ID               int     `json:"id"`
+	Start            float64 `json:"start"`
+	End              float64 `json:"end"`
+	Text             string  `json:"text"`
+	Tokens           []int   `json:"tokens"`
+	Temperature      float32 `json:"temperature"`
+	AvgLogprob       float32 `json:"avg_logprob"`
+	CompressionRatio float32 `json:"compression_ratio"`
+	NoSpeechProb     float32 `json:"no_speech_prob"`
+	Speaker          any     `json:"speaker,omitempty"` // id, or enrolled name
+	SpeakerSim       float64 `json:"speaker_similarity,omitempty"`
+}
+
+// VerboseWord is the JSON representation of a word in verbose_json format,
+// matching OpenAI's timestamp_granularities[]=word output.
+type VerboseWord struct {
+	Word        string  `json:"word"`
+	Start       float64 `json:"start"`
+	End         float64 `json:"end"`
+	Probability float32 `json:"probability"`
+}
+
+// VerboseJSON is the verbose_json document.
+type VerboseJSON struct {
+	Language string           `json:"language,omitempty"`
+	Duration float64          `json:"duration"`
+	Text     string           `json:"text"`
+	Segments []VerboseSegment `json:"segments"`
+	Words    []VerboseWord    `json:"words,omitempty"`
+	Warnings []string         `json:"warnings,omitempty"` // e.g. a failed diarization
+}
+
+// VerboseWords converts word timings to their JSON representation.
+func VerboseWords(words []whisper.Word) []VerboseWord {
+	vWords := make([]VerboseWord, len(words))
+	for i, w := range words {
+		vWords[i] = VerboseWord{
+			Word:        w.Text,
+			Start:       Seconds(w.Start),
+			End:         Seconds(w.End),
+			Probability: w.Probability,
+		}
+	}
+	return vWords
+}
+
+// BuildVerboseJSON creates the verbose_json document. Like OpenAI, the
+// language is reported by its full name (e.g. "english"). If speakers is
+// non-nil, it holds each segment's speaker from AttributeSpeakers.
+func BuildVerboseJSON(result whisper.TranscribeResult, speakers []Speaker) VerboseJSON {
+	segments := result.Segments
+	vSegs := make([]VerboseSegment, len(segments))
+	var vWords []VerboseWord
+	for i, seg := range segments {
+		vWords = append(vWords, VerboseWords(seg.Words)...)
+		vSegs[i] = VerboseSegment{
+			ID:               i,
+			Start:            Seconds(seg.Start),
+			End:              Seconds(seg.End),
+			Text:             seg.Text,
+			Tokens:           seg.Tokens,
+			Temperature:      seg.Temperature,
+			AvgLogprob:       seg.AvgLogprob,
+			CompressionRatio: seg.CompressionRatio,
+			NoSpeechProb:     seg.NoSpeechProb,
+		}
+		if vSegs[i].Tokens == nil {
+			vSegs[i].Tokens = []int{}
+		}
+		if speakers != nil && speakers[i].ID >= 0 {
+			vSegs[i].Speaker = speakers[i].Value()
+			vSegs[i].SpeakerSim = speakers[i].Similarity
+		}
+	}
+	v := VerboseJSON{
+		Duration: Seconds(result.Duration),
+		Text:     result.Text(),
+		Segments: vSegs,
+		Words:    vWords,
+	}
+	if result.Language != "" {
+		v.Language = whisper.LanguageName(result.Language)
+	}
+	return v
+}
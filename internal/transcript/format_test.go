@@ -0,0 +1,291 @@
+package transcript
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/thewh1teagle/sona/internal/diarize"
+	"github.com/thewh1teagle/sona/internal/whisper"
+)
+
+func TestSeconds(t *testing.T) {
+	tests := []struct {
+		cs   int64
+		want float64
+	}{
+		{0, 0.0},
+		{100, 1.0},
+		{250, 2.5},
+		{6000, 60.0},
+	}
+	for _, tt := range tests {
+		got := Seconds(tt.cs)
+		if got != tt.want {
+			t.Errorf("Seconds(%d) = %f, want %f", tt.cs, got, tt.want)
+		}
+	}
+}
+
+func TestCsToSRTTime(t *testing.T) {
+	tests := []struct {
+		cs   int64
+		want string
+	}{
+		{0, "00:00:00,000"},
+		{250, "00:00:02,500"},
+		{6150, "00:01:01,500"},
+		{360000, "01:00:00,000"},
+	}
+	for _, tt := range tests {
+		got := csToSRTTime(tt.cs)
+		if got != tt.want {
+			t.Errorf("csToSRTTime(%d) = %q, want %q", tt.cs, got, tt.want)
+		}
+	}
+}
+
+func TestCsToVTTTime(t *testing.T) {
+	tests := []struct {
+		cs   int64
+		want string
+	}{
+		{0, "00:00:00.000"},
+		{250, "00:00:02.500"},
+	}
+	for _, tt := range tests {
+		got := csToVTTTime(tt.cs)
+		if got != tt.want {
+			t.Errorf("csToVTTTime(%d) = %q, want %q", tt.cs, got, tt.want)
+		}
+	}
+}
+
+func TestSRT(t *testing.T) {
+	segments := []whisper.Segment{
+		{Start: 0, End: 250, Text: " Hello world"},
+		{Start: 250, End: 510, Text: " How are you"},
+	}
+	got := SRT(segments, nil)
+	want := "1\n00:00:00,000 --> 00:00:02,500\nHello world\n\n2\n00:00:02,500 --> 00:00:05,100\nHow are you\n"
+	if got != want {
+		t.Errorf("SRT() =\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestVTT(t *testing.T) {
+	segments := []whisper.Segment{
+		{Start: 0, End: 250, Text: " Hello world"},
+		{Start: 250, End: 510, Text: " How are you"},
+	}
+	got := VTT(segments, nil)
+	want := "WEBVTT\n\n00:00:00.000 --> 00:00:02.500\nHello world\n\n00:00:02.500 --> 00:00:05.100\nHow are you\n"
+	if got != want {
+		t.Errorf("VTT() =\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestTSV(t *testing.T) {
+	segments := []whisper.Segment{
+		{Start: 0, End: 250, Text: " Hello\tworld"},
+		{Start: 250, End: 510, Text: " How are you"},
+	}
+	if got, want := TSV(segments, nil), "start\tend\ttext\n0\t2500\tHello world\n2500\t5100\tHow are you\n"; got != want {
+		t.Errorf("TSV() =\n%q\nwant:\n%q", got, want)
+	}
+	speakers := []Speaker{{ID: 0}, {ID: -1}}
+	if got, want := TSV(segments, speakers), "start\tend\tspeaker\ttext\n0\t2500\tSpeaker 1\tHello world\n2500\t5100\t\tHow are you\n"; got != want {
+		t.Errorf("TSV() with speakers =\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestLRC(t *testing.T) {
+	segments := []whisper.Segment{
+		{Start: 0, End: 250, Text: " Hello world"},
+		{Start: 6150, End: 6300, Text: " How are you"},
+	}
+	if got, want := LRC(segments, nil), "[00:00.00]Hello world\n[01:01.50]How are you\n"; got != want {
+		t.Errorf("LRC() =\n%q\nwant:\n%q", got, want)
+	}
+	if got := LRC(segments, []Speaker{{ID: 1}, {ID: 1}}); !strings.HasPrefix(got, "[00:00.00]Speaker 2: Hello world\n") {
+		t.Errorf("LRC() with speakers = %q", got)
+	}
+}
+
+func TestTrimExtension(t *testing.T) {
+	tests := map[string]string{
+		"out.verbose.json": "out",
+		"out.json":         "out",
+		"out.srt":          "out",
+		"dir.v1/out":       "dir.v1/out",
+		"out.mp3":          "out.mp3",
+	}
+	for path, want := range tests {
+		if got := TrimExtension(path); got != want {
+			t.Errorf("TrimExtension(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	result := whisper.TranscribeResult{Segments: []whisper.Segment{{Start: 0, End: 100, Text: " Hi"}}}
+	for _, format := range Formats {
+		if body, err := Render(format, result, Options{}); err != nil || len(body) == 0 {
+			t.Errorf("Render(%q) = %q, %v", format, body, err)
+		}
+		if _, ok := Extensions[format]; !ok {
+			t.Errorf("no extension for %q", format)
+		}
+	}
+	if body, _ := Render("json", result, Options{}); string(body) != `{"text":" Hi"}`+"\n" {
+		t.Errorf("Render(json) = %q", body)
+	}
+	if _, err := Render("docx", result, Options{}); err == nil {
+		t.Error("Render(docx) succeeded, want an error")
+	}
+
+	// Words timed only to split speakers are left out, without touching
+	// the caller's segments.
+	result.Segments[0].Words = []whisper.Word{{Text: " Hi", Start: 0, End: 100}}
+	speakers := []diarize.Segment{{Start: 0, End: 1, SpeakerID: 0}}
+	body, _ := Render("verbose_json", result, Options{Speakers: speakers, OmitWords: true})
+	if strings.Contains(string(body), `"words"`) || !strings.Contains(string(body), `"speaker":0`) {
+		t.Errorf("Render(verbose_json, OmitWords) = %s", body)
+	}
+	if len(result.Segments[0].Words) != 1 {
+		t.Error("OmitWords cleared the caller's words")
+	}
+	if body, _ := Render("verbose_json", result, Options{}); !strings.Contains(string(body), `"words"`) {
+		t.Errorf("Render(verbose_json) = %s, want words", body)
+	}
+
+	result.Segments[0].AvgLogprob = float32(math.NaN())
+	if _, err := Render("verbose_json", result, Options{}); err == nil {
+		t.Error("Render(verbose_json) of a NaN succeeded, want an error")
+	}
+}
+
+func TestBuildVerboseJSON(t *testing.T) {
+	segments := []whisper.Segment{
+		{Start: 0, End: 250, Text: "Hello"},
+		{Start: 250, End: 510, Text: " world"},
+	}
+	v := BuildVerboseJSON(whisper.TranscribeResult{Segments: segments, Duration: 510}, nil)
+	if v.Text != "Hello world" {
+		t.Errorf("Text = %q, want %q", v.Text, "Hello world")
+	}
+	if len(v.Segments) != 2 {
+		t.Fatalf("got %d segments, want 2", len(v.Segments))
+	}
+	if v.Segments[0].Start != 0.0 || v.Segments[0].End != 2.5 {
+		t.Errorf("segment[0] times = (%f, %f), want (0.0, 2.5)", v.Segments[0].Start, v.Segments[0].End)
+	}
+	if v.Duration != 5.1 {
+		t.Errorf("Duration = %f, want 5.1", v.Duration)
+	}
+}
+
+func TestBuildVerboseJSONMetrics(t *testing.T) {
+	segments := []whisper.Segment{
+		{Start: 0, End: 100, Text: " Hi", Tokens: []int{7, 8}, AvgLogprob: -0.3, NoSpeechProb: 0.1, CompressionRatio: 1.2},
+		{Start: 100, End: 200, Text: " there"},
+	}
+	v := BuildVerboseJSON(whisper.TranscribeResult{Segments: segments}, nil)
+	s := v.Segments[0]
+	if s.ID != 0 || len(s.Tokens) != 2 || s.AvgLogprob != -0.3 || s.NoSpeechProb != 0.1 || s.CompressionRatio != 1.2 {
+		t.Errorf("segment[0] = %+v", s)
+	}
+	if v.Segments[1].ID != 1 || v.Segments[1].Tokens == nil {
+		t.Errorf("segment[1] = %+v, want id 1 and empty tokens", v.Segments[1])
+	}
+}
+
+func TestBuildVerboseJSONWords(t *testing.T) {
+	segments := []whisper.Segment{
+		{Start: 0, End: 100, Text: " Hello there", Words: []whisper.Word{
+			{Text: "Hello", Start: 0, End: 40, Probability: 0.9},
+			{Text: "there", Start: 50, End: 100, Probability: 0.7},
+		}},
+		{Start: 100, End: 150, Text: " friend", Words: []whisper.Word{
+			{Text: "friend", Start: 100, End: 150, Probability: 0.8},
+		}},
+	}
+	v := BuildVerboseJSON(whisper.TranscribeResult{Segments: segments}, nil)
+	if len(v.Words) != 3 {
+		t.Fatalf("got %d words, want 3", len(v.Words))
+	}
+	if w := v.Words[2]; w.Word != "friend" || w.Start != 1.0 || w.End != 1.5 {
+		t.Errorf("words[2] = %+v, want friend 1.0-1.5", w)
+	}
+
+	if v := BuildVerboseJSON(whisper.TranscribeResult{}, nil); v.Words != nil {
+		t.Errorf("expected no words without word timings, got %+v", v.Words)
+	}
+}
+
+func TestAttributeSpeakers(t *testing.T) {
+	diar := []diarize.Segment{
+		{Start: 0, End: 1.5, SpeakerID: 0},
+		{Start: 1.5, End: 4, SpeakerID: 1},
+	}
+	segments := []whisper.Segment{
+		{Start: 0, End: 300, Text: " Hi there. Hello!", Tokens: []int{1, 2, 3}, Words: []whisper.Word{
+			{Text: "Hi", Start: 0, End: 50},
+			{Text: "there.", Start: 50, End: 140},
+			{Text: "Hello!", Start: 160, End: 300},
+		}},
+		{Start: 300, End: 400, Text: " Bye"}, // no words: whole-segment match
+	}
+	got, speakers := AttributeSpeakers(segments, diar)
+	var ids []int
+	for _, sp := range speakers {
+		ids = append(ids, sp.ID)
+	}
+	if len(got) != 3 || fmt.Sprint(ids) != "[0 1 1]" {
+		t.Fatalf("got %d segments with speakers %v, want 3 with [0 1 1]", len(got), ids)
+	}
+	if got[0].Text != " Hi there." || got[0].Start != 0 || got[0].End != 140 || got[0].Tokens != nil {
+		t.Errorf("first piece = %+v", got[0])
+	}
+	if got[1].Text != " Hello!" || got[1].Start != 160 || got[1].End != 300 {
+		t.Errorf("second piece = %+v", got[1])
+	}
+	if got[2].Text != " Bye" {
+		t.Errorf("unsplit segment = %+v", got[2])
+	}
+}
+
+func TestSpeakerLabels(t *testing.T) {
+	segments := []whisper.Segment{
+		{Start: 0, End: 100, Text: " Hi."},
+		{Start: 100, End: 200, Text: " How are you?"},
+		{Start: 200, End: 300, Text: " Fine."},
+		{Start: 300, End: 400, Text: " Noise"},
+	}
+	speakers := []Speaker{{ID: 0}, {ID: 0}, {ID: 1}, {ID: -1}}
+
+	if got, want := Text(segments, speakers), "Speaker 1: Hi. How are you?\nSpeaker 2: Fine.\nNoise\n"; got != want {
+		t.Errorf("Text() = %q, want %q", got, want)
+	}
+	if got := SRT(segments, speakers); !strings.Contains(got, "\nSpeaker 2: Fine.\n") || !strings.Contains(got, "\nNoise\n") {
+		t.Errorf("SRT() = %q, want speaker-prefixed cues", got)
+	}
+	if got := VTT(segments, speakers); !strings.Contains(got, "\n<v Speaker 1>Hi.\n") || !strings.Contains(got, "\nNoise\n") {
+		t.Errorf("VTT() = %q, want voice tags", got)
+	}
+}
+
+func TestIdentifiedSpeakers(t *testing.T) {
+	diar := []diarize.Segment{{Start: 0, End: 2, SpeakerID: 0, Name: "Alice", Similarity: 0.9}, {Start: 2, End: 4, SpeakerID: 1}}
+	segments := []whisper.Segment{{Start: 0, End: 200, Text: " Hi."}, {Start: 200, End: 400, Text: " Hello."}}
+	got, speakers := AttributeSpeakers(segments, diar)
+
+	v := BuildVerboseJSON(whisper.TranscribeResult{Segments: got}, speakers)
+	if v.Segments[0].Speaker != "Alice" || v.Segments[0].SpeakerSim != 0.9 || v.Segments[1].Speaker != 1 {
+		t.Errorf("speakers = %v (%v), %v; want Alice (0.9), 1", v.Segments[0].Speaker, v.Segments[0].SpeakerSim, v.Segments[1].Speaker)
+	}
+	if text := Text(got, speakers); text != "Alice: Hi.\nSpeaker 2: Hello.\n" {
+		t.Errorf("Text() = %q", text)
+	}
+}
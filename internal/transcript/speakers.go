@@ -0,0 +1,133 @@
+package transcript
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/thewh1teagle/sona/internal/diarize"
+	"github.com/thewh1teagle/sona/internal/whisper"
+)
+
+// Speaker is the diarized speaker of an output segment.
+type Speaker struct {
+	ID         int     // -1 = no speaker overlaps the segment
+	Name       string  // enrolled name when the speaker was identified
+	Similarity float64 // cosine similarity to the enrolled voice
+}
+
+// Label names the speaker for display: the enrolled name, or "Speaker 1"
+// for speaker 0.
+func (sp Speaker) Label() string {
+	if sp.Name != "" {
+		return sp.Name
+	}
+	return fmt.Sprintf("Speaker %d", sp.ID+1)
+}
+
+// Value is the speaker as reported in JSON: the enrolled name, or the id.
+func (sp Speaker) Value() any {
+	if sp.Name != "" {
+		return sp.Name
+	}
+	return sp.ID
+}
+
+// matchSpeaker finds the diarization segment with maximum overlap and
+// returns its speaker_id, or -1 if no overlap found.
+func matchSpeaker(start, end float64, diarSegments []diarize.Segment) int {
+	bestID := -1
+	bestOverlap := 0.0
+	for _, ds := range diarSegments {
+		oStart := start
+		if ds.Start > oStart {
+			oStart = ds.Start
+		}
+		oEnd := end
+		if ds.End < oEnd {
+			oEnd = ds.End
+		}
+		overlap := oEnd - oStart
+		if overlap > bestOverlap {
+			bestOverlap = overlap
+			bestID = ds.SpeakerID
+		}
+	}
+	return bestID
+}
+
+// AttributeSpeakers assigns each segment a diarized speaker (ID -1 when
+// none overlaps it). Segments with word timings are split where the
+// speaker changes between words, each word going to the speaker it
+// overlaps most; a word overlapping no speaker stays with the word before
+// it. The text of a split piece is rebuilt from its words and its tokens
+// are dropped.
+func AttributeSpeakers(segments []whisper.Segment, diarSegments []diarize.Segment) ([]whisper.Segment, []Speaker) {
+	names := map[int]Speaker{}
+	for _, ds := range diarSegments {
+		if ds.Name != "" {
+			names[ds.SpeakerID] = Speaker{ID: ds.SpeakerID, Name: ds.Name, Similarity: ds.Similarity}
+		}
+	}
+	speaker := func(id int) Speaker {
+		if sp, ok := names[id]; ok {
+			return sp
+		}
+		return Speaker{ID: id}
+	}
+
+	var out []whisper.Segment
+	var speakers []Speaker
+	for _, seg := range segments {
+		if len(seg.Words) == 0 {
+			out = append(out, seg)
+			speakers = append(speakers, speaker(matchSpeaker(Seconds(seg.Start), Seconds(seg.End), diarSegments)))
+			continue
+		}
+
+		wordSpeakers := make([]int, len(seg.Words))
+		prev := -1
+		for i, w := range seg.Words {
+			sp := matchSpeaker(Seconds(w.Start), Seconds(w.End), diarSegments)
+			if sp < 0 {
+				sp = prev
+			}
+			wordSpeakers[i], prev = sp, sp
+		}
+		// Leading words overlapping no speaker go to the first one found.
+		for i := len(wordSpeakers) - 2; i >= 0; i-- {
+			if wordSpeakers[i] < 0 {
+				wordSpeakers[i] = wordSpeakers[i+1]
+			}
+		}
+
+		start := 0
+		for i := 1; i <= len(seg.Words); i++ {
+			if i < len(seg.Words) && wordSpeakers[i] == wordSpeakers[start] {
+				continue
+			}
+			if start == 0 && i == len(seg.Words) {
+				out = append(out, seg) // one speaker throughout
+				speakers = append(speakers, speaker(wordSpeakers[0]))
+				break
+			}
+			piece := seg
+			piece.Words = seg.Words[start:i]
+			piece.Tokens = nil
+			var text strings.Builder
+			for _, w := range piece.Words {
+				text.WriteString(" " + w.Text)
+			}
+			piece.Text = text.String()
+			if start > 0 {
+				piece.Start = piece.Words[0].Start
+			}
+			if i < len(seg.Words) {
+				piece.End = piece.Words[len(piece.Words)-1].End
+			}
+			out = append(out, piece)
+			speakers = append(speakers, speaker(wordSpeakers[start]))
+			start = i
+		}
+	}
+	return out, speakers
+}
@@ -0,0 +1,181 @@
+package wav
+
+import "math"
+
+const (
+	resampleZeros     = 16   // sinc zero crossings on each side of the kernel
+	resampleMaxPhases = 1024 // filter phases kept for irregular rate ratios
+	resampleRolloff   = 0.95 // cutoff relative to the lower Nyquist frequency
+	resampleKaiser    = 8.6  // Kaiser window beta (~-90 dB stopband)
+)
+
+// Resample converts samples from one rate to another with a polyphase
+// windowed-sinc filter. The low-pass cutoff sits just below the lower of
+// the two Nyquist frequencies, so downsampling does not alias.
+func Resample(samples []float32, from, to int) []float32 {
+	if from == to || from <= 0 || to <= 0 || len(samples) == 0 {
+		return samples
+	}
+	rs := NewResampler(from, to)
+	out := make([]float32, int(int64(len(samples))*int64(to)/int64(from)))
+	for i := range out {
+		out[i] = rs.at(int64(i), samples, 0)
+	}
+	return out
+}
+
+// Resampler is Resample for a stream: input arrives in pieces and output
+// is produced as soon as the filter has seen enough of it. Only the
+// filter's history is kept, and the concatenated output matches what
+// Resample returns for the whole input.
+type Resampler struct {
+	from, to     int
+	step, phases int64
+	half, taps   int
+	table        []float32
+
+	buf    []float32 // input still needed by the filter
+	offset int       // input index of buf[0]
+	total  int       // input samples received so far
+	next   int64     // index of the next output sample
+}
+
+// NewResampler returns a resampler from one rate to another. If the rates
+// are equal, samples pass through unchanged.
+func NewResampler(from, to int) *Resampler {
+	rs := &Resampler{from: from, to: to}
+	if from == to || from <= 0 || to <= 0 {
+		return rs
+	}
+
+	// Output sample i sits at input position i*step/phases: an integer
+	// offset plus one of `phases` fractional positions. Exact rational
+	// ratios (44100 -> 16000 is 441/160) use one phase per position;
+	// irregular ones round to the nearest of resampleMaxPhases.
+	g := gcd(from, to)
+	rs.step, rs.phases = int64(from/g), int64(to/g)
+	if rs.phases > resampleMaxPhases {
+		rs.step = int64(math.Round(float64(from) / float64(to) * resampleMaxPhases))
+		rs.phases = resampleMaxPhases
+	}
+
+	cutoff := resampleRolloff * math.Min(1, float64(to)/float64(from))
+	rs.half = int(math.Ceil(resampleZeros / cutoff))
+	rs.taps = 2*rs.half + 1
+	rs.table = make([]float32, int(rs.phases)*rs.taps)
+	for p := 0; p < int(rs.phases); p++ {
+		frac := float64(p) / float64(rs.phases)
+		kernel := rs.table[p*rs.taps : (p+1)*rs.taps]
+		var sum float64
+		for j := range kernel {
+			d := float64(j-rs.half) - frac // distance from the output position
+			v := cutoff * sinc(cutoff*d) * kaiser(d/float64(rs.half+1))
+			kernel[j] = float32(v)
+			sum += v
+		}
+		for j := range kernel { // unity gain at DC
+			kernel[j] = float32(float64(kernel[j]) / sum)
+		}
+	}
+	return rs
+}
+
+// Process feeds the next input samples and returns the output that is
+// complete so far. The result does not alias in.
+func (rs *Resampler) Process(in []float32) []float32 {
+	if rs.table == nil {
+		return append([]float32(nil), in...)
+	}
+	rs.buf = append(rs.buf, in...)
+	rs.total += len(in)
+
+	// Output i needs input up to base+half; stop there, and never run past
+	// the output length Resample would give for the input seen so far.
+	limit := int64(rs.total) * int64(rs.to) / int64(rs.from)
+	var out []float32
+	for ; rs.next < limit; rs.next++ {
+		if int(rs.next*rs.step/rs.phases)+rs.half >= rs.total {
+			break
+		}
+		out = append(out, rs.at(rs.next, rs.buf, rs.offset))
+	}
+
+	// Drop input no later output reaches back to.
+	if keep := int(rs.next*rs.step/rs.phases) - rs.half; keep > rs.offset {
+		drop := min(keep-rs.offset, len(rs.buf))
+		rs.buf = append(rs.buf[:0], rs.buf[drop:]...)
+		rs.offset += drop
+	}
+	return out
+}
+
+// Flush returns the remaining output once the input has ended, treating
+// samples past the end as silence.
+func (rs *Resampler) Flush() []float32 {
+	if rs.table == nil {
+		return nil
+	}
+	n := int64(rs.total) * int64(rs.to) / int64(rs.from)
+	var out []float32
+	for ; rs.next < n; rs.next++ {
+		out = append(out, rs.at(rs.next, rs.buf, rs.offset))
+	}
+	rs.buf = nil
+	return out
+}
+
+// at computes output sample i from input held in samples, whose first
+// element is input sample offset. Input outside samples is silence.
+func (rs *Resampler) at(i int64, samples []float32, offset int) float32 {
+	pos := i * rs.step
+	base := int(pos / rs.phases)
+	kernel := rs.table[int(pos%rs.phases)*rs.taps:][:rs.taps]
+	first := base - rs.half - offset
+	var acc float32
+	if first >= 0 && first+rs.taps <= len(samples) {
+		window := samples[first : first+rs.taps]
+		for j, k := range kernel {
+			acc += window[j] * k
+		}
+	} else { // near the edges, treat samples outside the input as silence
+		for j, k := range kernel {
+			if idx := first + j; idx >= 0 && idx < len(samples) {
+				acc += samples[idx] * k
+			}
+		}
+	}
+	return acc
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	x *= math.Pi
+	return math.Sin(x) / x
+}
+
+// kaiser evaluates the Kaiser window at x in [-1, 1].
+func kaiser(x float64) float64 {
+	if x <= -1 || x >= 1 {
+		return 0
+	}
+	return bessel0(resampleKaiser*math.Sqrt(1-x*x)) / bessel0(resampleKaiser)
+}
+
+// bessel0 is the zeroth-order modified Bessel function of the first kind.
+func bessel0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; term > 1e-12*sum; k++ {
+		term *= (x / (2 * float64(k))) * (x / (2 * float64(k)))
+		sum += term
+	}
+	return sum
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
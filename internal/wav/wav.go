@@ -8,140 +8,345 @@ import (
 	"os"
 )
 
+// Audio formats found in the fmt chunk.
+const (
+	FormatPCM        = 1
+	FormatFloat      = 3
+	FormatExtensible = 0xFFFE
+)
+
+// SampleRate is the rate Read resamples to, in Hz.
+const SampleRate = 16000
+
 // Header contains WAV format metadata.
 type Header struct {
-	AudioFormat   uint16
+	AudioFormat   uint16 // FormatPCM or FormatFloat; the sub-format for WAVE_FORMAT_EXTENSIBLE
 	Channels      uint16
 	SampleRate    uint32
 	BitsPerSample uint16
 }
 
-// IsNative returns true if the WAV is already 16kHz mono 16-bit PCM
-// and can be decoded without ffmpeg.
-func (h Header) IsNative() bool {
-	return h.AudioFormat == 1 && h.Channels == 1 && h.SampleRate == 16000 && h.BitsPerSample == 16
+// MaxChannels is the most channels Supported accepts.
+const MaxChannels = 32
+
+// Supported reports whether Read can decode the WAV: 8/16/24/32-bit PCM
+// or 32/64-bit IEEE float, with any rate and up to MaxChannels channels.
+func (h Header) Supported() bool {
+	if h.Channels == 0 || h.Channels > MaxChannels || h.SampleRate == 0 {
+		return false
+	}
+	switch h.AudioFormat {
+	case FormatPCM:
+		return h.BitsPerSample == 8 || h.BitsPerSample == 16 || h.BitsPerSample == 24 || h.BitsPerSample == 32
+	case FormatFloat:
+		return h.BitsPerSample == 32 || h.BitsPerSample == 64
+	}
+	return false
 }
 
-// ReadHeader reads the WAV header and seeks back to the start.
-// Returns an error if the file is not a valid WAV.
-func ReadHeader(r io.ReadSeeker) (Header, error) {
+// parseFmt decodes a fmt chunk, resolving WAVE_FORMAT_EXTENSIBLE to its
+// sub-format.
+func parseFmt(buf []byte) (Header, error) {
+	if len(buf) < 16 {
+		return Header{}, fmt.Errorf("fmt chunk too short (%d bytes)", len(buf))
+	}
+	h := Header{
+		AudioFormat:   binary.LittleEndian.Uint16(buf[0:2]),
+		Channels:      binary.LittleEndian.Uint16(buf[2:4]),
+		SampleRate:    binary.LittleEndian.Uint32(buf[4:8]),
+		BitsPerSample: binary.LittleEndian.Uint16(buf[14:16]),
+	}
+	if h.AudioFormat == FormatExtensible {
+		// cbSize, valid bits, channel mask, then the sub-format GUID whose
+		// first two bytes are the format code.
+		if len(buf) < 40 {
+			return Header{}, fmt.Errorf("extensible fmt chunk too short (%d bytes)", len(buf))
+		}
+		h.AudioFormat = binary.LittleEndian.Uint16(buf[24:26])
+	}
+	return h, nil
+}
+
+// maxFmtSize is the largest fmt chunk accepted. Real ones are 16 to 40
+// bytes; the size comes from the file, so it is not trusted further.
+const maxFmtSize = 1024
+
+// readChunks walks the RIFF chunks of r, parsing the fmt chunk, until the
+// data chunk. It returns the header and the data chunk size, leaving r at
+// the start of the sample data. With headerOnly it returns right after the
+// fmt chunk. Only reads forward, so r may be a stream.
+func readChunks(r io.Reader, headerOnly bool) (Header, uint32, error) {
 	var riff [12]byte
 	if _, err := io.ReadFull(r, riff[:]); err != nil {
-		return Header{}, fmt.Errorf("failed to read WAV header: %w", err)
+		return Header{}, 0, fmt.Errorf("failed to read WAV header: %w", err)
 	}
 	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
-		r.Seek(0, io.SeekStart)
-		return Header{}, fmt.Errorf("not a valid WAV file")
+		return Header{}, 0, fmt.Errorf("not a valid WAV file")
 	}
 
 	var h Header
+	haveFmt := false
 	for {
 		var chunkID [4]byte
 		var chunkSize uint32
 		if err := binary.Read(r, binary.LittleEndian, &chunkID); err != nil {
-			r.Seek(0, io.SeekStart)
-			return Header{}, fmt.Errorf("unexpected end of file: %w", err)
+			return Header{}, 0, fmt.Errorf("unexpected end of file: %w", err)
 		}
 		if err := binary.Read(r, binary.LittleEndian, &chunkSize); err != nil {
-			r.Seek(0, io.SeekStart)
-			return Header{}, fmt.Errorf("could not read chunk size: %w", err)
+			return Header{}, 0, fmt.Errorf("could not read chunk size: %w", err)
 		}
 
-		if string(chunkID[:]) == "fmt " {
-			var fmtBuf [16]byte
-			if _, err := io.ReadFull(r, fmtBuf[:]); err != nil {
-				r.Seek(0, io.SeekStart)
-				return Header{}, fmt.Errorf("failed to read fmt chunk: %w", err)
+		switch string(chunkID[:]) {
+		case "fmt ":
+			if chunkSize > maxFmtSize {
+				return Header{}, 0, fmt.Errorf("fmt chunk too large (%d bytes)", chunkSize)
+			}
+			// Only the first 40 bytes (WAVE_FORMAT_EXTENSIBLE) are parsed.
+			var buf [40]byte
+			n := min(int(chunkSize), len(buf))
+			if _, err := io.ReadFull(r, buf[:n]); err != nil {
+				return Header{}, 0, fmt.Errorf("failed to read fmt chunk: %w", err)
+			}
+			if _, err := io.CopyN(io.Discard, r, int64(chunkSize+chunkSize%2)-int64(n)); err != nil {
+				return Header{}, 0, fmt.Errorf("failed to read fmt chunk: %w", err)
+			}
+			var err error
+			if h, err = parseFmt(buf[:n]); err != nil {
+				return Header{}, 0, err
+			}
+			if headerOnly {
+				return h, 0, nil
+			}
+			haveFmt = true
+		case "data":
+			if !haveFmt {
+				return Header{}, 0, fmt.Errorf("data chunk before fmt chunk")
+			}
+			return h, chunkSize, nil
+		default:
+			// Chunks are padded to an even size.
+			if _, err := io.CopyN(io.Discard, r, int64(chunkSize+chunkSize%2)); err != nil {
+				return Header{}, 0, fmt.Errorf("failed to skip %q chunk: %w", chunkID[:], err)
 			}
-			h.AudioFormat = binary.LittleEndian.Uint16(fmtBuf[0:2])
-			h.Channels = binary.LittleEndian.Uint16(fmtBuf[2:4])
-			h.SampleRate = binary.LittleEndian.Uint32(fmtBuf[4:8])
-			h.BitsPerSample = binary.LittleEndian.Uint16(fmtBuf[14:16])
-			r.Seek(0, io.SeekStart)
-			return h, nil
 		}
+	}
+}
+
+// ReadHeader reads the WAV header and seeks back to the start.
+// Returns an error if the file is not a valid WAV.
+func ReadHeader(r io.ReadSeeker) (Header, error) {
+	h, _, err := readChunks(r, true)
+	r.Seek(0, io.SeekStart)
+	return h, err
+}
 
-		if _, err := r.Seek(int64(chunkSize), io.SeekCurrent); err != nil {
-			r.Seek(0, io.SeekStart)
-			return Header{}, err
+// Read parses a WAV from r and returns 16kHz mono float32 samples in
+// [-1, 1]. Channels are mixed down and other sample rates are resampled
+// (see Resample). r is read sequentially, so it may be a stream.
+func Read(r io.Reader) ([]float32, error) {
+	d, err := NewDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+	var samples []float32
+	chunk := make([]float32, 1<<16)
+	for {
+		n, err := d.Read(chunk)
+		samples = append(samples, chunk[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
 		}
 	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("audio file contains no samples")
+	}
+	return Resample(samples, int(d.SampleRate), SampleRate), nil
+}
+
+// Decoder reads the sample data of a WAV stream a piece at a time, for
+// files too long to hold in memory.
+type Decoder struct {
+	Header
+	r         io.Reader
+	remaining int64 // bytes of sample data left; -1 reads to EOF
+	buf       []byte
+}
+
+// NewDecoder reads the WAV header from r and returns a decoder positioned
+// at the start of the sample data.
+func NewDecoder(r io.Reader) (*Decoder, error) {
+	h, dataSize, err := readChunks(r, false)
+	if err != nil {
+		return nil, err
+	}
+	if !h.Supported() {
+		return nil, fmt.Errorf("unsupported WAV format %d with %d bits per sample and %d channels", h.AudioFormat, h.BitsPerSample, h.Channels)
+	}
+	d := &Decoder{Header: h, r: r, remaining: int64(dataSize)}
+	// Streamed WAVs (e.g. from a pipe) may carry a placeholder data size;
+	// read to EOF then.
+	if dataSize == 0 || dataSize == math.MaxUint32 {
+		d.remaining = -1
+	}
+	return d, nil
 }
 
-// Read parses a 16-bit PCM WAV from an io.ReadSeeker and returns float32 samples in [-1, 1].
-// Assumes 16kHz mono — no resampling is done.
-func Read(r io.ReadSeeker) ([]float32, error) {
-	var header [12]byte
-	if _, err := io.ReadFull(r, header[:]); err != nil {
-		return nil, fmt.Errorf("failed to read WAV header: %w", err)
+// maxReadSize caps the sample data a Decoder reads at once; larger reads
+// are decoded in several passes.
+const maxReadSize = 1 << 20
+
+// Read decodes up to len(dst) mono samples at the file's own sample rate
+// (Header.SampleRate). It returns io.EOF after the last sample; a
+// truncated file ends early without an error.
+func (d *Decoder) Read(dst []float32) (int, error) {
+	frameSize := int(d.Channels) * int(d.BitsPerSample) / 8
+	total := 0
+	for total < len(dst) && d.remaining != 0 {
+		want := int64(min((len(dst)-total)*frameSize, maxReadSize-maxReadSize%frameSize))
+		if d.remaining > 0 {
+			want = min(want, d.remaining-d.remaining%int64(frameSize))
+		}
+		if want == 0 {
+			break
+		}
+		if int64(cap(d.buf)) < want {
+			d.buf = make([]byte, want)
+		}
+		n, err := io.ReadFull(d.r, d.buf[:want])
+		if err == io.ErrUnexpectedEOF || (err == io.EOF && n == 0) {
+			d.remaining = 0 // truncated file or end of a streamed one
+		} else if err != nil {
+			return total, fmt.Errorf("failed to read PCM data: %w", err)
+		} else if d.remaining > 0 {
+			d.remaining -= int64(n)
+		}
+		total += decodeSamples(dst[total:], d.buf[:n-n%frameSize], d.Header)
 	}
-	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
-		return nil, fmt.Errorf("not a valid WAV file")
+	if total == 0 {
+		return 0, io.EOF
 	}
+	return total, nil
+}
 
-	var audioFormat, channels, bitsPerSample uint16
+// decodeSamples converts interleaved sample data to mono float32 in dst,
+// returning the number of samples written.
+func decodeSamples(dst []float32, data []byte, h Header) int {
+	channels := int(h.Channels)
+	width := int(h.BitsPerSample) / 8
+	frameSize := channels * width
+	nSamples := len(data) / frameSize
 
-	var dataSize uint32
-	for {
-		var chunkID [4]byte
-		var chunkSize uint32
-		if err := binary.Read(r, binary.LittleEndian, &chunkID); err != nil {
-			return nil, fmt.Errorf("unexpected end of file: %w", err)
+	var sample func(b []byte) float64
+	switch {
+	case h.AudioFormat == FormatFloat && width == 4:
+		sample = func(b []byte) float64 { return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))) }
+	case h.AudioFormat == FormatFloat:
+		sample = func(b []byte) float64 { return math.Float64frombits(binary.LittleEndian.Uint64(b)) }
+	case width == 1: // 8-bit PCM is unsigned
+		sample = func(b []byte) float64 { return (float64(b[0]) - 128) / math.MaxInt8 }
+	case width == 2:
+		sample = func(b []byte) float64 { return float64(int16(binary.LittleEndian.Uint16(b))) / math.MaxInt16 }
+	case width == 3:
+		sample = func(b []byte) float64 {
+			v := int32(b[0]) | int32(b[1])<<8 | int32(int8(b[2]))<<16
+			return float64(v) / (1<<23 - 1)
 		}
-		if err := binary.Read(r, binary.LittleEndian, &chunkSize); err != nil {
-			return nil, fmt.Errorf("could not read chunk size: %w", err)
+	default:
+		sample = func(b []byte) float64 { return float64(int32(binary.LittleEndian.Uint32(b))) / math.MaxInt32 }
+	}
+
+	for i := 0; i < nSamples; i++ {
+		frame := data[i*frameSize:]
+		var sum float64
+		for ch := 0; ch < channels; ch++ {
+			sum += sample(frame[ch*width:])
 		}
+		dst[i] = float32(sum / float64(channels))
+	}
+	return nSamples
+}
 
-		switch string(chunkID[:]) {
-		case "fmt ":
-			var fmtBuf [16]byte
-			if _, err := io.ReadFull(r, fmtBuf[:]); err != nil {
-				return nil, fmt.Errorf("failed to read fmt chunk: %w", err)
-			}
-			audioFormat = binary.LittleEndian.Uint16(fmtBuf[0:2])
-			channels = binary.LittleEndian.Uint16(fmtBuf[2:4])
-			bitsPerSample = binary.LittleEndian.Uint16(fmtBuf[14:16])
-			if chunkSize > 16 {
-				if _, err := r.Seek(int64(chunkSize-16), io.SeekCurrent); err != nil {
-					return nil, err
-				}
-			}
-		case "data":
-			dataSize = chunkSize
-			goto readData
-		default:
-			if _, err := r.Seek(int64(chunkSize), io.SeekCurrent); err != nil {
-				return nil, err
-			}
+// Write encodes samples as a 16kHz mono 16-bit PCM WAV, the native format
+// read by Read and by sona-diarize.
+func Write(w io.Writer, samples []float32) error {
+	if err := writeHeader(w, uint32(len(samples)*2)); err != nil {
+		return err
+	}
+	return writePCM(w, samples)
+}
+
+// writeHeader writes the header of a native WAV with dataSize bytes of
+// sample data.
+func writeHeader(w io.Writer, dataSize uint32) error {
+	const sampleRate, bytesPerSample = SampleRate, 2
+	header := []any{
+		[4]byte{'R', 'I', 'F', 'F'}, 36 + dataSize, [4]byte{'W', 'A', 'V', 'E'},
+		[4]byte{'f', 'm', 't', ' '}, uint32(16),
+		uint16(1), uint16(1), uint32(sampleRate), uint32(sampleRate * bytesPerSample), uint16(bytesPerSample), uint16(16),
+		[4]byte{'d', 'a', 't', 'a'}, dataSize,
+	}
+	for _, v := range header {
+		if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+			return fmt.Errorf("failed to write WAV header: %w", err)
 		}
 	}
+	return nil
+}
 
-readData:
-	if audioFormat != 1 {
-		return nil, fmt.Errorf("unsupported audio format %d (only PCM=1)", audioFormat)
+func writePCM(w io.Writer, samples []float32) error {
+	pcm := make([]int16, len(samples))
+	for i, s := range samples {
+		pcm[i] = int16(max(-1, min(1, s)) * math.MaxInt16)
 	}
-	if bitsPerSample != 16 {
-		return nil, fmt.Errorf("unsupported bits per sample %d (only 16)", bitsPerSample)
+	if err := binary.Write(w, binary.LittleEndian, pcm); err != nil {
+		return fmt.Errorf("failed to write PCM data: %w", err)
 	}
+	return nil
+}
 
-	nSamples := int(dataSize) / int(channels) / 2
-	if nSamples == 0 {
-		return nil, fmt.Errorf("audio file contains no samples")
+// Writer writes a native WAV a piece at a time, for audio too long to
+// hold in memory. Close fills in the sizes in the header.
+type Writer struct {
+	w io.WriteSeeker
+	n int64 // samples written
+}
+
+// NewWriter writes a WAV header with placeholder sizes to w.
+func NewWriter(w io.WriteSeeker) (*Writer, error) {
+	if err := writeHeader(w, 0); err != nil {
+		return nil, err
 	}
-	raw := make([]int16, int(dataSize)/2)
-	if err := binary.Read(r, binary.LittleEndian, raw); err != nil {
-		return nil, fmt.Errorf("failed to read PCM data: %w", err)
+	return &Writer{w: w}, nil
+}
+
+// Write appends samples to the data chunk.
+func (w *Writer) Write(samples []float32) error {
+	if err := writePCM(w.w, samples); err != nil {
+		return err
 	}
+	w.n += int64(len(samples))
+	return nil
+}
 
-	samples := make([]float32, nSamples)
-	for i := 0; i < nSamples; i++ {
-		var sum float64
-		for ch := 0; ch < int(channels); ch++ {
-			sum += float64(raw[i*int(channels)+ch])
-		}
-		samples[i] = float32(sum / float64(channels) / math.MaxInt16)
+// Len returns the number of samples written so far.
+func (w *Writer) Len() int64 {
+	return w.n
+}
+
+// Close rewrites the header with the final sizes. It does not close the
+// underlying writer.
+func (w *Writer) Close() error {
+	if _, err := w.w.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewrite WAV header: %w", err)
+	}
+	if err := writeHeader(w.w, uint32(min(w.n*2, math.MaxUint32-36))); err != nil {
+		return err
 	}
-	return samples, nil
+	_, err := w.w.Seek(0, io.SeekEnd)
+	return err
 }
 
 // ReadFile opens a WAV file by path and returns float32 samples.
@@ -0,0 +1,262 @@
+package wav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"testing"
+)
+
+// buildWAV assembles a WAV file from a fmt chunk body and sample data.
+func buildWAV(fmtChunk, data []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(4+8+len(fmtChunk)+8+len(data)))
+	b.WriteString("WAVE")
+	b.WriteString("fmt ")
+	binary.Write(&b, binary.LittleEndian, uint32(len(fmtChunk)))
+	b.Write(fmtChunk)
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(len(data)))
+	b.Write(data)
+	return b.Bytes()
+}
+
+func fmtChunk(format, channels uint16, rate uint32, bits uint16) []byte {
+	var b bytes.Buffer
+	blockAlign := channels * bits / 8
+	for _, v := range []any{format, channels, rate, rate * uint32(blockAlign), blockAlign, bits} {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+	return b.Bytes()
+}
+
+func extensibleChunk(subFormat, channels uint16, rate uint32, bits uint16) []byte {
+	b := bytes.NewBuffer(fmtChunk(FormatExtensible, channels, rate, bits))
+	binary.Write(b, binary.LittleEndian, uint16(22))  // cbSize
+	binary.Write(b, binary.LittleEndian, bits)        // valid bits
+	binary.Write(b, binary.LittleEndian, uint32(0x3)) // channel mask
+	binary.Write(b, binary.LittleEndian, subFormat)   // GUID data1 (low half)
+	b.Write(make([]byte, 14))                         // rest of the GUID
+	return b.Bytes()
+}
+
+func TestReadFormats(t *testing.T) {
+	float32Stereo := new(bytes.Buffer)
+	binary.Write(float32Stereo, binary.LittleEndian, []float32{0.5, -0.5, 0.25, 0.25})
+
+	tests := []struct {
+		name string
+		file []byte
+		want []float32
+	}{
+		{"8-bit", buildWAV(fmtChunk(FormatPCM, 1, SampleRate, 8), []byte{128, 255, 1}), []float32{0, 1, -1}},
+		{"16-bit", buildWAV(fmtChunk(FormatPCM, 1, SampleRate, 16), []byte{0xff, 0x7f, 0x01, 0x80}), []float32{1, -1}},
+		{"24-bit", buildWAV(fmtChunk(FormatPCM, 1, SampleRate, 24), []byte{0xff, 0xff, 0x7f, 0x00, 0x00, 0xc0}), []float32{1, -0.5}},
+		{"32-bit", buildWAV(fmtChunk(FormatPCM, 1, SampleRate, 32), []byte{0, 0, 0, 0x40}), []float32{0.5}},
+		{"float32 stereo", buildWAV(fmtChunk(FormatFloat, 2, SampleRate, 32), float32Stereo.Bytes()), []float32{0, 0.25}},
+		{"extensible float", buildWAV(extensibleChunk(FormatFloat, 2, SampleRate, 32), float32Stereo.Bytes()), []float32{0, 0.25}},
+	}
+	for _, tt := range tests {
+		got, err := Read(bytes.NewReader(tt.file))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if math.Abs(float64(got[i]-tt.want[i])) > 1e-3 {
+				t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestReadHeaderExtensible(t *testing.T) {
+	file := buildWAV(extensibleChunk(FormatPCM, 2, 48000, 24), make([]byte, 6))
+	h, err := ReadHeader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.AudioFormat != FormatPCM || h.Channels != 2 || h.SampleRate != 48000 || h.BitsPerSample != 24 || !h.Supported() {
+		t.Errorf("header = %+v", h)
+	}
+}
+
+func TestReadHeaderFmtSize(t *testing.T) {
+	// Bytes past the first 40 are skipped.
+	long := append(extensibleChunk(FormatPCM, 1, SampleRate, 16), make([]byte, 10)...)
+	if _, err := Read(bytes.NewReader(buildWAV(long, []byte{0xff, 0x7f}))); err != nil {
+		t.Errorf("50-byte fmt chunk: %v", err)
+	}
+
+	// A huge declared size is rejected without reading it.
+	file := buildWAV(fmtChunk(FormatPCM, 1, SampleRate, 16), nil)
+	binary.LittleEndian.PutUint32(file[16:20], 1<<30)
+	if _, err := ReadHeader(bytes.NewReader(file)); err == nil {
+		t.Error("ReadHeader accepted a 1 GB fmt chunk")
+	}
+}
+
+func TestReadResamples(t *testing.T) {
+	data := make([]byte, 44100*2)
+	file := buildWAV(fmtChunk(FormatPCM, 1, 44100, 16), data)
+	samples, err := Read(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != SampleRate {
+		t.Errorf("got %d samples, want %d", len(samples), SampleRate)
+	}
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, []float32{0, 0.5, -0.5}); err != nil {
+		t.Fatal(err)
+	}
+	h, err := ReadHeader(bytes.NewReader(buf.Bytes()))
+	if err != nil || h != (Header{AudioFormat: FormatPCM, Channels: 1, SampleRate: SampleRate, BitsPerSample: 16}) {
+		t.Fatalf("header = %+v, %v; want 16 kHz mono 16-bit PCM", h, err)
+	}
+	samples, err := Read(bytes.NewReader(buf.Bytes()))
+	if err != nil || len(samples) != 3 || math.Abs(float64(samples[1]-0.5)) > 1e-3 {
+		t.Errorf("Read = %v, %v", samples, err)
+	}
+}
+
+// sine returns n samples of a unit sine at freq Hz.
+func sine(freq float64, rate, n int) []float32 {
+	s := make([]float32, n)
+	for i := range s {
+		s[i] = float32(math.Sin(2 * math.Pi * freq * float64(i) / float64(rate)))
+	}
+	return s
+}
+
+// rms is the root mean square of s, ignoring filter edges.
+func rms(s []float32) float64 {
+	s = s[len(s)/10 : len(s)*9/10]
+	var sum float64
+	for _, v := range s {
+		sum += float64(v) * float64(v)
+	}
+	return math.Sqrt(sum / float64(len(s)))
+}
+
+func TestResample(t *testing.T) {
+	for _, from := range []int{8000, 22050, 44100, 48000, 44056} {
+		out := Resample(sine(440, from, from), from, SampleRate)
+		if want := SampleRate; len(out) < want-1 || len(out) > want {
+			t.Errorf("%d Hz: got %d samples, want %d", from, len(out), want)
+		}
+		// A passband tone keeps its level (unit sine RMS is 1/sqrt(2)).
+		if got := rms(out); math.Abs(got-math.Sqrt2/2) > 0.01 {
+			t.Errorf("%d Hz: 440 Hz tone RMS = %f, want %f", from, got, math.Sqrt2/2)
+		}
+	}
+
+	// A tone above the target Nyquist is filtered out instead of aliasing.
+	if got := rms(Resample(sine(12000, 48000, 48000), 48000, SampleRate)); got > 0.001 {
+		t.Errorf("12 kHz tone leaked into 16 kHz output with RMS %f", got)
+	}
+}
+
+func TestResamplerMatchesResample(t *testing.T) {
+	in := sine(440, 44100, 44100)
+	want := Resample(in, 44100, SampleRate)
+
+	rs := NewResampler(44100, SampleRate)
+	var got []float32
+	for i, n := 0, 1; i < len(in); i, n = i+n, n*3%997+1 { // uneven piece sizes
+		got = append(got, rs.Process(in[i:min(i+n, len(in))])...)
+	}
+	got = append(got, rs.Flush()...)
+
+	if len(got) != len(want) {
+		t.Fatalf("streamed %d samples, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("sample %d = %f, want %f", i, got[i], want[i])
+		}
+	}
+}
+
+func TestDecoderPieces(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, []float32{0, 0.5, -0.5, 0.25, 1}); err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDecoder(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []float32
+	dst := make([]float32, 2)
+	for {
+		n, err := d.Read(dst)
+		got = append(got, dst[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(got) != 5 || math.Abs(float64(got[3]-0.25)) > 1e-3 {
+		t.Errorf("decoded %v, want [0 0.5 -0.5 0.25 1]", got)
+	}
+}
+
+func TestDecoderBoundsReads(t *testing.T) {
+	// A streamed header (data size 0) with absurd channels is rejected.
+	file := buildWAV(fmtChunk(FormatFloat, 65535, SampleRate, 64), nil)
+	if _, err := NewDecoder(bytes.NewReader(file)); err == nil {
+		t.Error("NewDecoder accepted 65535 channels")
+	}
+
+	// Wide frames are read in passes of at most maxReadSize bytes.
+	const frames = 40000
+	file = buildWAV(fmtChunk(FormatFloat, MaxChannels, SampleRate, 64), make([]byte, frames*MaxChannels*8))
+	binary.LittleEndian.PutUint32(file[40:44], 0)
+	d, err := NewDecoder(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := d.Read(make([]float32, frames+1))
+	if n != frames || err != nil || cap(d.buf) > maxReadSize {
+		t.Errorf("Read = %d, %v with a %d-byte buffer; want %d samples within %d bytes", n, err, cap(d.buf), frames, maxReadSize)
+	}
+	if _, err := d.Read(make([]float32, 1)); err != io.EOF {
+		t.Errorf("Read at the end = %v, want io.EOF", err)
+	}
+}
+
+func TestWriterPatchesHeader(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "*.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := NewWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]float32{0, 0.5})
+	w.Write([]float32{-0.5})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Seek(0, io.SeekStart)
+	samples, err := Read(f)
+	if err != nil || len(samples) != 3 || math.Abs(float64(samples[2]+0.5)) > 1e-3 {
+		t.Errorf("Read = %v, %v; want [0 0.5 -0.5]", samples, err)
+	}
+}
@@ -0,0 +1,165 @@
+package whisper
+
+import (
+	"errors"
+	"io"
+	"math"
+	"strings"
+)
+
+// SampleReader supplies 16 kHz mono samples a piece at a time, such as a
+// decoder reading a long recording from disk.
+type SampleReader interface {
+	// ReadSamples fills dst with the next samples and returns how many were
+	// written. It returns io.EOF once the audio has ended.
+	ReadSamples(dst []float32) (int, error)
+}
+
+// sizedReader is a SampleReader that knows its total length in samples,
+// which lets chunked transcription report progress.
+type sizedReader interface {
+	SampleReader
+	Len() int
+}
+
+// NewSampleReader returns a SampleReader over samples already in memory.
+func NewSampleReader(samples []float32) SampleReader {
+	return &sliceReader{samples, len(samples)}
+}
+
+type sliceReader struct {
+	samples []float32
+	n       int
+}
+
+func (r *sliceReader) Len() int { return r.n }
+
+func (r *sliceReader) ReadSamples(dst []float32) (int, error) {
+	if len(r.samples) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(dst, r.samples)
+	r.samples = r.samples[n:]
+	return n, nil
+}
+
+const (
+	defaultChunkSeconds = 300
+	defaultChunkOverlap = 5
+)
+
+var errAborted = errors.New("whisper: transcription aborted")
+
+// transcribeChunks reads src one window at a time and decodes each with
+// decode, whose segment timestamps are relative to the window. Only one
+// window of samples is held at once.
+//
+// Consecutive windows overlap by opts.ChunkOverlap seconds so speech cut
+// at a window edge is decoded whole by one of them. A segment belongs to
+// the window it starts in, with the boundary in the middle of the overlap;
+// the other window's copy is dropped, as is a segment repeating the one
+// before it. When pause is set, it is asked for a silent point in each
+// window (a sample index, or 0 if none), and a window that has one in its
+// second half ends there instead, without overlap. Progress is reported
+// after each window if src implements Len.
+func transcribeChunks(src SampleReader, opts TranscribeOptions, decode func([]float32) ([]Segment, error), pause func([]float32) int, cb StreamCallbacks) (TranscribeResult, error) {
+	length := opts.ChunkSeconds * SampleRate
+	if length <= 0 {
+		length = defaultChunkSeconds * SampleRate
+	}
+	overlap := opts.ChunkOverlap * SampleRate
+	if overlap <= 0 {
+		overlap = defaultChunkOverlap * SampleRate
+	}
+	overlap = min(overlap, length/2)
+
+	result := TranscribeResult{Segments: []Segment{}}
+	window := make([]float32, 0, length)
+	start := 0              // input index of window[0]
+	var boundary int64 = -1 // segments starting before it belong to the previous window
+	eof := false
+	for {
+		for !eof && len(window) < length {
+			n, err := src.ReadSamples(window[len(window):length])
+			window = window[:len(window)+n]
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				return TranscribeResult{}, err
+			}
+		}
+		if len(window) == 0 {
+			break
+		}
+		if cb.ShouldAbort != nil && cb.ShouldAbort() {
+			return TranscribeResult{}, errAborted
+		}
+
+		// end is how much of the window to decode and next where the next
+		// window starts; segments starting at cut or later are left to it.
+		end, next := len(window), len(window)
+		cut := int64(math.MaxInt64)
+		if !eof {
+			if p := callPause(pause, window); p > len(window)/2 && p <= len(window) {
+				end, next = p, p
+				cut = SamplesToCs(start + p)
+			} else {
+				next = len(window) - overlap
+				cut = SamplesToCs(start + len(window) - overlap/2)
+			}
+		}
+
+		segments, err := decode(window[:end])
+		if err != nil {
+			return TranscribeResult{}, err
+		}
+		offset := SamplesToCs(start)
+		for _, seg := range segments {
+			seg = seg.Shift(offset)
+			if seg.Start < boundary || seg.Start >= cut {
+				continue
+			}
+			if n := len(result.Segments); n > 0 && duplicate(result.Segments[n-1], seg) {
+				continue
+			}
+			result.Segments = append(result.Segments, seg)
+			if cb.OnSegment != nil {
+				cb.OnSegment(seg)
+			}
+		}
+		boundary = cut
+
+		if next >= len(window) && eof {
+			start += len(window)
+			break
+		}
+		window = window[:copy(window, window[next:])]
+		start += next
+		if sized, ok := src.(sizedReader); ok && cb.OnProgress != nil && sized.Len() > 0 {
+			cb.OnProgress(min(99, start*100/sized.Len()))
+		}
+	}
+	if cb.OnProgress != nil {
+		cb.OnProgress(100)
+	}
+	result.Duration = SamplesToCs(start)
+	return result, nil
+}
+
+func callPause(pause func([]float32) int, window []float32) int {
+	if pause == nil {
+		return 0
+	}
+	return pause(window)
+}
+
+// duplicate reports whether seg repeats prev, as when a phrase crossing a
+// window boundary is decoded by both windows with slightly different
+// start times.
+func duplicate(prev, seg Segment) bool {
+	return seg.Start < prev.End && normalizeText(seg.Text) == normalizeText(prev.Text)
+}
+
+func normalizeText(text string) string {
+	return strings.ToLower(strings.Trim(strings.Join(strings.Fields(text), " "), ".,!?;:"))
+}
@@ -0,0 +1,96 @@
+package whisper
+
+import (
+	"fmt"
+	"testing"
+)
+
+// secondsDecoder returns one segment per second of the window, named after
+// the absolute second encoded in the samples (see countingSamples).
+func secondsDecoder(windows *[][2]int) func([]float32) ([]Segment, error) {
+	return func(samples []float32) ([]Segment, error) {
+		*windows = append(*windows, [2]int{int(samples[0]), len(samples)})
+		var segments []Segment
+		for i := 0; i < len(samples); i += SampleRate {
+			rel := int64(i / SampleRate * 100)
+			segments = append(segments, Segment{Start: rel, End: rel + 100, Text: fmt.Sprintf(" s%d", int(samples[i]))})
+		}
+		return segments, nil
+	}
+}
+
+// countingSamples returns n seconds of samples whose value is the second
+// they belong to.
+func countingSamples(n int) []float32 {
+	samples := make([]float32, n*SampleRate)
+	for i := range samples {
+		samples[i] = float32(i / SampleRate)
+	}
+	return samples
+}
+
+func checkSeconds(t *testing.T, result TranscribeResult, n int) {
+	t.Helper()
+	if len(result.Segments) != n {
+		t.Fatalf("got %d segments, want %d: %q", len(result.Segments), n, result.Text())
+	}
+	for i, seg := range result.Segments {
+		if want := fmt.Sprintf(" s%d", i); seg.Text != want || seg.Start != int64(i*100) {
+			t.Errorf("segment %d = %q at %d, want %q at %d", i, seg.Text, seg.Start, want, i*100)
+		}
+	}
+	if result.Duration != int64(n*100) {
+		t.Errorf("duration = %d, want %d", result.Duration, n*100)
+	}
+}
+
+func TestTranscribeChunksOverlap(t *testing.T) {
+	var windows [][2]int
+	var emitted int
+	var progress []int
+	result, err := transcribeChunks(NewSampleReader(countingSamples(25)),
+		TranscribeOptions{ChunkSeconds: 10, ChunkOverlap: 2}, secondsDecoder(&windows), nil,
+		StreamCallbacks{
+			OnSegment:  func(Segment) { emitted++ },
+			OnProgress: func(p int) { progress = append(progress, p) },
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSeconds(t, result, 25)
+	if emitted != 25 {
+		t.Errorf("OnSegment called %d times, want 25", emitted)
+	}
+	want := [][2]int{{0, 10 * SampleRate}, {8, 10 * SampleRate}, {16, 9 * SampleRate}}
+	if fmt.Sprint(windows) != fmt.Sprint(want) {
+		t.Errorf("windows (start second, samples) = %v, want %v", windows, want)
+	}
+	if fmt.Sprint(progress) != "[32 64 100]" {
+		t.Errorf("progress = %v, want [32 64 100]", progress)
+	}
+}
+
+func TestTranscribeChunksPause(t *testing.T) {
+	var windows [][2]int
+	pause := func(samples []float32) int { return 7 * SampleRate }
+	result, err := transcribeChunks(NewSampleReader(countingSamples(20)),
+		TranscribeOptions{ChunkSeconds: 10}, secondsDecoder(&windows), pause, StreamCallbacks{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSeconds(t, result, 20)
+	want := [][2]int{{0, 7 * SampleRate}, {7, 7 * SampleRate}, {14, 6 * SampleRate}}
+	if fmt.Sprint(windows) != fmt.Sprint(want) {
+		t.Errorf("windows (start second, samples) = %v, want %v", windows, want)
+	}
+}
+
+func TestDuplicate(t *testing.T) {
+	prev := Segment{Start: 0, End: 300, Text: " Hello there."}
+	if !duplicate(prev, Segment{Start: 250, End: 320, Text: "hello there"}) {
+		t.Error("overlapping repeat not detected")
+	}
+	if duplicate(prev, Segment{Start: 300, End: 400, Text: " Hello there."}) {
+		t.Error("later repeat treated as a duplicate")
+	}
+}
@@ -0,0 +1,74 @@
+package whisper
+
+import "sync"
+
+// decodeInOrder decodes items 0..n-1 on up to workers goroutines, where
+// decode(w, i) runs item i on worker w (0 <= w < workers) and each worker
+// handles one item at a time. Results are passed to emit in item order, as
+// soon as every earlier item is done, and progress receives the share of
+// items done, never decreasing. abort is polled before each item. The
+// first error stops the remaining items and is returned.
+func decodeInOrder(n, workers int, decode func(worker, i int) ([]Segment, error), emit func(i int, segments []Segment), progress func(int), abort func() bool) error {
+	workers = max(1, min(workers, n))
+
+	var (
+		mu       sync.Mutex
+		next     int                   // next item to hand out
+		emitted  int                   // items passed to emit
+		done     int                   // items decoded
+		pending  = map[int][]Segment{} // decoded items waiting for earlier ones
+		firstErr error
+	)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for {
+				mu.Lock()
+				if firstErr == nil && abort != nil && abort() {
+					firstErr = errAborted
+				}
+				if firstErr != nil || next >= n {
+					mu.Unlock()
+					return
+				}
+				i := next
+				next++
+				mu.Unlock()
+
+				segments, err := decode(w, i)
+
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					return
+				}
+				if firstErr != nil {
+					mu.Unlock()
+					return
+				}
+				pending[i] = segments
+				for {
+					segs, ok := pending[emitted]
+					if !ok {
+						break
+					}
+					delete(pending, emitted)
+					emit(emitted, segs)
+					emitted++
+				}
+				done++
+				if progress != nil {
+					progress(done * 100 / n)
+				}
+				mu.Unlock()
+			}
+		}(w)
+	}
+	wg.Wait()
+	return firstErr
+}
@@ -0,0 +1,64 @@
+package whisper
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDecodeInOrder(t *testing.T) {
+	const n = 20
+	var busy [4]atomic.Int32
+	decode := func(w, i int) ([]Segment, error) {
+		if busy[w].Add(1) != 1 {
+			t.Errorf("worker %d ran two items at once", w)
+		}
+		defer busy[w].Add(-1)
+		time.Sleep(time.Duration((i*7)%5) * time.Millisecond) // finish out of order
+		return []Segment{{Text: fmt.Sprint(i)}}, nil
+	}
+	var order []string
+	var progress []int
+	err := decodeInOrder(n, 4, decode,
+		func(i int, segs []Segment) { order = append(order, segs[0].Text) },
+		func(p int) { progress = append(progress, p) }, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, text := range order {
+		if text != fmt.Sprint(i) {
+			t.Fatalf("emitted %v, want items in order", order)
+		}
+	}
+	if len(order) != n {
+		t.Fatalf("emitted %d items, want %d", len(order), n)
+	}
+	for i := 1; i < len(progress); i++ {
+		if progress[i] < progress[i-1] {
+			t.Fatalf("progress went backwards: %v", progress)
+		}
+	}
+	if progress[len(progress)-1] != 100 {
+		t.Errorf("final progress = %d, want 100", progress[len(progress)-1])
+	}
+}
+
+func TestDecodeInOrderError(t *testing.T) {
+	boom := errors.New("boom")
+	var decoded atomic.Int32
+	err := decodeInOrder(50, 2, func(w, i int) ([]Segment, error) {
+		decoded.Add(1)
+		if i == 3 {
+			return nil, boom
+		}
+		return nil, nil
+	}, func(int, []Segment) {}, nil, nil)
+	if !errors.Is(err, boom) {
+		t.Fatalf("got %v, want boom", err)
+	}
+	if decoded.Load() > 10 {
+		t.Errorf("decoded %d items after the error, want the rest skipped", decoded.Load())
+	}
+}
@@ -0,0 +1,72 @@
+package whisper
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGroupWords(t *testing.T) {
+	tokens := []token{
+		{text: " Hel", start: 0, end: 20, p: 0.8},
+		{text: "lo", start: 20, end: 40, p: 0.6},
+		{text: ",", start: 40, end: 42, p: 1},
+		{text: " world", start: 50, end: 90, p: 0.9},
+		{text: " ", start: 90, end: 91, p: 0.5},
+	}
+	words := groupWords(tokens)
+	if len(words) != 2 {
+		t.Fatalf("got %d words, want 2: %+v", len(words), words)
+	}
+	want := Word{Text: "Hello,", Start: 0, End: 42, Probability: 0.8}
+	if got := words[0]; got.Text != want.Text || got.Start != want.Start || got.End != want.End {
+		t.Errorf("words[0] = %+v, want %+v", got, want)
+	}
+	if p := words[0].Probability; p < 0.79 || p > 0.81 {
+		t.Errorf("words[0].Probability = %f, want 0.8", p)
+	}
+	if words[1].Text != "world" || words[1].Start != 50 || words[1].End != 90 {
+		t.Errorf("words[1] = %+v, want world 50-90", words[1])
+	}
+}
+
+func TestSegmentShift(t *testing.T) {
+	seg := Segment{Start: 10, End: 50, Words: []Word{{Text: "hi", Start: 10, End: 30}}}
+	shifted := seg.Shift(100)
+	if shifted.Start != 110 || shifted.End != 150 || shifted.Words[0].Start != 110 || shifted.Words[0].End != 130 {
+		t.Errorf("Shift(100) = %+v", shifted)
+	}
+	if seg.Words[0].Start != 10 {
+		t.Error("Shift modified the original segment's words")
+	}
+}
+
+func TestSetTokens(t *testing.T) {
+	seg := Segment{Text: " Hello"}
+	seg.setTokens([]token{
+		{id: 10, text: " Hel", plog: -0.5},
+		{id: 11, text: "lo", plog: -1.5},
+	}, segmentOptions{temperature: 0.2})
+	if len(seg.Tokens) != 2 || seg.Tokens[0] != 10 || seg.Tokens[1] != 11 {
+		t.Errorf("Tokens = %v, want [10 11]", seg.Tokens)
+	}
+	if seg.AvgLogprob != -1 {
+		t.Errorf("AvgLogprob = %f, want -1", seg.AvgLogprob)
+	}
+	if seg.Temperature != 0.2 {
+		t.Errorf("Temperature = %f, want 0.2", seg.Temperature)
+	}
+	if seg.Words != nil {
+		t.Errorf("Words = %+v, want nil without word timestamps", seg.Words)
+	}
+}
+
+func TestCompressionRatio(t *testing.T) {
+	if r := compressionRatio(""); r != 0 {
+		t.Errorf("empty text: ratio = %f, want 0", r)
+	}
+	normal := compressionRatio(" The quick brown fox jumps over the lazy dog.")
+	repeated := compressionRatio(strings.Repeat(" thank you", 30))
+	if repeated <= 2.4 || normal >= repeated {
+		t.Errorf("ratios: normal %f, repeated %f; want repeated > 2.4 and above normal", normal, repeated)
+	}
+}
@@ -0,0 +1,90 @@
+package whisper
+
+import (
+	"io"
+	"strings"
+)
+
+// vadWindow is how much audio DetectSpeech runs VAD over at once.
+const vadWindow = 10 * 60 * SampleRate
+
+// detectSpeechChunks reads src in windows of vadWindow samples and runs
+// detect on each, whose regions are relative to the window. A region cut
+// by a window edge is joined with its continuation in the next window.
+// It returns the regions and the audio length in centiseconds.
+func detectSpeechChunks(src SampleReader, detect func([]float32) ([]SpeechSegment, error)) ([]SpeechSegment, int64, error) {
+	speech := []SpeechSegment{}
+	window := make([]float32, vadWindow)
+	start := 0 // input index of window[0]
+	for {
+		n := 0
+		eof := false
+		for n < len(window) && !eof {
+			k, err := src.ReadSamples(window[n:])
+			n += k
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				return nil, 0, err
+			}
+		}
+		if n > 0 {
+			regions, err := detect(window[:n])
+			if err != nil {
+				return nil, 0, err
+			}
+			offset := SamplesToCs(start)
+			for _, seg := range regions {
+				seg.Start += offset
+				seg.End += offset
+				if last := len(speech) - 1; last >= 0 && seg.Start <= speech[last].End {
+					speech[last].End = max(speech[last].End, seg.End)
+					continue
+				}
+				speech = append(speech, seg)
+			}
+		}
+		start += n
+		if eof {
+			return speech, SamplesToCs(start), nil
+		}
+	}
+}
+
+// lastPause returns the sample index in the middle of the last pause
+// between the speech regions of an n-sample window: n if there is no
+// speech, or 0 if speech fills the window.
+func lastPause(speech []SpeechSegment, n int) int {
+	if len(speech) == 0 {
+		return n
+	}
+	// Walk back from the silence after the last speech region.
+	gapEnd := n
+	for i := len(speech) - 1; i >= 0; i-- {
+		gapStart := CsToSamples(speech[i].End)
+		if gapStart < gapEnd {
+			return (gapStart + gapEnd) / 2
+		}
+		gapEnd = CsToSamples(speech[i].Start)
+	}
+	return gapEnd / 2
+}
+
+// carryPromptChars bounds how much of the previous region's text is carried
+// into the next prompt, well under whisper's half-context prompt limit so
+// the caller's own prompt is not truncated away.
+const carryPromptChars = 200
+
+// carryPrompt returns the prompt for the next speech region: the caller's
+// prompt followed by the tail of the text decoded so far, cut at a word
+// boundary.
+func carryPrompt(prompt, previous string) string {
+	previous = strings.Join(strings.Fields(previous), " ")
+	if len(previous) > carryPromptChars {
+		previous = previous[len(previous)-carryPromptChars:]
+		if i := strings.IndexByte(previous, ' '); i >= 0 {
+			previous = previous[i+1:]
+		}
+	}
+	return strings.TrimSpace(prompt + " " + previous)
+}
@@ -0,0 +1,137 @@
+//go:build linux || darwin || windows
+
+package whisper
+
+/*
+#include "whisper_cgo.h"
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+// VadContext is a loaded GGML VAD model. Loading one once and passing it
+// in TranscribeOptions.VadContext avoids reading the model for every
+// transcription. It is safe for concurrent use; detections on one context
+// run one at a time.
+type VadContext struct {
+	mu   sync.Mutex
+	vctx *C.struct_whisper_vad_context
+	path string
+}
+
+// NewVadContext loads the VAD model at path. threads sets the CPU threads
+// it runs on (0 = whisper default).
+func NewVadContext(path string, threads int) (*VadContext, error) {
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+	ctxParams := C.whisper_vad_default_context_params()
+	if threads > 0 {
+		ctxParams.n_threads = C.int(threads)
+	}
+	vctx := C.whisper_vad_init_from_file_with_params(cPath, ctxParams)
+	if vctx == nil {
+		return nil, fmt.Errorf("whisper: failed to load VAD model from %s", path)
+	}
+	return &VadContext{vctx: vctx, path: path}, nil
+}
+
+// Path returns the file the model was loaded from.
+func (v *VadContext) Path() string {
+	return v.path
+}
+
+// Close frees the model once a running detection has finished.
+func (v *VadContext) Close() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.vctx != nil {
+		C.whisper_vad_free(v.vctx)
+		v.vctx = nil
+	}
+}
+
+// DetectSpeech returns the regions of speech in src and the audio length
+// in centiseconds. Audio is read ten minutes at a time, so src may be
+// arbitrarily long.
+func (v *VadContext) DetectSpeech(src SampleReader, params VadParams) ([]SpeechSegment, int64, error) {
+	return detectSpeechChunks(src, func(samples []float32) ([]SpeechSegment, error) {
+		return v.speech(samples, params)
+	})
+}
+
+// DetectSpeech loads the VAD model at modelPath for a single detection;
+// see VadContext.DetectSpeech.
+func DetectSpeech(modelPath string, src SampleReader, params VadParams, threads int) ([]SpeechSegment, int64, error) {
+	v, err := NewVadContext(modelPath, threads)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer v.Close()
+	return v.DetectSpeech(src, params)
+}
+
+// openVAD returns opts.VadContext, or loads opts.VadModelPath for the
+// duration of one call. The caller must call release.
+func openVAD(opts TranscribeOptions) (*VadContext, func(), error) {
+	if opts.VadContext != nil {
+		return opts.VadContext, func() {}, nil
+	}
+	v, err := NewVadContext(opts.VadModelPath, opts.Threads)
+	if err != nil {
+		return nil, nil, err
+	}
+	return v, v.Close, nil
+}
+
+// speech runs VAD over samples and returns the speech regions.
+func (v *VadContext) speech(samples []float32, params VadParams) ([]SpeechSegment, error) {
+	if len(samples) == 0 {
+		return nil, nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.vctx == nil {
+		return nil, fmt.Errorf("whisper: VAD model is closed")
+	}
+	segments := C.whisper_vad_segments_from_samples(v.vctx, vadParams(params), (*C.float)(&samples[0]), C.int(len(samples)))
+	if segments == nil {
+		return nil, fmt.Errorf("whisper: failed to run VAD segmentation")
+	}
+	defer C.whisper_vad_free_segments(segments)
+
+	n := int(C.whisper_vad_segments_n_segments(segments))
+	speech := make([]SpeechSegment, 0, n)
+	for i := 0; i < n; i++ {
+		speech = append(speech, SpeechSegment{
+			Start: int64(C.whisper_vad_segments_get_segment_t0(segments, C.int(i))),
+			End:   int64(C.whisper_vad_segments_get_segment_t1(segments, C.int(i))),
+		})
+	}
+	return speech, nil
+}
+
+// vadParams applies the non-zero fields of p over whisper.cpp's defaults.
+func vadParams(p VadParams) C.struct_whisper_vad_params {
+	params := C.whisper_vad_default_params()
+	if p.Threshold > 0 {
+		params.threshold = C.float(p.Threshold)
+	}
+	if p.MinSpeechMs > 0 {
+		params.min_speech_duration_ms = C.int(p.MinSpeechMs)
+	}
+	if p.MinSilenceMs > 0 {
+		params.min_silence_duration_ms = C.int(p.MinSilenceMs)
+	}
+	if p.MaxSpeechSeconds > 0 {
+		params.max_speech_duration_s = C.float(p.MaxSpeechSeconds)
+	}
+	if p.SpeechPadMs > 0 {
+		params.speech_pad_ms = C.int(p.SpeechPadMs)
+	}
+	return params
+}
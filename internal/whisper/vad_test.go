@@ -0,0 +1,61 @@
+package whisper
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDetectSpeechChunksJoinsRegions(t *testing.T) {
+	// Speech from 9.5 minutes to 10.5 minutes crosses the first window edge.
+	samples := make([]float32, vadWindow+vadWindow/2)
+	var calls int
+	detect := func(window []float32) ([]SpeechSegment, error) {
+		calls++
+		if calls == 1 {
+			return []SpeechSegment{{Start: 100, End: 200}, {Start: 57000, End: 60000}}, nil
+		}
+		return []SpeechSegment{{Start: 0, End: 3000}}, nil
+	}
+	speech, duration, err := detectSpeechChunks(NewSampleReader(samples), detect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SpeechSegment{{100, 200}, {57000, 63000}}
+	if fmt.Sprint(speech) != fmt.Sprint(want) {
+		t.Errorf("speech = %v, want %v", speech, want)
+	}
+	if duration != 90000 {
+		t.Errorf("duration = %d, want 90000", duration)
+	}
+}
+
+func TestLastPause(t *testing.T) {
+	n := 10 * SampleRate
+	tests := []struct {
+		speech []SpeechSegment
+		want   int
+	}{
+		{nil, n},
+		{[]SpeechSegment{{0, 600}}, 8 * SampleRate},              // trailing silence 6-10 s
+		{[]SpeechSegment{{0, 400}, {600, 1000}}, 5 * SampleRate}, // gap 4-6 s
+		{[]SpeechSegment{{200, 1000}}, SampleRate},               // only leading silence
+		{[]SpeechSegment{{0, 500}, {500, 1000}}, 0},              // no pause
+	}
+	for _, tt := range tests {
+		if got := lastPause(tt.speech, n); got != tt.want {
+			t.Errorf("lastPause(%v) = %d, want %d", tt.speech, got, tt.want)
+		}
+	}
+}
+
+func TestCarryPrompt(t *testing.T) {
+	if got := carryPrompt("Names: Ada.", " Hello  there. "); got != "Names: Ada. Hello there." {
+		t.Errorf("carryPrompt = %q", got)
+	}
+	long := strings.Repeat("word ", 100) + "end."
+	got := carryPrompt("", long)
+	if len(got) > carryPromptChars || !strings.HasPrefix(got, "word ") || !strings.HasSuffix(got, " end.") {
+		t.Errorf("carryPrompt(long) = %q, want the last words within %d chars", got, carryPromptChars)
+	}
+}
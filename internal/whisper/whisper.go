@@ -1,29 +1,67 @@
 package whisper
 
 import (
+	"bytes"
+	"compress/zlib"
 	"errors"
+	"runtime"
 	"strings"
 )
 
 var ErrNotImplemented = errors.New("whisper: not implemented on this platform")
 
+// SampleRate is the sample rate whisper expects, in Hz.
+const SampleRate = 16000
+
+// MaxStableWorkers is the most whisper states StableWorkers may use: one
+// per CPU. Each state holds its own KV cache, so more would only cost
+// memory.
+func MaxStableWorkers() int {
+	return runtime.NumCPU()
+}
+
 // TranscribeOptions controls transcription behavior.
 type TranscribeOptions struct {
-	Language         string  // e.g. "en", "he" (empty = whisper.cpp default: "en")
-	DetectLanguage   bool    // auto-detect language (whisper.cpp detect_language)
-	Translate        bool    // translate to English
-	Threads          int     // CPU threads (0 = whisper default)
-	Prompt           string  // initial prompt / vocabulary hint
-	Verbose          bool    // enable whisper/ggml logs
-	Temperature      float32 // initial decoding temperature (0 = whisper default)
-	MaxTextCtx       int     // max tokens from past text as context (0 = whisper default)
-	WordTimestamps   bool    // enable token-level timestamps
-	MaxSegmentLen    int     // max segment length in characters (0 = no limit)
-	SamplingGreedy   bool    // use greedy strategy (default); false = beam search
-	BestOf           int     // greedy: number of top candidates (0 = whisper default)
-	BeamSize         int     // beam search: beam width (0 = whisper default)
-	StableTimestamps bool    // enable VAD-backed timestamp stabilization
-	VadModelPath     string  // path to GGML VAD model (required with StableTimestamps)
+	Language         string      // e.g. "en", "he" (empty = whisper.cpp default: "en")
+	DetectLanguage   bool        // auto-detect language (whisper.cpp detect_language)
+	Translate        bool        // translate to English
+	Threads          int         // CPU threads (0 = whisper default)
+	Prompt           string      // initial prompt / vocabulary hint
+	Verbose          bool        // enable whisper/ggml logs
+	Temperature      float32     // initial decoding temperature (0 = whisper default)
+	MaxTextCtx       int         // max tokens from past text as context (0 = whisper default)
+	WordTimestamps   bool        // enable token-level timestamps
+	MaxSegmentLen    int         // max segment length in characters (0 = no limit)
+	SamplingGreedy   bool        // use greedy strategy (default); false = beam search
+	BestOf           int         // greedy: number of top candidates (0 = whisper default)
+	BeamSize         int         // beam search: beam width (0 = whisper default)
+	StableTimestamps bool        // enable VAD-backed timestamp stabilization
+	StableWorkers    int         // StableTimestamps: VAD regions decoded at once, each on its own whisper state (0 = 1, at most MaxStableWorkers)
+	StableLent       []Worker    // StableTimestamps: idle workers of the same model lent for the extra regions; when non-nil (even empty), used instead of allocating StableWorkers-1 states
+	CarryPrompt      bool        // StableTimestamps: prompt each VAD region with the end of the previous one's text (decodes sequentially)
+	Vad              bool        // skip non-speech with whisper.cpp's VAD before decoding
+	VadModelPath     string      // path to GGML VAD model (required with Vad, StableTimestamps and ChunkOnSilence)
+	VadParams        VadParams   // VAD tuning, used wherever VAD runs
+	VadContext       *VadContext // preloaded VAD model for StableTimestamps and ChunkOnSilence, used instead of loading VadModelPath
+	ChunkSeconds     int         // TranscribeReader window length in seconds (0 = 300)
+	ChunkOverlap     int         // TranscribeReader overlap between windows in seconds (0 = 5)
+	ChunkOnSilence   bool        // TranscribeReader: end windows in a VAD-detected pause (requires VadModelPath)
+}
+
+// VadParams tunes voice activity detection. Zero values keep whisper.cpp's
+// defaults.
+type VadParams struct {
+	Threshold        float32 // speech probability threshold (0 = 0.5)
+	MinSpeechMs      int     // speech shorter than this is ignored (0 = 250)
+	MinSilenceMs     int     // silence needed to end a speech region (0 = 100)
+	MaxSpeechSeconds float32 // longer speech regions are split (0 = no limit)
+	SpeechPadMs      int     // padding kept before and after each region (0 = 30)
+}
+
+// SpeechSegment is a region of speech found by VAD.
+type SpeechSegment struct {
+	Start int64 // start time in centiseconds (10ms units)
+	End   int64 // end time in centiseconds (10ms units)
 }
 
 // Segment represents a transcribed text segment with timestamps.
@@ -31,11 +69,147 @@ type Segment struct {
 	Start int64 // start time in centiseconds (10ms units)
 	End   int64 // end time in centiseconds (10ms units)
 	Text  string
+	Words []Word // set when TranscribeOptions.WordTimestamps is enabled
+
+	Tokens           []int   // text token ids
+	AvgLogprob       float32 // mean log probability of the text tokens
+	NoSpeechProb     float32 // probability that the segment is silence
+	CompressionRatio float32 // zlib compression ratio of the text; high values suggest repetition
+	Temperature      float32 // initial decoding temperature (whisper.cpp does not report fallbacks)
+}
+
+// Word is a word within a segment, built from whisper's token timestamps.
+type Word struct {
+	Text        string  // word without surrounding whitespace
+	Start       int64   // start time in centiseconds (10ms units)
+	End         int64   // end time in centiseconds (10ms units)
+	Probability float32 // mean probability of the word's tokens
+}
+
+// Shift returns a copy of the segment with all timestamps moved by cs
+// centiseconds.
+func (s Segment) Shift(cs int64) Segment {
+	s.Start += cs
+	s.End += cs
+	if s.Words != nil {
+		words := make([]Word, len(s.Words))
+		for i, w := range s.Words {
+			w.Start += cs
+			w.End += cs
+			words[i] = w
+		}
+		s.Words = words
+	}
+	return s
+}
+
+// token is a decoded text token with its timestamps.
+type token struct {
+	id    int
+	text  string
+	start int64
+	end   int64
+	p     float32
+	plog  float32
+}
+
+// segmentOptions controls what is read for each decoded segment.
+type segmentOptions struct {
+	words       bool    // group token timings into words (requires token_timestamps)
+	temperature float32 // reported as Segment.Temperature
+}
+
+func newSegmentOptions(opts TranscribeOptions) segmentOptions {
+	return segmentOptions{words: opts.WordTimestamps, temperature: opts.Temperature}
+}
+
+// setTokens fills the token-derived fields of seg.
+func (seg *Segment) setTokens(tokens []token, opts segmentOptions) {
+	seg.Tokens = make([]int, len(tokens))
+	var sum float32
+	for i, tok := range tokens {
+		seg.Tokens[i] = tok.id
+		sum += tok.plog
+	}
+	if len(tokens) > 0 {
+		seg.AvgLogprob = sum / float32(len(tokens))
+	}
+	seg.CompressionRatio = compressionRatio(seg.Text)
+	seg.Temperature = opts.temperature
+	if opts.words {
+		seg.Words = groupWords(tokens)
+	}
+}
+
+// compressionRatio is the ratio of text size to its zlib-compressed size,
+// as used by OpenAI's whisper to detect repetitive (hallucinated) output.
+func compressionRatio(text string) float32 {
+	if text == "" {
+		return 0
+	}
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write([]byte(text))
+	zw.Close()
+	return float32(len(text)) / float32(buf.Len())
+}
+
+// groupWords merges tokens into words. A token starting with a space
+// begins a new word; any other token continues the current one, so
+// sub-word pieces and punctuation stay attached to their word.
+func groupWords(tokens []token) []Word {
+	var words []Word
+	var pSum float32
+	var n int
+	flush := func() {
+		if n == 0 {
+			return
+		}
+		last := &words[len(words)-1]
+		last.Text = strings.TrimSpace(last.Text)
+		last.Probability = pSum / float32(n)
+		if last.Text == "" {
+			words = words[:len(words)-1]
+		}
+		pSum, n = 0, 0
+	}
+	for _, tok := range tokens {
+		if n == 0 || strings.HasPrefix(tok.text, " ") {
+			flush()
+			words = append(words, Word{Text: tok.text, Start: tok.start, End: tok.end})
+		} else {
+			last := &words[len(words)-1]
+			last.Text += tok.text
+			last.End = tok.end
+		}
+		pSum += tok.p
+		n++
+	}
+	flush()
+	return words
 }
 
 // TranscribeResult holds the output of a transcription.
 type TranscribeResult struct {
-	Segments []Segment
+	Segments      []Segment
+	Language      string             // language code whisper decoded with, e.g. "en"
+	LanguageProbs map[string]float32 // per-language probabilities, set when the language was auto-detected
+	Duration      int64              // input audio length in centiseconds (10ms units)
+}
+
+// autoLanguage reports whether whisper picks the language itself.
+func autoLanguage(opts TranscribeOptions) bool {
+	return opts.DetectLanguage || opts.Language == "auto"
+}
+
+// SamplesToCs converts a sample count at SampleRate to centiseconds.
+func SamplesToCs(n int) int64 {
+	return int64(n) * 100 / SampleRate
+}
+
+// CsToSamples converts centiseconds to a sample count at SampleRate.
+func CsToSamples(cs int64) int {
+	return int(cs * SampleRate / 100)
 }
 
 // Text returns the concatenated text of all segments.
@@ -55,4 +229,6 @@ type StreamCallbacks struct {
 	OnSegment func(segment Segment)
 	// ShouldAbort is polled during inference; return true to cancel.
 	ShouldAbort func() bool
+
+	segment segmentOptions // what OnSegment reads for each segment
 }
@@ -22,19 +22,20 @@ func sonaGoProgressCB(handle uintptr, progress int32) {
 }
 
 //export sonaGoSegmentCB
-func sonaGoSegmentCB(handle uintptr, ctxPtr unsafe.Pointer, nNew int32) {
+func sonaGoSegmentCB(handle uintptr, ctxPtr unsafe.Pointer, statePtr unsafe.Pointer, nNew int32) {
 	h := cgo.Handle(handle)
 	cb := h.Value().(*StreamCallbacks)
 	if cb.OnSegment != nil {
-		ctx := (*C.struct_whisper_context)(ctxPtr)
-		nSegments := int(C.whisper_full_n_segments(ctx))
+		// whisper.cpp always passes the state being decoded, including the
+		// context's built-in one, so read segments from it directly.
+		r := runner{
+			ctx:   (*C.struct_whisper_context)(ctxPtr),
+			state: (*C.struct_whisper_state)(statePtr),
+			opts:  cb.segment,
+		}
+		nSegments := r.nSegments()
 		for i := nSegments - int(nNew); i < nSegments; i++ {
-			seg := Segment{
-				Start: int64(C.whisper_full_get_segment_t0(ctx, C.int(i))),
-				End:   int64(C.whisper_full_get_segment_t1(ctx, C.int(i))),
-				Text:  C.GoString(C.whisper_full_get_segment_text(ctx, C.int(i))),
-			}
-			cb.OnSegment(seg)
+			cb.OnSegment(r.segment(i))
 		}
 	}
 }
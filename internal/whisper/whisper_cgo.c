@@ -3,7 +3,7 @@
 
 // Forward declarations for Go-exported callback trampolines.
 extern void sonaGoProgressCB(uintptr_t handle, int32_t progress);
-extern void sonaGoSegmentCB(uintptr_t handle, void *ctx_ptr, int32_t n_new);
+extern void sonaGoSegmentCB(uintptr_t handle, void *ctx_ptr, void *state_ptr, int32_t n_new);
 extern int32_t sonaGoAbortCB(uintptr_t handle);
 
 static int sona_whisper_verbose = 0;
@@ -28,8 +28,7 @@ static void sona_whisper_progress_trampoline(struct whisper_context *ctx, struct
 }
 
 static void sona_whisper_new_segment_trampoline(struct whisper_context *ctx, struct whisper_state *state, int n_new, void *user_data) {
-    (void)state;
-    sonaGoSegmentCB((uintptr_t)user_data, ctx, (int32_t)n_new);
+    sonaGoSegmentCB((uintptr_t)user_data, ctx, state, (int32_t)n_new);
 }
 
 static _Bool sona_whisper_abort_trampoline(void *user_data) {
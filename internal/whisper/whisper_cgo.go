@@ -12,6 +12,7 @@ import "C"
 import (
 	"fmt"
 	"os"
+	"runtime"
 	"runtime/cgo"
 	"unsafe"
 )
@@ -20,6 +21,33 @@ type Context struct {
 	ctx *C.struct_whisper_context
 }
 
+// State is an independent decoding state sharing a Context's loaded model.
+// Separate States can transcribe concurrently without loading the weights
+// again; a single State must not be used from two goroutines at once.
+type State struct {
+	ctx   *Context
+	state *C.struct_whisper_state
+}
+
+// Worker is a Context or one of its States: somewhere a transcription can
+// decode. Idle ones can be lent to a transcription through
+// TranscribeOptions.StableLent.
+type Worker interface {
+	newRunner() runner
+}
+
+func (c *Context) newRunner() runner { return runner{ctx: c.ctx} }
+
+func (s *State) newRunner() runner { return runner{ctx: s.ctx.ctx, state: s.state} }
+
+// runner pairs a model with the decoding state to run it on. A nil state
+// means the context's built-in state.
+type runner struct {
+	ctx   *C.struct_whisper_context
+	state *C.struct_whisper_state
+	opts  segmentOptions
+}
+
 func SetVerbose(v bool) {
 	if v {
 		C.sona_whisper_set_verbose(1)
@@ -53,6 +81,19 @@ func New(modelPath string, gpuDevice int, noGpu bool) (*Context, error) {
 	return &Context{ctx: ctx}, nil
 }
 
+// NewState allocates a decoding state for the loaded model. The caller
+// must Close it before closing the Context.
+func (c *Context) NewState() (*State, error) {
+	if c.ctx == nil {
+		return nil, fmt.Errorf("whisper: context is nil")
+	}
+	state := C.whisper_init_state(c.ctx)
+	if state == nil {
+		return nil, fmt.Errorf("whisper: failed to allocate state")
+	}
+	return &State{ctx: c, state: state}, nil
+}
+
 // Transcribe runs inference and returns all segments with timestamps.
 func (c *Context) Transcribe(samples []float32, opts TranscribeOptions) (TranscribeResult, error) {
 	return c.TranscribeStream(samples, opts, StreamCallbacks{})
@@ -63,11 +104,58 @@ func (c *Context) TranscribeStream(samples []float32, opts TranscribeOptions, cb
 	if c.ctx == nil {
 		return TranscribeResult{}, fmt.Errorf("whisper: context is nil")
 	}
+	return runner{ctx: c.ctx, opts: newSegmentOptions(opts)}.transcribeStream(samples, opts, cb)
+}
+
+// Transcribe runs inference on this state and returns all segments with timestamps.
+func (s *State) Transcribe(samples []float32, opts TranscribeOptions) (TranscribeResult, error) {
+	return s.TranscribeStream(samples, opts, StreamCallbacks{})
+}
+
+// TranscribeStream runs inference on this state with real-time callbacks
+// for progress, segments, and cancellation.
+func (s *State) TranscribeStream(samples []float32, opts TranscribeOptions, cb StreamCallbacks) (TranscribeResult, error) {
+	if s.state == nil || s.ctx.ctx == nil {
+		return TranscribeResult{}, fmt.Errorf("whisper: state is closed")
+	}
+	return runner{ctx: s.ctx.ctx, state: s.state, opts: newSegmentOptions(opts)}.transcribeStream(samples, opts, cb)
+}
+
+// TranscribeReader transcribes audio read incrementally from src, one
+// window of opts.ChunkSeconds at a time, so memory use depends on the
+// window length rather than the recording's. Segments are emitted through
+// cb.OnSegment once their window is decoded. Progress is reported after
+// each window when src has a Len() int method giving its total length in
+// samples (as NewSampleReader's does); otherwise only 100 at the end.
+func (c *Context) TranscribeReader(src SampleReader, opts TranscribeOptions, cb StreamCallbacks) (TranscribeResult, error) {
+	if c.ctx == nil {
+		return TranscribeResult{}, fmt.Errorf("whisper: context is nil")
+	}
+	return runner{ctx: c.ctx, opts: newSegmentOptions(opts)}.transcribeReader(src, opts, cb)
+}
+
+// TranscribeReader is Context.TranscribeReader on this state.
+func (s *State) TranscribeReader(src SampleReader, opts TranscribeOptions, cb StreamCallbacks) (TranscribeResult, error) {
+	if s.state == nil || s.ctx.ctx == nil {
+		return TranscribeResult{}, fmt.Errorf("whisper: state is closed")
+	}
+	return runner{ctx: s.ctx.ctx, state: s.state, opts: newSegmentOptions(opts)}.transcribeReader(src, opts, cb)
+}
+
+// Close frees the state. The Context it came from stays loaded.
+func (s *State) Close() {
+	if s.state != nil {
+		C.whisper_free_state(s.state)
+		s.state = nil
+	}
+}
+
+func (r runner) transcribeStream(samples []float32, opts TranscribeOptions, cb StreamCallbacks) (TranscribeResult, error) {
 	if len(samples) == 0 {
 		return TranscribeResult{}, fmt.Errorf("whisper: no samples")
 	}
 	if opts.StableTimestamps {
-		return c.transcribeStableTimestamps(samples, opts, cb)
+		return r.transcribeStableTimestamps(samples, opts, cb)
 	}
 
 	params, cleanup := buildFullParams(opts)
@@ -77,17 +165,192 @@ func (c *Context) TranscribeStream(samples []float32, opts TranscribeOptions, cb
 	hasCallbacks := cb.OnProgress != nil || cb.OnSegment != nil || cb.ShouldAbort != nil
 	var handle cgo.Handle
 	if hasCallbacks {
+		cb.segment = r.opts
 		handle = cgo.NewHandle(&cb)
 		defer handle.Delete()
 		C.sona_whisper_set_stream_callbacks(&params, C.uintptr_t(handle))
 	}
 
-	ret := C.whisper_full(c.ctx, params, (*C.float)(&samples[0]), C.int(len(samples)))
+	ret := r.full(params, samples)
 	if ret != 0 {
 		return TranscribeResult{}, fmt.Errorf("whisper: transcription failed with code %d", ret)
 	}
 
-	return TranscribeResult{Segments: collectSegments(c.ctx)}, nil
+	result := TranscribeResult{
+		Segments: r.collectSegments(),
+		Language: r.language(),
+		Duration: SamplesToCs(len(samples)),
+	}
+	if autoLanguage(opts) {
+		// Re-run detection on the mel whisper_full left in the state to get
+		// the full distribution; failure only loses the probabilities.
+		if _, probs, err := r.languageProbs(opts.Threads); err == nil {
+			result.LanguageProbs = probs
+		}
+	}
+	return result, nil
+}
+
+func (r runner) transcribeReader(src SampleReader, opts TranscribeOptions, cb StreamCallbacks) (TranscribeResult, error) {
+	if opts.ChunkOnSilence && opts.VadModelPath == "" && opts.VadContext == nil {
+		return TranscribeResult{}, fmt.Errorf("whisper: vad_model is required when chunking on silence")
+	}
+
+	params, cleanup := buildFullParams(opts)
+	defer cleanup()
+	// Segments are emitted by transcribeChunks once the overlap is resolved;
+	// whisper only gets the abort check.
+	abortOnly := StreamCallbacks{ShouldAbort: cb.ShouldAbort}
+	if cb.ShouldAbort != nil {
+		handle := cgo.NewHandle(&abortOnly)
+		defer handle.Delete()
+		C.sona_whisper_set_stream_callbacks(&params, C.uintptr_t(handle))
+	}
+
+	var pause func([]float32) int
+	if opts.ChunkOnSilence {
+		vad, release, err := openVAD(opts)
+		if err != nil {
+			return TranscribeResult{}, err
+		}
+		defer release()
+		pause = func(samples []float32) int {
+			speech, err := vad.speech(samples, opts.VadParams)
+			if err != nil {
+				return 0
+			}
+			return lastPause(speech, len(samples))
+		}
+	}
+
+	// The first window decides the reported language.
+	var language string
+	var languageProbs map[string]float32
+	decode := func(samples []float32) ([]Segment, error) {
+		if opts.StableTimestamps {
+			res, err := r.transcribeStableTimestamps(samples, opts, abortOnly)
+			if language == "" {
+				language, languageProbs = res.Language, res.LanguageProbs
+			}
+			return res.Segments, err
+		}
+		if ret := r.full(params, samples); ret != 0 {
+			return nil, fmt.Errorf("whisper: transcription failed with code %d", ret)
+		}
+		if language == "" {
+			language = r.language()
+			if autoLanguage(opts) {
+				if _, probs, err := r.languageProbs(opts.Threads); err == nil {
+					languageProbs = probs
+				}
+			}
+		}
+		return r.collectSegments(), nil
+	}
+
+	result, err := transcribeChunks(src, opts, decode, pause, cb)
+	if err != nil {
+		return TranscribeResult{}, err
+	}
+	result.Language, result.LanguageProbs = language, languageProbs
+	return result, nil
+}
+
+// DetectLanguage identifies the spoken language from the first 30 seconds
+// of samples, returning its code and the probability of every language.
+func (c *Context) DetectLanguage(samples []float32, threads int) (string, map[string]float32, error) {
+	if c.ctx == nil {
+		return "", nil, fmt.Errorf("whisper: context is nil")
+	}
+	return runner{ctx: c.ctx}.detectLanguage(samples, threads)
+}
+
+// DetectLanguage identifies the spoken language on this state.
+func (s *State) DetectLanguage(samples []float32, threads int) (string, map[string]float32, error) {
+	if s.state == nil || s.ctx.ctx == nil {
+		return "", nil, fmt.Errorf("whisper: state is closed")
+	}
+	return runner{ctx: s.ctx.ctx, state: s.state}.detectLanguage(samples, threads)
+}
+
+// LanguageName returns whisper's full name for a language code, e.g.
+// "english" for "en", or "" if the code is unknown.
+func LanguageName(code string) string {
+	cCode := C.CString(code)
+	defer C.free(unsafe.Pointer(cCode))
+	id := C.whisper_lang_id(cCode)
+	if id < 0 {
+		return ""
+	}
+	return C.GoString(C.whisper_lang_str_full(id))
+}
+
+func defaultThreads(threads int) int {
+	if threads > 0 {
+		return threads
+	}
+	return min(4, runtime.NumCPU()) // whisper.cpp's default
+}
+
+func (r runner) detectLanguage(samples []float32, threads int) (string, map[string]float32, error) {
+	if len(samples) == 0 {
+		return "", nil, fmt.Errorf("whisper: no samples")
+	}
+	threads = defaultThreads(threads)
+	var ret C.int
+	if r.state == nil {
+		ret = C.whisper_pcm_to_mel(r.ctx, (*C.float)(&samples[0]), C.int(len(samples)), C.int(threads))
+	} else {
+		ret = C.whisper_pcm_to_mel_with_state(r.ctx, r.state, (*C.float)(&samples[0]), C.int(len(samples)), C.int(threads))
+	}
+	if ret != 0 {
+		return "", nil, fmt.Errorf("whisper: failed to compute mel spectrogram (code %d)", ret)
+	}
+	return r.languageProbs(threads)
+}
+
+// languageProbs runs language detection on the mel already in the state.
+func (r runner) languageProbs(threads int) (string, map[string]float32, error) {
+	threads = defaultThreads(threads)
+	probs := make([]C.float, int(C.whisper_lang_max_id())+1)
+	var id C.int
+	if r.state == nil {
+		id = C.whisper_lang_auto_detect(r.ctx, 0, C.int(threads), &probs[0])
+	} else {
+		id = C.whisper_lang_auto_detect_with_state(r.ctx, r.state, 0, C.int(threads), &probs[0])
+	}
+	if id < 0 {
+		return "", nil, fmt.Errorf("whisper: language detection failed (code %d)", id)
+	}
+	byCode := make(map[string]float32, len(probs))
+	for i, p := range probs {
+		if code := C.whisper_lang_str(C.int(i)); code != nil {
+			byCode[C.GoString(code)] = float32(p)
+		}
+	}
+	return C.GoString(C.whisper_lang_str(id)), byCode, nil
+}
+
+// language returns the code of the language of the last decode.
+func (r runner) language() string {
+	var id C.int
+	if r.state == nil {
+		id = C.whisper_full_lang_id(r.ctx)
+	} else {
+		id = C.whisper_full_lang_id_from_state(r.state)
+	}
+	if id < 0 {
+		return ""
+	}
+	return C.GoString(C.whisper_lang_str(id))
+}
+
+// full runs whisper_full on the runner's state.
+func (r runner) full(params C.struct_whisper_full_params, samples []float32) C.int {
+	if r.state == nil {
+		return C.whisper_full(r.ctx, params, (*C.float)(&samples[0]), C.int(len(samples)))
+	}
+	return C.whisper_full_with_state(r.ctx, r.state, params, (*C.float)(&samples[0]), C.int(len(samples)))
 }
 
 func buildFullParams(opts TranscribeOptions) (C.struct_whisper_full_params, func()) {
@@ -139,6 +402,13 @@ func buildFullParams(opts TranscribeOptions) (C.struct_whisper_full_params, func
 	if opts.BeamSize > 0 {
 		params.beam_search.beam_size = C.int(opts.BeamSize)
 	}
+	if opts.Vad {
+		cVadModel := C.CString(opts.VadModelPath)
+		cPtrs = append(cPtrs, unsafe.Pointer(cVadModel))
+		params.vad = C.bool(true)
+		params.vad_model_path = cVadModel
+		params.vad_params = vadParams(opts.VadParams)
+	}
 
 	cleanup := func() {
 		for _, ptr := range cPtrs {
@@ -148,21 +418,83 @@ func buildFullParams(opts TranscribeOptions) (C.struct_whisper_full_params, func
 	return params, cleanup
 }
 
-func collectSegments(ctx *C.struct_whisper_context) []Segment {
-	nSegments := int(C.whisper_full_n_segments(ctx))
+func (r runner) nSegments() int {
+	if r.state == nil {
+		return int(C.whisper_full_n_segments(r.ctx))
+	}
+	return int(C.whisper_full_n_segments_from_state(r.state))
+}
+
+// segment reads decoded segment i with its token metrics, and its words
+// if enabled.
+func (r runner) segment(i int) Segment {
+	var seg Segment
+	if r.state == nil {
+		seg = Segment{
+			Start:        int64(C.whisper_full_get_segment_t0(r.ctx, C.int(i))),
+			End:          int64(C.whisper_full_get_segment_t1(r.ctx, C.int(i))),
+			Text:         C.GoString(C.whisper_full_get_segment_text(r.ctx, C.int(i))),
+			NoSpeechProb: float32(C.whisper_full_get_segment_no_speech_prob(r.ctx, C.int(i))),
+		}
+	} else {
+		seg = Segment{
+			Start:        int64(C.whisper_full_get_segment_t0_from_state(r.state, C.int(i))),
+			End:          int64(C.whisper_full_get_segment_t1_from_state(r.state, C.int(i))),
+			Text:         C.GoString(C.whisper_full_get_segment_text_from_state(r.state, C.int(i))),
+			NoSpeechProb: float32(C.whisper_full_get_segment_no_speech_prob_from_state(r.state, C.int(i))),
+		}
+	}
+	seg.setTokens(r.tokens(i), r.opts)
+	return seg
+}
+
+// tokens reads the text tokens of segment i, skipping special and
+// timestamp tokens.
+func (r runner) tokens(i int) []token {
+	eot := C.whisper_token_eot(r.ctx)
+	var n int
+	if r.state == nil {
+		n = int(C.whisper_full_n_tokens(r.ctx, C.int(i)))
+	} else {
+		n = int(C.whisper_full_n_tokens_from_state(r.state, C.int(i)))
+	}
+	tokens := make([]token, 0, n)
+	for j := 0; j < n; j++ {
+		var data C.whisper_token_data
+		var text *C.char
+		if r.state == nil {
+			data = C.whisper_full_get_token_data(r.ctx, C.int(i), C.int(j))
+			text = C.whisper_full_get_token_text(r.ctx, C.int(i), C.int(j))
+		} else {
+			data = C.whisper_full_get_token_data_from_state(r.state, C.int(i), C.int(j))
+			text = C.whisper_full_get_token_text_from_state(r.ctx, r.state, C.int(i), C.int(j))
+		}
+		if data.id >= eot {
+			continue
+		}
+		tokens = append(tokens, token{
+			id:    int(data.id),
+			text:  C.GoString(text),
+			start: int64(data.t0),
+			end:   int64(data.t1),
+			p:     float32(data.p),
+			plog:  float32(data.plog),
+		})
+	}
+	return tokens
+}
+
+func (r runner) collectSegments() []Segment {
+	nSegments := r.nSegments()
 	segments := make([]Segment, nSegments)
 	for i := 0; i < nSegments; i++ {
-		segments[i] = Segment{
-			Start: int64(C.whisper_full_get_segment_t0(ctx, C.int(i))),
-			End:   int64(C.whisper_full_get_segment_t1(ctx, C.int(i))),
-			Text:  C.GoString(C.whisper_full_get_segment_text(ctx, C.int(i))),
-		}
+		segments[i] = r.segment(i)
 	}
 	return segments
 }
 
-func (c *Context) transcribeStableTimestamps(samples []float32, opts TranscribeOptions, cb StreamCallbacks) (TranscribeResult, error) {
-	if opts.VadModelPath == "" {
+func (r runner) transcribeStableTimestamps(samples []float32, opts TranscribeOptions, cb StreamCallbacks) (TranscribeResult, error) {
+	if opts.VadModelPath == "" && opts.VadContext == nil {
 		return TranscribeResult{}, fmt.Errorf("whisper: vad_model is required when stable timestamps are enabled")
 	}
 
@@ -179,76 +511,107 @@ func (c *Context) transcribeStableTimestamps(samples []float32, opts TranscribeO
 		C.sona_whisper_set_stream_callbacks(&params, C.uintptr_t(handle))
 	}
 
-	cVadModelPath := C.CString(opts.VadModelPath)
-	defer C.free(unsafe.Pointer(cVadModelPath))
-
-	vadCtxParams := C.whisper_vad_default_context_params()
-	vctx := C.whisper_vad_init_from_file_with_params(cVadModelPath, vadCtxParams)
-	if vctx == nil {
-		return TranscribeResult{}, fmt.Errorf("whisper: failed to load VAD model from %s", opts.VadModelPath)
+	vad, release, err := openVAD(opts)
+	if err != nil {
+		return TranscribeResult{}, err
 	}
-	defer C.whisper_vad_free(vctx)
+	defer release()
 
-	vadParams := C.whisper_vad_default_params()
-	vadSegments := C.whisper_vad_segments_from_samples(vctx, vadParams, (*C.float)(&samples[0]), C.int(len(samples)))
-	if vadSegments == nil {
-		return TranscribeResult{}, fmt.Errorf("whisper: failed to run VAD segmentation")
+	speech, err := vad.speech(samples, opts.VadParams)
+	if err != nil {
+		return TranscribeResult{}, err
 	}
-	defer C.whisper_vad_free_segments(vadSegments)
-
-	nVadSegments := int(C.whisper_vad_segments_n_segments(vadSegments))
-	if nVadSegments == 0 {
+	if len(speech) == 0 {
 		if cb.OnProgress != nil {
 			cb.OnProgress(100)
 		}
-		return TranscribeResult{Segments: []Segment{}}, nil
+		return TranscribeResult{Segments: []Segment{}, Duration: SamplesToCs(len(samples))}, nil
 	}
 
-	result := TranscribeResult{Segments: make([]Segment, 0, nVadSegments)}
-	for i := 0; i < nVadSegments; i++ {
-		if cb.ShouldAbort != nil && cb.ShouldAbort() {
-			return TranscribeResult{}, fmt.Errorf("whisper: transcription aborted")
+	// Extra states let VAD regions decode concurrently; each region is
+	// independent, so results are only reordered. Lent workers are used
+	// as they are; otherwise states are allocated for this call.
+	// Carrying the prompt makes each region depend on the one before it.
+	workers := []runner{r}
+	for _, w := range opts.StableLent {
+		if opts.CarryPrompt || len(workers) >= len(speech) {
+			break
 		}
-
-		t0cs := int64(C.whisper_vad_segments_get_segment_t0(vadSegments, C.int(i)))
-		t1cs := int64(C.whisper_vad_segments_get_segment_t1(vadSegments, C.int(i)))
-		if t1cs <= t0cs {
-			continue
+		worker := w.newRunner()
+		worker.opts = r.opts
+		workers = append(workers, worker)
+	}
+	for !opts.CarryPrompt && opts.StableLent == nil && len(workers) < min(opts.StableWorkers, len(speech), MaxStableWorkers()) {
+		state := C.whisper_init_state(r.ctx)
+		if state == nil {
+			break // decode on the states we have
 		}
+		defer C.whisper_free_state(state)
+		workers = append(workers, runner{ctx: r.ctx, state: state, opts: r.opts})
+	}
 
-		start := int(float64(t0cs) * float64(C.WHISPER_SAMPLE_RATE) / 100.0)
-		end := int(float64(t1cs) * float64(C.WHISPER_SAMPLE_RATE) / 100.0)
-		if start < 0 {
-			start = 0
-		}
-		if end > len(samples) {
-			end = len(samples)
+	bounds := func(region SpeechSegment) (int, int) {
+		return max(0, int(region.Start*SampleRate/100)), min(len(samples), int(region.End*SampleRate/100))
+	}
+	// The first speech region decides the reported language.
+	first := -1
+	for i, region := range speech {
+		if start, end := bounds(region); end > start {
+			first = i
+			break
 		}
+	}
+
+	result := TranscribeResult{
+		Segments: make([]Segment, 0, len(speech)),
+		Duration: SamplesToCs(len(samples)),
+	}
+	var previous string // text decoded so far, for CarryPrompt
+	decode := func(w, i int) ([]Segment, error) {
+		start, end := bounds(speech[i])
 		if end <= start {
-			continue
+			return nil, nil
 		}
-
-		ret := C.whisper_full(c.ctx, params, (*C.float)(&samples[start]), C.int(end-start))
-		if ret != 0 {
-			return TranscribeResult{}, fmt.Errorf("whisper: transcription failed with code %d", ret)
+		worker := workers[w]
+		params := params
+		if opts.CarryPrompt {
+			if prompt := carryPrompt(opts.Prompt, previous); prompt != "" {
+				cPrompt := C.CString(prompt)
+				defer C.free(unsafe.Pointer(cPrompt))
+				params.initial_prompt = cPrompt
+			}
 		}
-
-		decoded := collectSegments(c.ctx)
-		for _, seg := range decoded {
-			shifted := seg
-			shifted.Start += t0cs
-			shifted.End += t0cs
-			result.Segments = append(result.Segments, shifted)
-			if cb.OnSegment != nil {
-				cb.OnSegment(shifted)
+		if ret := worker.full(params, samples[start:end]); ret != 0 {
+			return nil, fmt.Errorf("whisper: transcription failed with code %d", ret)
+		}
+		if i == first {
+			result.Language = worker.language()
+			if autoLanguage(opts) {
+				if _, probs, err := worker.languageProbs(opts.Threads); err == nil {
+					result.LanguageProbs = probs
+				}
 			}
 		}
-
-		if cb.OnProgress != nil {
-			cb.OnProgress((i + 1) * 100 / nVadSegments)
+		segments := worker.collectSegments()
+		for j := range segments {
+			segments[j] = segments[j].Shift(speech[i].Start)
 		}
+		return segments, nil
+	}
+	emit := func(i int, segments []Segment) {
+		result.Segments = append(result.Segments, segments...)
+		if opts.CarryPrompt && len(segments) > 0 {
+			previous = carryPrompt("", previous+" "+TranscribeResult{Segments: segments}.Text())
+		}
+		if cb.OnSegment != nil {
+			for _, seg := range segments {
+				cb.OnSegment(seg)
+			}
+		}
+	}
+	if err := decodeInOrder(len(speech), len(workers), decode, emit, cb.OnProgress, cb.ShouldAbort); err != nil {
+		return TranscribeResult{}, err
 	}
-
 	return result, nil
 }
 